@@ -12,6 +12,8 @@
 //   		上层模块在对数据项进行任何修改之前, 都必须调用d.Before(), 如果想撤销修改, 则再调用
 //		d.UnBefore(). 修改完成后, 还必须调用d.After(xid).
 //		DM会保证对Dataitem的修改是原子性的.
+//		如果上层模块已经通过d.Lock()持有了写锁, 则在修改之前调用d.BeforeLocked(),
+//		修改完成后调用d.AfterLocked(xid), 两者都不会再加锁或解锁.
 //
 //	数据项释放协议:
 //		上层模块不用数据项时, 必须调用d.Release()来将其释放
@@ -32,6 +34,8 @@ type DataItem interface {
 	Before()
 	UnBefore()
 	After(xid tm.TransactionID)
+	BeforeLocked()
+	AfterLocked(xid tm.TransactionID)
 	Release()
 
 	// 下面是DM为上层模块提供的针对DataItem的锁操作.
@@ -114,17 +118,23 @@ func (di *dataItem) UUID() utils.UUID {
 }
 func (di *dataItem) Before() {
 	di.rwlock.Lock()
-	di.pageCacher.Dirty()
-	copy(di.oldraw, di.raw)
+	di.BeforeLocked()
 }
 func (di *dataItem) UnBefore() {
 	copy(di.raw, di.oldraw)
 	di.rwlock.Unlock()
 }
 func (di *dataItem) After(xid tm.TransactionID) {
-	di.dataManager.logDataitem(xid, di)
+	di.AfterLocked(xid)
 	di.rwlock.Unlock()
 }
+func (di *dataItem) BeforeLocked() {
+	di.pageCacher.Dirty()
+	copy(di.oldraw, di.raw)
+}
+func (di *dataItem) AfterLocked(xid tm.TransactionID) {
+	di.dataManager.logDataitem(xid, di)
+}
 func (di *dataItem) Release() {
 	di.dataManager.ReleaseDataitem(di)
 }
@@ -11,7 +11,10 @@
 */
 package page_cacher
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 type Page interface {
 	PageNum() PageNum // 取得页号
@@ -26,7 +29,7 @@ type Page interface {
 type page struct {
 	pageNum PageNum
 	data    []byte
-	dirty   bool
+	dirty   int32 // 不为0时表示脏页, 多个dataitem可能并发地将同一页设为脏页
 	lock    sync.Mutex
 
 	pageCacher *pageCacher
@@ -53,7 +56,7 @@ func (p *page) Release() {
 }
 
 func (p *page) Dirty() {
-	p.dirty = true
+	atomic.StoreInt32(&p.dirty, 1)
 }
 
 func (p *page) PageNum() PageNum {
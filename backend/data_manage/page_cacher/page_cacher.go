@@ -143,9 +143,8 @@ func (p *pageCacher) getForCacher(uid utils.UUID) (interface{}, error) {
 // release 释放掉该页的内容, 也就是刷新该页, 然后从内存中释放掉.
 func (p *pageCacher) releaseForCacher(underlying interface{}) {
 	pg := underlying.(*page)
-	if pg.dirty == true {
+	if atomic.SwapInt32(&pg.dirty, 0) != 0 {
 		p.flush(pg)
-		pg.dirty = false
 	}
 }
 
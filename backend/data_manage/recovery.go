@@ -179,7 +179,7 @@ func doUpdateLog(pc page_cacher.PageCacher, log []byte, flag int) {
    表示XID将Raw的内容插入到了PageNum页的Offset位移处.
 */
 func InsertLog(xid tm.TransactionID, pg page_cacher.Page, raw []byte) []byte {
-	log := make([]byte, 1+tm.LEN_TRANSACTION_ID+page_cacher.LEN_PGNO+2+len(raw))
+	log := make([]byte, 1+tm.LEN_TRANSACTION_ID+page_cacher.LEN_PGNO+LEN_OFFSET+len(raw))
 	pos := 0
 	log[pos] = _LOG_TYPE_INSERT
 	pos++
@@ -0,0 +1,124 @@
+/*
+	bulk.go 实现了B+树的批量加载.
+
+	逐个插入时, 每个key都要从根节点向下查找, 节点一次只分裂一个, 每次修改都会记录一条update日志.
+	批量加载先将所有的(key, uuid)排序, 再从叶节点开始逐层向上构造整棵树:
+	每一层的节点依次填充到fillFactor * _NODE_SIZE, 上一层为每个节点记录一个(uuid, 上界),
+	直到某一层只有一个节点, 它即为新的根节点. 每个节点只被写入一次, 只记录一条insert日志.
+	fillFactor小于1时, 节点中留下的空间可以容纳之后的插入, 而不会立即分裂.
+
+	批量加载会替换整棵树, 原有的节点不再被引用, 和被删除的索引一样, 它们的空间不会被释放.
+	调用者需要保证加载期间没有其他对该树的读写.
+*/
+package index_manage
+
+import (
+	tm "fansDB/backend/transaction_manage"
+	"fansDB/backend/utils"
+	"sort"
+)
+
+const (
+	DEFAULT_FILL_FACTOR = 0.9 // 批量加载时节点默认的填充率
+	MIN_FILL_FACTOR     = 0.5 // 填充率的下限, 保证每个节点至少有两个entry, 每一层的节点数都比下一层少
+)
+
+// Entry 为批量加载时的一个(key, uuid)
+type Entry struct {
+	Key  []byte
+	UUID utils.UUID
+}
+
+// BulkLoad 将entries和树中原有的entry排序后, 自底向上地构造一棵新的树, 并替换原来的根节点
+func (bt *bPlusTree) BulkLoad(entries []Entry, fillFactor float64) error {
+	if fillFactor < MIN_FILL_FACTOR || fillFactor > 1 {
+		return ErrFillFactor
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	level := make([]nodeEntry, 0, len(entries))
+	for _, e := range entries {
+		if bt.validKey(e.Key) == false {
+			return ErrInvalidKey
+		}
+		level = append(level, nodeEntry{e.UUID, e.Key})
+	}
+	err := bt.ScanKey(nil, nil, false, func(key []byte, uuid utils.UUID) (bool, error) {
+		level = append(level, nodeEntry{uuid, key})
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(level, func(i, j int) bool {
+		return bt.compare(level[i].key, level[j].key) < 0
+	})
+
+	capacity := _NODE_HEADER_SIZE + int(float64(_NODE_SIZE-_NODE_HEADER_SIZE)*fillFactor)
+	isLeaf := true
+	for {
+		groups := bt.packEntries(level, capacity)
+		level, err = bt.writeLevel(groups, isLeaf)
+		if err != nil {
+			return err
+		}
+		if len(level) == 1 {
+			break
+		}
+		isLeaf = false
+	}
+
+	bt.bootLock.Lock()
+	defer bt.bootLock.Unlock()
+	bt.setRootUUID(level[0].son)
+	return nil
+}
+
+// packEntries 将一层的entries依次分为若干组, 每组的大小不超过capacity, 且至少有一个entry.
+// 最后一组过小时, 与前一组合并, 放不下则将两组平分.
+func (bt *bPlusTree) packEntries(entries []nodeEntry, capacity int) [][]nodeEntry {
+	var groups [][]nodeEntry
+	start, size := 0, _NODE_HEADER_SIZE
+	for i, e := range entries {
+		esize := entrySize(bt.varKey, e.key)
+		if i > start && size+esize > capacity {
+			groups = append(groups, entries[start:i])
+			start, size = i, _NODE_HEADER_SIZE
+		}
+		size += esize
+	}
+	groups = append(groups, entries[start:])
+
+	last := len(groups) - 1
+	if last > 0 && entriesSize(bt.varKey, groups[last]) < _MIN_NODE_SIZE {
+		merged := append(append([]nodeEntry(nil), groups[last-1]...), groups[last]...)
+		if entriesSize(bt.varKey, merged) <= _NODE_SIZE {
+			groups = append(groups[:last-1], merged)
+		} else {
+			mid := splitPoint(bt.varKey, merged)
+			groups[last-1], groups[last] = merged[:mid], merged[mid:]
+		}
+	}
+	return groups
+}
+
+// writeLevel 将一层的各组entry写入为节点, 从右向左写入, 以便设置sibling.
+// 返回上一层的entry, 即各个节点的uuid和上界, 最右边的节点的上界为INF.
+func (bt *bPlusTree) writeLevel(groups [][]nodeEntry, isLeaf bool) ([]nodeEntry, error) {
+	parents := make([]nodeEntry, len(groups))
+	sibling := utils.NilUUID
+	for i := len(groups) - 1; i >= 0; i-- {
+		group := groups[i]
+		raw := newNodeRaw(isLeaf, bt.varKey, sibling, group)
+		uuid, err := bt.DataManager.Insert(tm.SUPER_TRANSACTION_ID, raw)
+		if err != nil {
+			return nil, err
+		}
+		parents[i] = nodeEntry{uuid, group[len(group)-1].key}
+		sibling = uuid
+	}
+	parents[len(parents)-1].key = nil
+	return parents, nil
+}
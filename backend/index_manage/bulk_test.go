@@ -0,0 +1,119 @@
+package index_manage
+
+import (
+	"fansDB/backend/utils"
+	"fmt"
+	"testing"
+)
+
+// bulkEntries 返回fillTree插入的n个entry, 顺序被打乱
+func bulkEntries(n int) []Entry {
+	entries := make([]Entry, n)
+	for i := 0; i < n; i++ {
+		j := (i * 7919) % n
+		entries[i] = Entry{[]byte(fmt.Sprintf("key%06d", j)), utils.UUID(j + 1)}
+	}
+	return entries
+}
+
+// TestBulkLoadFillFactor 检查批量加载得到的树与逐个插入的树有相同的entry, 结构正确,
+// 且叶节点数随fillFactor减小而增加. 顺序插入时分裂的节点只有一半满, 默认的fillFactor应当得到更少的叶节点.
+func TestBulkLoadFillFactor(t *testing.T) {
+	const n = 3000
+	dmr := openTestDM(t)
+	incremental := newTestTree(t, dmr)
+	fillTree(t, incremental, n)
+	expected := scanEntries(t, incremental, nil, nil, false)
+	incrementalLeaves := mustVerify(t, incremental).Leaves
+	leaves := n + 1
+
+	for _, fillFactor := range []float64{MIN_FILL_FACTOR, 0.7, DEFAULT_FILL_FACTOR, 1} {
+		bt := newTestTree(t, dmr)
+		if err := bt.BulkLoad(bulkEntries(n), fillFactor); err != nil {
+			t.Fatal(err)
+		}
+		result := mustVerify(t, bt)
+		if len(result.Violations) > 0 {
+			t.Fatalf("fill factor %g: got violations %v", fillFactor, result.Violations)
+		}
+		if equalEntries(scanEntries(t, bt, nil, nil, false), expected) == false {
+			t.Errorf("fill factor %g: entries differ from incremental inserts", fillFactor)
+		}
+		if result.Leaves >= leaves {
+			t.Errorf("fill factor %g: got %d leaves, expected less than %d", fillFactor, result.Leaves, leaves)
+		}
+		if fillFactor >= DEFAULT_FILL_FACTOR && result.Leaves*3 > incrementalLeaves*2 {
+			t.Errorf("fill factor %g: got %d leaves, %d by incremental inserts", fillFactor, result.Leaves, incrementalLeaves)
+		}
+		leaves = result.Leaves
+		if m := underflowNodes(t, bt); m > 0 {
+			t.Errorf("fill factor %g: %d nodes underflow", fillFactor, m)
+		}
+	}
+
+	bt := newTestTree(t, dmr)
+	for _, fillFactor := range []float64{MIN_FILL_FACTOR - 0.1, 1.1} {
+		if err := bt.BulkLoad(bulkEntries(10), fillFactor); err != ErrFillFactor {
+			t.Errorf("fill factor %g: got %v, expected %v", fillFactor, err, ErrFillFactor)
+		}
+	}
+	if err := bt.BulkLoad([]Entry{{make([]byte, MAX_KEY_SIZE+1), 1}}, 1); err != ErrInvalidKey {
+		t.Errorf("long key: got %v, expected %v", err, ErrInvalidKey)
+	}
+}
+
+// TestBulkLoadFreeSpace 检查fillFactor小于1时留下的空间可以容纳之后的插入, 节点不会立即分裂,
+// 而填满的节点在插入时分裂.
+func TestBulkLoadFreeSpace(t *testing.T) {
+	const n = 3000
+	dmr := openTestDM(t)
+	for _, fillFactor := range []float64{DEFAULT_FILL_FACTOR, 1} {
+		bt := newTestTree(t, dmr)
+		if err := bt.BulkLoad(bulkEntries(n), fillFactor); err != nil {
+			t.Fatal(err)
+		}
+		before := mustVerify(t, bt)
+		for i := 0; i < n; i += n / before.Leaves * 2 {
+			if err := bt.InsertKey([]byte(fmt.Sprintf("key%06dx", i)), utils.UUID(n+i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		after := mustVerify(t, bt)
+		if len(after.Violations) > 0 {
+			t.Fatalf("fill factor %g: got violations %v", fillFactor, after.Violations)
+		}
+		if fillFactor < 1 && after.Leaves != before.Leaves {
+			t.Errorf("fill factor %g: leaves grew from %d to %d", fillFactor, before.Leaves, after.Leaves)
+		}
+		if fillFactor == 1 && after.Leaves == before.Leaves {
+			t.Errorf("fill factor %g: full leaves did not split", fillFactor)
+		}
+	}
+}
+
+// TestBulkLoadExisting 检查批量加载时树中原有的entry被保留, 和新的entry一起排序
+func TestBulkLoadExisting(t *testing.T) {
+	bt := newTestTree(t, openTestDM(t))
+	fillTree(t, bt, 1000)
+	var entries []Entry
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, Entry{[]byte(fmt.Sprintf("key%06dx", i)), utils.UUID(5000 + i)})
+	}
+	if err := bt.BulkLoad(entries, DEFAULT_FILL_FACTOR); err != nil {
+		t.Fatal(err)
+	}
+
+	result := mustVerify(t, bt)
+	if len(result.Violations) > 0 || result.Entries != 2000 {
+		t.Fatalf("got %d entries, violations %v", result.Entries, result.Violations)
+	}
+	for i, e := range scanEntries(t, bt, nil, nil, false) {
+		expected := Entry{[]byte(fmt.Sprintf("key%06d", i/2)), utils.UUID(i/2 + 1)}
+		if i%2 == 1 {
+			expected = entries[i/2]
+		}
+		if equalEntries([]Entry{e}, []Entry{expected}) == false {
+			t.Fatalf("entry %d: got (%s, %d), expected (%s, %d)", i, e.Key, e.UUID, expected.Key, expected.UUID)
+		}
+	}
+}
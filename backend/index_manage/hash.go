@@ -0,0 +1,609 @@
+/*
+	hash.go 实现了线性hash(linear hashing)索引, 它和B+树一样以utils.UUID为key, 实现了Index.
+
+	key先经过hashKey打散, 索引中有n = 2^level + next个bucket, hash值为h的key所在的bucket为
+	h mod 2^level, 如果它小于next, 说明该bucket已经被分裂, 则为h mod 2^(level+1).
+	每个bucket是一条链, 第一个bucket满了之后, 通过overflow链接溢出的bucket.
+	每当插入时追加了一个溢出的bucket, 就分裂第next个bucket: 将其中的entry按h mod 2^(level+1)分到它和第next+2^level个bucket中,
+	然后next加一, next达到2^level时level加一, next回到0. 所以bucket的数目随entry的数目线性增长,
+	等值查找只需要读出一个槽和一条链, 不受索引大小的影响.
+	相同的key总是在同一条链中, 大量重复的key只会使它们所在的链变长, 而不会使其他部分变大.
+
+	槽i中存放第i个bucket的链的第一个bucket的uuid, 槽存放在若干个chunk中, 每个chunk存放_HASH_CHUNK_SLOTS个槽,
+	根存放level, next和各个chunk的uuid, 在创建时就分配了最大的大小, 所以分裂时只需要在需要时新建chunk, 并原地修改根.
+	bucket达到_HASH_MAX_BUCKETS之后不再分裂, 之后的entry都放入溢出的bucket中. 该索引的uuid即为根的uuid.
+
+	并发控制: dirLock保护根和所有的槽, 查找, 删除和插入持有它的读锁, 再通过bucket的dataitem的锁读写bucket;
+	第一个bucket已满时, 插入改为持有它的写锁, 此时没有其他对该索引的读写, 追加溢出的bucket和分裂都在此时进行.
+	删除不会合并bucket, 空的bucket也不会被释放, 和B+树的节点一样, DM不支持回收空间.
+*/
+package index_manage
+
+import (
+	dm "fansDB/backend/data_manage"
+	tm "fansDB/backend/transaction_manage"
+	"fansDB/backend/utils"
+	"sort"
+	"sync"
+)
+
+const (
+	_HASH_LEVEL_OFFSET  = 0                      // 根: level, 1字节
+	_HASH_NEXT_OFFSET   = _HASH_LEVEL_OFFSET + 1 // 根: next, 4字节
+	_HASH_CHUNKS_OFFSET = _HASH_NEXT_OFFSET + 4  // 根: 各个chunk的uuid
+	_HASH_CHUNK_SLOTS   = 256                    // 每个chunk中的槽数
+	_HASH_MAX_CHUNKS    = 512                    // 根中最多能存放的chunk数
+	_HASH_MAX_BUCKETS   = _HASH_CHUNK_SLOTS * _HASH_MAX_CHUNKS
+	_HASH_ROOT_SIZE     = _HASH_CHUNKS_OFFSET + utils.LEN_UUID*_HASH_MAX_CHUNKS
+	_HASH_CHUNK_SIZE    = utils.LEN_UUID * _HASH_CHUNK_SLOTS
+
+	_BUCKET_COUNT_OFFSET    = 0                        // entry数目, 2字节
+	_BUCKET_OVERFLOW_OFFSET = _BUCKET_COUNT_OFFSET + 2 // 溢出的bucket的uuid
+	_BUCKET_HEADER_SIZE     = _BUCKET_OVERFLOW_OFFSET + utils.LEN_UUID
+	_BUCKET_CAPACITY        = 64 // 每个bucket最多容纳的entry数
+	_BUCKET_ENTRY_SIZE      = 2 * utils.LEN_UUID
+	_BUCKET_SIZE            = _BUCKET_HEADER_SIZE + _BUCKET_ENTRY_SIZE*_BUCKET_CAPACITY
+)
+
+/**
+ * 根的结构:    [level] 1字节, [next] 4字节, [chunk0 uuid] ... [chunk511 uuid]
+ * chunk的结构:  [slot0 bucket uuid] ... [slot255 bucket uuid]
+ * bucket的结构: [no of entries] 2字节, [overflow uuid] 8字节, [key0][uuid0] ... [key63][uuid63]
+ * 根中只有前(n + _HASH_CHUNK_SLOTS - 1) / _HASH_CHUNK_SLOTS个chunk被使用.
+ */
+type hashIndex struct {
+	rootUUID     utils.UUID
+	rootDataItem dm.DataItem  // 根, 一直被持有
+	dirLock      sync.RWMutex // 根和槽的锁, 见hash.go
+
+	DataManager dm.DataManager
+}
+
+// hashEntry 为bucket中的一个(key, uuid)
+type hashEntry struct {
+	key  utils.UUID
+	uuid utils.UUID
+}
+
+// CreateHash 创建一个只有一个空bucket的hash索引, 并返回其uuid
+func CreateHash(dm dm.DataManager) (utils.UUID, error) {
+	bucketUUID, err := dm.Insert(tm.SUPER_TRANSACTION_ID, newBucketRaw(utils.NilUUID, nil))
+	if err != nil {
+		return utils.NilUUID, err
+	}
+	chunk := make([]byte, _HASH_CHUNK_SIZE)
+	utils.PutUUID(chunk, bucketUUID)
+	chunkUUID, err := dm.Insert(tm.SUPER_TRANSACTION_ID, chunk)
+	if err != nil {
+		return utils.NilUUID, err
+	}
+	root := make([]byte, _HASH_ROOT_SIZE)
+	utils.PutUUID(root[_HASH_CHUNKS_OFFSET:], chunkUUID)
+	return dm.Insert(tm.SUPER_TRANSACTION_ID, root)
+}
+
+// LoadHash 通过CreateHash返回的uuid读取hash索引
+func LoadHash(rootUUID utils.UUID, dm dm.DataManager) (Index, error) {
+	rootItem, ok, err := dm.Read(rootUUID)
+	if err != nil {
+		return nil, err
+	}
+	utils.Assert(ok)
+
+	h := &hashIndex{
+		rootUUID:     rootUUID,
+		rootDataItem: rootItem,
+		DataManager:  dm,
+	}
+	if len(rootItem.Data()) != _HASH_ROOT_SIZE || h.buckets() > _HASH_MAX_BUCKETS {
+		rootItem.Release()
+		return nil, ErrInvalidHash
+	}
+	return h, nil
+}
+
+// hashKey 将key打散, 使得相近的key的低位也各不相同(MurmurHash3的fmix64)
+func hashKey(key utils.UUID) uint64 {
+	h := uint64(key)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb3f97a4fe63b
+	h ^= h >> 33
+	return h
+}
+
+func newBucketRaw(overflow utils.UUID, entries []hashEntry) []byte {
+	raw := make([]byte, _BUCKET_SIZE)
+	setBucketOverflow(raw, overflow)
+	setBucketEntries(raw, entries)
+	return raw
+}
+
+func getBucketOverflow(raw []byte) utils.UUID {
+	return utils.ParseUUID(raw[_BUCKET_OVERFLOW_OFFSET:])
+}
+
+func setBucketOverflow(raw []byte, overflow utils.UUID) {
+	utils.PutUUID(raw[_BUCKET_OVERFLOW_OFFSET:], overflow)
+}
+
+func getBucketCount(raw []byte) int {
+	return int(utils.ParseUint16(raw[_BUCKET_COUNT_OFFSET:]))
+}
+
+func setBucketCount(raw []byte, n int) {
+	utils.PutUint16(raw[_BUCKET_COUNT_OFFSET:], uint16(n))
+}
+
+func getBucketEntries(raw []byte) []hashEntry {
+	entries := make([]hashEntry, getBucketCount(raw))
+	for i := range entries {
+		pos := _BUCKET_HEADER_SIZE + i*_BUCKET_ENTRY_SIZE
+		entries[i].key = utils.ParseUUID(raw[pos:])
+		entries[i].uuid = utils.ParseUUID(raw[pos+utils.LEN_UUID:])
+	}
+	return entries
+}
+
+// setBucketEntries 将bucket的内容设置为entries, 调用者保证其数目不超过_BUCKET_CAPACITY
+func setBucketEntries(raw []byte, entries []hashEntry) {
+	setBucketCount(raw, len(entries))
+	for i, e := range entries {
+		setBucketEntry(raw, i, e)
+	}
+}
+
+func setBucketEntry(raw []byte, i int, e hashEntry) {
+	pos := _BUCKET_HEADER_SIZE + i*_BUCKET_ENTRY_SIZE
+	utils.PutUUID(raw[pos:], e.key)
+	utils.PutUUID(raw[pos+utils.LEN_UUID:], e.uuid)
+}
+
+// appendBucketEntry 在持有bucket b的写锁(b.Lock)时, 将e加入其中并记录日志.
+// bucket已满时返回false, 此时b没有被修改.
+func appendBucketEntry(b dm.DataItem, e hashEntry) bool {
+	raw := b.Data()
+	n := getBucketCount(raw)
+	if n >= _BUCKET_CAPACITY {
+		return false
+	}
+	b.BeforeLocked()
+	setBucketEntry(raw, n, e)
+	setBucketCount(raw, n+1)
+	b.AfterLocked(tm.SUPER_TRANSACTION_ID)
+	return true
+}
+
+// readDataItem 读入uuid对应的dataitem
+func (h *hashIndex) readDataItem(uuid utils.UUID) (dm.DataItem, error) {
+	dataItem, ok, err := h.DataManager.Read(uuid)
+	if err != nil {
+		return nil, err
+	}
+	utils.Assert(ok)
+	return dataItem, nil
+}
+
+// 以下方法需要调用者持有dirLock
+
+func (h *hashIndex) level() int {
+	return int(h.rootDataItem.Data()[_HASH_LEVEL_OFFSET])
+}
+
+func (h *hashIndex) next() int {
+	return int(utils.ParseUint32(h.rootDataItem.Data()[_HASH_NEXT_OFFSET:]))
+}
+
+// buckets 返回bucket的数目
+func (h *hashIndex) buckets() int {
+	return 1<<h.level() + h.next()
+}
+
+func (h *hashIndex) chunkUUID(i int) utils.UUID {
+	return utils.ParseUUID(h.rootDataItem.Data()[_HASH_CHUNKS_OFFSET+i*utils.LEN_UUID:])
+}
+
+// bucketOf 返回hash值所在的bucket的编号
+func (h *hashIndex) bucketOf(hash uint64) int {
+	i := int(hash & (1<<h.level() - 1))
+	if i < h.next() {
+		i = int(hash & (1<<(h.level()+1) - 1))
+	}
+	return i
+}
+
+// readSlot 返回第i个bucket的链的第一个bucket
+func (h *hashIndex) readSlot(i int) (utils.UUID, error) {
+	chunk, err := h.readDataItem(h.chunkUUID(i / _HASH_CHUNK_SLOTS))
+	if err != nil {
+		return utils.NilUUID, err
+	}
+	defer chunk.Release()
+	return utils.ParseUUID(chunk.Data()[(i%_HASH_CHUNK_SLOTS)*utils.LEN_UUID:]), nil
+}
+
+// writeSlot 将第i个槽设置为uuid
+func (h *hashIndex) writeSlot(i int, uuid utils.UUID) error {
+	chunk, err := h.readDataItem(h.chunkUUID(i / _HASH_CHUNK_SLOTS))
+	if err != nil {
+		return err
+	}
+	defer chunk.Release()
+	chunk.Before()
+	utils.PutUUID(chunk.Data()[(i%_HASH_CHUNK_SLOTS)*utils.LEN_UUID:], uuid)
+	chunk.After(tm.SUPER_TRANSACTION_ID)
+	return nil
+}
+
+// readChain 以读锁依次读出从uuid开始的一条链上所有的entry
+func (h *hashIndex) readChain(uuid utils.UUID) ([]hashEntry, error) {
+	var entries []hashEntry
+	for uuid != utils.NilUUID {
+		b, err := h.readDataItem(uuid)
+		if err != nil {
+			return nil, err
+		}
+		b.RLock()
+		entries = append(entries, getBucketEntries(b.Data())...)
+		uuid = getBucketOverflow(b.Data())
+		b.RUnlock()
+		b.Release()
+	}
+	return entries, nil
+}
+
+func (h *hashIndex) Insert(key, uuid utils.UUID) error {
+	hash := hashKey(key)
+	e := hashEntry{key, uuid}
+
+	h.dirLock.RLock()
+	inserted, err := h.insertFirst(hash, e)
+	h.dirLock.RUnlock()
+	if err != nil || inserted {
+		return err
+	}
+
+	h.dirLock.Lock()
+	defer h.dirLock.Unlock()
+	overflowed, err := h.insertChain(hash, e)
+	if err != nil || overflowed == false {
+		return err
+	}
+	if h.buckets() >= _HASH_MAX_BUCKETS {
+		return nil
+	}
+	return h.split()
+}
+
+// insertFirst 在持有dirLock的读锁时, 将e插入其所在的链的第一个bucket, 该bucket已满时返回false
+func (h *hashIndex) insertFirst(hash uint64, e hashEntry) (bool, error) {
+	first, err := h.readSlot(h.bucketOf(hash))
+	if err != nil {
+		return false, err
+	}
+	b, err := h.readDataItem(first)
+	if err != nil {
+		return false, err
+	}
+	defer b.Release()
+	b.Lock()
+	defer b.Unlock()
+	return appendBucketEntry(b, e), nil
+}
+
+// insertChain 在持有dirLock的写锁时, 将e插入其所在的链中第一个未满的bucket,
+// 都已满时在链的末尾追加一个溢出的bucket, 并返回true.
+func (h *hashIndex) insertChain(hash uint64, e hashEntry) (bool, error) {
+	uuid, err := h.readSlot(h.bucketOf(hash))
+	if err != nil {
+		return false, err
+	}
+	for {
+		b, err := h.readDataItem(uuid)
+		if err != nil {
+			return false, err
+		}
+		b.Lock()
+		if appendBucketEntry(b, e) {
+			b.Unlock()
+			b.Release()
+			return false, nil
+		}
+		next := getBucketOverflow(b.Data())
+		if next == utils.NilUUID {
+			overflow, err := h.DataManager.Insert(tm.SUPER_TRANSACTION_ID, newBucketRaw(utils.NilUUID, []hashEntry{e}))
+			if err == nil {
+				b.BeforeLocked()
+				setBucketOverflow(b.Data(), overflow)
+				b.AfterLocked(tm.SUPER_TRANSACTION_ID)
+			}
+			b.Unlock()
+			b.Release()
+			return err == nil, err
+		}
+		b.Unlock()
+		b.Release()
+		uuid = next
+	}
+}
+
+// split 在持有dirLock的写锁时, 将第next个bucket分裂为它和第next+2^level个bucket
+func (h *hashIndex) split() error {
+	level, next := h.level(), h.next()
+	target := next + 1<<level
+	if target%_HASH_CHUNK_SLOTS == 0 { // 需要一个新的chunk
+		chunk, err := h.DataManager.Insert(tm.SUPER_TRANSACTION_ID, make([]byte, _HASH_CHUNK_SIZE))
+		if err != nil {
+			return err
+		}
+		h.rootDataItem.Before()
+		utils.PutUUID(h.rootDataItem.Data()[_HASH_CHUNKS_OFFSET+target/_HASH_CHUNK_SLOTS*utils.LEN_UUID:], chunk)
+		h.rootDataItem.After(tm.SUPER_TRANSACTION_ID)
+	}
+
+	first, err := h.readSlot(next)
+	if err != nil {
+		return err
+	}
+	entries, err := h.readChain(first)
+	if err != nil {
+		return err
+	}
+	var stay, move []hashEntry
+	for _, e := range entries {
+		if int(hashKey(e.key)&(1<<(level+1)-1)) == next {
+			stay = append(stay, e)
+		} else {
+			move = append(move, e)
+		}
+	}
+
+	moved, err := h.writeChain(move)
+	if err != nil {
+		return err
+	}
+	err = h.writeSlot(target, moved)
+	if err != nil {
+		return err
+	}
+	err = h.rewriteChain(first, stay)
+	if err != nil {
+		return err
+	}
+
+	next++
+	if next == 1<<level {
+		level, next = level+1, 0
+	}
+	h.rootDataItem.Before()
+	raw := h.rootDataItem.Data()
+	raw[_HASH_LEVEL_OFFSET] = byte(level)
+	utils.PutUint32(raw[_HASH_NEXT_OFFSET:], uint32(next))
+	h.rootDataItem.After(tm.SUPER_TRANSACTION_ID)
+	return nil
+}
+
+// writeChain 将entries写入一条新的链, 返回其第一个bucket的uuid. entries为空时也写入一个空的bucket.
+func (h *hashIndex) writeChain(entries []hashEntry) (utils.UUID, error) {
+	next := utils.NilUUID
+	end := len(entries)
+	for {
+		start := (end - 1) / _BUCKET_CAPACITY * _BUCKET_CAPACITY
+		uuid, err := h.DataManager.Insert(tm.SUPER_TRANSACTION_ID, newBucketRaw(next, entries[start:end]))
+		if err != nil {
+			return utils.NilUUID, err
+		}
+		if start == 0 {
+			return uuid, nil
+		}
+		next, end = uuid, start
+	}
+}
+
+// rewriteChain 将从first开始的链的内容替换为entries, 多余的溢出bucket被丢弃.
+// entries不多于链中原有的entry, 所以链中的bucket足够容纳它们.
+func (h *hashIndex) rewriteChain(first utils.UUID, entries []hashEntry) error {
+	uuid := first
+	for uuid != utils.NilUUID {
+		b, err := h.readDataItem(uuid)
+		if err != nil {
+			return err
+		}
+		b.Before()
+		raw := b.Data()
+		n := len(entries)
+		if n > _BUCKET_CAPACITY {
+			n = _BUCKET_CAPACITY
+		}
+		setBucketEntries(raw, entries[:n])
+		entries = entries[n:]
+		uuid = getBucketOverflow(raw)
+		if len(entries) == 0 {
+			setBucketOverflow(raw, utils.NilUUID)
+			uuid = utils.NilUUID
+		}
+		b.After(tm.SUPER_TRANSACTION_ID)
+		b.Release()
+	}
+	return nil
+}
+
+func (h *hashIndex) Delete(key, uuid utils.UUID) (bool, error) {
+	h.dirLock.RLock()
+	defer h.dirLock.RUnlock()
+	next, err := h.readSlot(h.bucketOf(hashKey(key)))
+	if err != nil {
+		return false, err
+	}
+	for next != utils.NilUUID {
+		b, err := h.readDataItem(next)
+		if err != nil {
+			return false, err
+		}
+		b.Lock()
+		raw := b.Data()
+		entries := getBucketEntries(raw)
+		next = getBucketOverflow(raw)
+		for i, e := range entries {
+			if e.key == key && e.uuid == uuid {
+				last := len(entries) - 1
+				b.BeforeLocked()
+				setBucketEntry(raw, i, entries[last])
+				setBucketCount(raw, last)
+				b.AfterLocked(tm.SUPER_TRANSACTION_ID)
+				b.Unlock()
+				b.Release()
+				return true, nil
+			}
+		}
+		b.Unlock()
+		b.Release()
+	}
+	return false, nil
+}
+
+func (h *hashIndex) Search(key utils.UUID) ([]utils.UUID, error) {
+	h.dirLock.RLock()
+	defer h.dirLock.RUnlock()
+	first, err := h.readSlot(h.bucketOf(hashKey(key)))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := h.readChain(first)
+	if err != nil {
+		return nil, err
+	}
+	var uuids []utils.UUID
+	for _, e := range entries {
+		if e.key == key {
+			uuids = append(uuids, e.uuid)
+		}
+	}
+	return uuids, nil
+}
+
+// searchRange 返回[leftKey, rightKey]中所有的entry, 按key排序, 相同的key之间保持在链中的顺序.
+// leftKey等于rightKey时只读出一条链, 否则读出整个索引.
+func (h *hashIndex) searchRange(leftKey, rightKey utils.UUID) ([]Entry, error) {
+	var result []Entry
+	if leftKey == rightKey {
+		uuids, err := h.Search(leftKey)
+		if err != nil {
+			return nil, err
+		}
+		for _, uuid := range uuids {
+			result = append(result, Entry{UUIDToKey(leftKey), uuid})
+		}
+		return result, nil
+	}
+
+	var entries []hashEntry
+	h.dirLock.RLock()
+	for i := 0; i < h.buckets(); i++ {
+		first, err := h.readSlot(i)
+		if err != nil {
+			h.dirLock.RUnlock()
+			return nil, err
+		}
+		chain, err := h.readChain(first)
+		if err != nil {
+			h.dirLock.RUnlock()
+			return nil, err
+		}
+		for _, e := range chain {
+			if e.key >= leftKey && e.key <= rightKey {
+				entries = append(entries, e)
+			}
+		}
+	}
+	h.dirLock.RUnlock()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	for _, e := range entries {
+		result = append(result, Entry{UUIDToKey(e.key), e.uuid})
+	}
+	return result, nil
+}
+
+func (h *hashIndex) SearchRange(leftKey, rightKey utils.UUID) ([]utils.UUID, error) {
+	entries, err := h.searchRange(leftKey, rightKey)
+	if err != nil {
+		return nil, err
+	}
+	uuids := make([]utils.UUID, len(entries))
+	for i, e := range entries {
+		uuids[i] = e.UUID
+	}
+	return uuids, nil
+}
+
+func (h *hashIndex) Scan(leftKey, rightKey utils.UUID, desc bool, handle func(key, uuid utils.UUID) (bool, error)) error {
+	it, err := h.Iterate(leftKey, rightKey, desc)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for {
+		key, uuid, ok, err := it.Next()
+		if err != nil || ok == false {
+			return err
+		}
+		goOn, err := handle(KeyToUUID(key), uuid)
+		if err != nil || goOn == false {
+			return err
+		}
+	}
+}
+
+// Iterate 返回遍历[leftKey, rightKey]的迭代器, 它在创建时就读出并排序了区间中所有的entry
+func (h *hashIndex) Iterate(leftKey, rightKey utils.UUID, desc bool) (Iterator, error) {
+	entries, err := h.searchRange(leftKey, rightKey)
+	if err != nil {
+		return nil, err
+	}
+	if desc {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	return &sliceIterator{entries: entries, desc: desc}, nil
+}
+
+// sliceIterator 遍历已经排好序的entries
+type sliceIterator struct {
+	entries []Entry
+	desc    bool
+	pos     int
+	done    bool
+}
+
+func (it *sliceIterator) Next() ([]byte, utils.UUID, bool, error) {
+	if it.done || it.pos >= len(it.entries) {
+		return nil, utils.NilUUID, false, nil
+	}
+	e := it.entries[it.pos]
+	it.pos++
+	return e.Key, e.UUID, true, nil
+}
+
+func (it *sliceIterator) Seek(key []byte) error {
+	if key == nil {
+		it.pos = 0
+		return nil
+	}
+	k := KeyToUUID(key)
+	it.pos = sort.Search(len(it.entries), func(i int) bool {
+		if it.desc {
+			return KeyToUUID(it.entries[i].Key) <= k
+		}
+		return KeyToUUID(it.entries[i].Key) >= k
+	})
+	return nil
+}
+
+func (it *sliceIterator) Close() {
+	it.done = true
+}
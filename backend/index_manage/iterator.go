@@ -0,0 +1,197 @@
+/*
+	iterator.go 实现了B+树上的迭代器, 它按key的顺序逐个返回[leftKey, rightKey]中的(key, uuid),
+	只在需要时才读入下一个叶节点, 所以遍历很大的区间时也不需要把所有的uuid都放在内存中.
+
+	迭代器每次只缓存一个叶节点的内容, 不会在两次Next之间持有节点的latch, 所以遍历期间其他事务可以修改该树.
+
+	正序遍历时, 读出一个叶节点后释放它的latch, 再沿着sibling读入下一个叶节点.
+	分裂和平衡都不会修改被读者引用的节点之外的内容(见bPlusTree.rebalance), 且节点不会被释放,
+	所以从任何一个读到的叶节点向右遍历, 都能读到它之后的所有entry.
+
+	叶节点之间只有向右的指针, 所以逆序遍历时, 每次从根节点重新找到最后一个小于上一批中最小key的entry所在的叶节点.
+	该叶节点中严格位于最小key和最大key之间的key不会出现在其他叶节点中, 而等于两端的key可能跨越多个叶节点,
+	所以两端的key再通过一次正序遍历完整地读出.
+*/
+package index_manage
+
+import (
+	"fansDB/backend/utils"
+)
+
+// Iterator 按key的顺序遍历B+树中的(key, uuid)
+type Iterator interface {
+	// Next 返回下一个(key, uuid), 没有更多的entry时返回false
+	Next() ([]byte, utils.UUID, bool, error)
+	// Seek 移动迭代器, 使得Next从key处开始返回:
+	// 正序时为第一个不小于key的entry, 逆序时为最后一个不大于key的entry. key为nil时回到起点.
+	Seek(key []byte) error
+	// Close 结束遍历, 之后Next总是返回false
+	Close()
+}
+
+type iterator struct {
+	bt                *bPlusTree
+	leftKey, rightKey []byte
+	desc              bool
+
+	entries []nodeEntry // 正序遍历时为当前叶节点的内容, 逆序遍历时为当前一批entry, 已按从大到小排列
+	pos     int         // 下一个返回的entry在entries中的位置
+	sibling utils.UUID  // 正序遍历时下一个叶节点
+
+	upper     []byte // 逆序遍历时, 下一批entry的上界, nil表示INF
+	inclusive bool   // 下一批entry是否可以等于upper
+
+	done bool
+}
+
+// newIterator 创建一个遍历[leftKey, rightKey]的迭代器, desc为true时从大到小遍历
+func newIterator(bt *bPlusTree, leftKey, rightKey []byte, desc bool) (*iterator, error) {
+	it := &iterator{
+		bt:       bt,
+		leftKey:  leftKey,
+		rightKey: rightKey,
+		desc:     desc,
+	}
+	err := it.Seek(nil)
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+func (it *iterator) Seek(key []byte) error {
+	it.done = false
+	it.entries, it.pos, it.sibling = nil, 0, utils.NilUUID
+	if it.desc {
+		if key == nil || it.bt.compare(key, it.rightKey) > 0 {
+			key = it.rightKey
+		}
+		it.upper, it.inclusive = key, true
+		return nil
+	}
+	if key == nil || (it.leftKey != nil && it.bt.compare(key, it.leftKey) < 0) {
+		key = it.leftKey
+	}
+	return it.seekAsc(key)
+}
+
+// seekAsc 找到key所在的叶节点, 并定位到其中第一个不小于key的entry
+func (it *iterator) seekAsc(key []byte) error {
+	entries, sibling, err := it.bt.searchLeaf(key)
+	if err != nil {
+		return err
+	}
+	it.entries, it.sibling = entries, sibling
+	for key != nil && it.pos < len(it.entries) && it.bt.compare(it.entries[it.pos].key, key) < 0 {
+		it.pos++
+	}
+	return nil
+}
+
+func (it *iterator) Next() ([]byte, utils.UUID, bool, error) {
+	if it.done {
+		return nil, utils.NilUUID, false, nil
+	}
+	if it.desc {
+		return it.nextDesc()
+	}
+	return it.nextAsc()
+}
+
+func (it *iterator) nextAsc() ([]byte, utils.UUID, bool, error) {
+	for it.pos == len(it.entries) {
+		if it.sibling == utils.NilUUID {
+			it.done = true
+			return nil, utils.NilUUID, false, nil
+		}
+		entries, sibling, err := it.bt.readLeaf(it.sibling)
+		if err != nil {
+			return nil, utils.NilUUID, false, err
+		}
+		it.entries, it.pos, it.sibling = entries, 0, sibling
+	}
+
+	e := it.entries[it.pos]
+	it.pos++
+	if it.bt.compare(e.key, it.rightKey) > 0 {
+		it.done = true
+		return nil, utils.NilUUID, false, nil
+	}
+	return e.key, e.son, true, nil
+}
+
+func (it *iterator) nextDesc() ([]byte, utils.UUID, bool, error) {
+	for it.pos == len(it.entries) {
+		err := it.loadDescBatch()
+		if err != nil {
+			return nil, utils.NilUUID, false, err
+		}
+		if it.done {
+			return nil, utils.NilUUID, false, nil
+		}
+	}
+
+	e := it.entries[it.pos]
+	it.pos++
+	if it.leftKey != nil && it.bt.compare(e.key, it.leftKey) < 0 {
+		it.done = true
+		return nil, utils.NilUUID, false, nil
+	}
+	return e.key, e.son, true, nil
+}
+
+// loadDescBatch 读入upper之前的一批entry, 即upper之前最后一个entry所在的叶节点中的所有key.
+// 读入后upper被设置为这批entry中最小的key.
+func (it *iterator) loadDescBatch() error {
+	leaf, err := it.bt.searchBefore(it.upper, it.inclusive)
+	if err != nil {
+		return err
+	}
+	if leaf == nil || (it.leftKey != nil && it.bt.compare(leaf[len(leaf)-1].key, it.leftKey) < 0) {
+		it.done = true
+		return nil
+	}
+
+	minKey, maxKey := leaf[0].key, leaf[len(leaf)-1].key
+	batch, err := it.keyGroup(maxKey)
+	if err != nil {
+		return err
+	}
+	if it.bt.compare(minKey, maxKey) < 0 {
+		for i := len(leaf) - 1; i >= 0; i-- {
+			if it.bt.compare(leaf[i].key, minKey) > 0 && it.bt.compare(leaf[i].key, maxKey) < 0 {
+				batch = append(batch, leaf[i])
+			}
+		}
+		group, err := it.keyGroup(minKey)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, group...)
+	}
+
+	it.entries, it.pos = batch, 0
+	it.upper, it.inclusive = minKey, false
+	return nil
+}
+
+// keyGroup 读出所有等于key的entry, 按逆序排列
+func (it *iterator) keyGroup(key []byte) ([]nodeEntry, error) {
+	var group []nodeEntry
+	err := it.bt.ScanKey(key, key, false, func(k []byte, uuid utils.UUID) (bool, error) {
+		group = append(group, nodeEntry{uuid, k})
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(group)-1; i < j; i, j = i+1, j-1 {
+		group[i], group[j] = group[j], group[i]
+	}
+	return group, nil
+}
+
+func (it *iterator) Close() {
+	it.done = true
+	it.entries = nil
+}
@@ -0,0 +1,139 @@
+package index_manage
+
+import (
+	"bytes"
+	"fansDB/backend/utils"
+	"testing"
+)
+
+// iterateEntries 读出迭代器中剩下的所有entry
+func iterateEntries(t *testing.T, it Iterator) []Entry {
+	var entries []Entry
+	for {
+		key, uuid, ok, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok == false {
+			return entries
+		}
+		entries = append(entries, Entry{key, uuid})
+	}
+}
+
+// equalEntries 判断a和b是否相同
+func equalEntries(a, b []Entry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if bytes.Equal(a[i].Key, b[i].Key) == false || a[i].UUID != b[i].UUID {
+			return false
+		}
+	}
+	return true
+}
+
+// TestIterateDesc 检查逆序的迭代器返回的entry恰好是正序的逆序, 包括跨越多个叶节点的相同key
+func TestIterateDesc(t *testing.T) {
+	bt := newTestTree(t, openTestDM(t))
+	fillTree(t, bt, 500)
+	dup := []byte("key000250x")
+	for i := 0; i < 600; i++ {
+		if err := bt.InsertKey(dup, utils.UUID(10000+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ranges := []struct {
+		left, right string
+	}{
+		{"", ""},
+		{"key000100", "key000300"},
+		{"key000250x", "key000250x"},
+		{"key000250x", "key000499"},
+		{"key000250", "key000251"},
+		{"key0001005", "key0001995"},
+		{"", "key000000"},
+		{"key000499", ""},
+		{"key999", ""},
+		{"a", "b"},
+	}
+	for _, r := range ranges {
+		var left, right []byte
+		if r.left != "" {
+			left = []byte(r.left)
+		}
+		if r.right != "" {
+			right = []byte(r.right)
+		}
+		expected := scanEntries(t, bt, left, right, false)
+		for i, j := 0, len(expected)-1; i < j; i, j = i+1, j-1 {
+			expected[i], expected[j] = expected[j], expected[i]
+		}
+
+		it, err := bt.IterateKey(left, right, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := iterateEntries(t, it)
+		if equalEntries(got, expected) == false {
+			t.Errorf("[%s, %s]: got %d entries, expected %d in reverse order", r.left, r.right, len(got), len(expected))
+		}
+		if desc := scanEntries(t, bt, left, right, true); equalEntries(desc, expected) == false {
+			t.Errorf("[%s, %s]: ScanKey got %d entries, expected %d in reverse order", r.left, r.right, len(desc), len(expected))
+		}
+		it.Close()
+	}
+}
+
+// TestIteratorSeek 检查Seek之后, 正序从第一个不小于key的entry开始, 逆序从最后一个不大于key的entry开始,
+// Seek(nil)回到起点, Close之后Next总是返回false.
+func TestIteratorSeek(t *testing.T) {
+	bt := newTestTree(t, openTestDM(t))
+	fillTree(t, bt, 1000)
+
+	tests := []struct {
+		desc     bool
+		seek     string
+		expected utils.UUID // Seek之后第一个entry的uuid, 0表示没有
+	}{
+		{false, "key000500", 501},
+		{false, "key0005005", 502},
+		{false, "key000001", 101}, // 在leftKey之前, 从leftKey开始
+		{false, "key000900", 0},
+		{true, "key000500", 501},
+		{true, "key0005005", 501},
+		{true, "key000999", 800}, // 在rightKey之后, 从rightKey开始
+		{true, "key000050", 0},
+	}
+	for _, test := range tests {
+		it, err := bt.IterateKey([]byte("key000100"), []byte("key000799"), test.desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := it.Seek([]byte(test.seek)); err != nil {
+			t.Fatal(err)
+		}
+		_, uuid, ok, err := it.Next()
+		if err != nil || (ok == false && test.expected != 0) || uuid != test.expected {
+			t.Errorf("desc=%v, seek %s: got %d, %v, %v, expected %d", test.desc, test.seek, uuid, ok, err, test.expected)
+		}
+
+		if err := it.Seek(nil); err != nil {
+			t.Fatal(err)
+		}
+		expected := utils.UUID(101)
+		if test.desc {
+			expected = 800
+		}
+		if _, uuid, _, _ := it.Next(); uuid != expected {
+			t.Errorf("desc=%v, seek nil: got %d, expected %d", test.desc, uuid, expected)
+		}
+
+		it.Close()
+		if _, _, ok, _ := it.Next(); ok {
+			t.Errorf("desc=%v: Next returned an entry after Close", test.desc)
+		}
+	}
+}
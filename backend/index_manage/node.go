@@ -7,21 +7,37 @@ import (
 )
 
 const (
-	_IS_LEAF_OFFSET   = 0                                //是否是叶子节点的数据偏移
+	_IS_LEAF_OFFSET   = 0                                //节点标志的数据偏移
 	_NO_KEYS_OFFSET   = _IS_LEAF_OFFSET + 1              //节点数目的数据偏移
 	_SIBLING_OFFSET   = _NO_KEYS_OFFSET + 2              // 右节点的uuid
 	_NODE_HEADER_SIZE = _SIBLING_OFFSET + utils.LEN_UUID // 节点头部信息大小
 
-	_BALANCE_NUMBER = 32 // 平衡数目，一个节点到达该数目两倍，则分裂
+	_BALANCE_NUMBER = 32 // 平衡数目，定长格式的节点最多能容纳该数目两倍多一点的key
 	_NODE_SIZE      = _NODE_HEADER_SIZE + (2*utils.LEN_UUID)*(_BALANCE_NUMBER*2+2)
+	_MIN_NODE_SIZE  = _NODE_HEADER_SIZE + (_NODE_SIZE-_NODE_HEADER_SIZE)/4 // 删除后小于该大小的节点需要与兄弟节点平衡
+
+	_FLAG_LEAF    = 1 << 0 // 是否是叶子节点
+	_FLAG_VAR_KEY = 1 << 1 // 是否是变长key的格式
+
+	_LEN_KEY_LENGTH = 2      // 变长格式中key长度的大小
+	_INF_KEY_LENGTH = 0xFFFF // 变长格式中表示INF的key长度
+
+	// MAX_KEY_SIZE 为变长格式中key的最大长度, 保证一个节点至少能容纳4个key
+	MAX_KEY_SIZE = (_NODE_SIZE-_NODE_HEADER_SIZE)/4 - utils.LEN_UUID - _LEN_KEY_LENGTH
 )
 
 /**
  * 结构如下
- * [leaf flag]        叶子节点标志1字节
+ * [flags]            节点标志1字节, 由_FLAG_LEAF和_FLAG_VAR_KEY组成
  * [no of keys]       keys数目2字节
  * [sibling uuid]     右节点uuid，8字节
  * [son0],[key0],[son1],[key1]...[sonN],[keyN]
+ *
+ * 旧版本创建的节点为定长格式, key为8字节的uint64(小端序).
+ * 变长格式中, 每个key为[length] uint16 + [bytes], length为_INF_KEY_LENGTH时表示INF.
+ * 在内存中, key都以[]byte表示, 定长格式的key被转换为8字节的大端序, 以便和变长格式一样按字节比较.
+ * INF在内存中为nil, 定长格式中为utils.INF.
+ * 非叶子节点中, key为其左边son中key的上界, 最右边的节点的最后一个key为INF.
  */
 type node struct {
 	bPlusTree *bPlusTree
@@ -29,18 +45,37 @@ type node struct {
 
 	raw      []byte     //用于存储树节点信息
 	selfUUID utils.UUID //存储改树节点信息的uuid，也是节点的索引值
+	dirty    bool       //持有写latch期间是否修改过
+}
+
+// nodeEntry 为节点中的一个son和key
+type nodeEntry struct {
+	son utils.UUID
+	key []byte
 }
 
 func setRawIsLeaf(raw []byte, isLeaf bool) {
 	if isLeaf {
-		raw[_IS_LEAF_OFFSET] = byte(1)
+		raw[_IS_LEAF_OFFSET] |= _FLAG_LEAF
 	} else {
-		raw[_IS_LEAF_OFFSET] = byte(0)
+		raw[_IS_LEAF_OFFSET] &^= _FLAG_LEAF
 	}
 }
 
 func getRawIsLeaf(raw []byte) bool {
-	return raw[_IS_LEAF_OFFSET] == byte(1)
+	return raw[_IS_LEAF_OFFSET]&_FLAG_LEAF != 0
+}
+
+func setRawVarKey(raw []byte, varKey bool) {
+	if varKey {
+		raw[_IS_LEAF_OFFSET] |= _FLAG_VAR_KEY
+	} else {
+		raw[_IS_LEAF_OFFSET] &^= _FLAG_VAR_KEY
+	}
+}
+
+func getRawVarKey(raw []byte) bool {
+	return raw[_IS_LEAF_OFFSET]&_FLAG_VAR_KEY != 0
 }
 
 func setRawNoKeys(raw []byte, noKeys int) {
@@ -59,67 +94,106 @@ func getRawSibling(raw []byte) utils.UUID {
 	return utils.ParseUUID(raw[_SIBLING_OFFSET:])
 }
 
-func setRawKthSon(raw []byte, uid utils.UUID, kth int) {
-	offset := _NODE_HEADER_SIZE + kth*(utils.LEN_UUID*2)
-	utils.PutUUID(raw[offset:], uid)
-}
-
-func getRawKthSon(raw []byte, kth int) utils.UUID {
-	offset := _NODE_HEADER_SIZE + kth*(utils.LEN_UUID*2)
-	return utils.ParseUUID(raw[offset:])
+// entrySize 返回一个key为key的entry在节点中所占的大小
+func entrySize(varKey bool, key []byte) int {
+	if varKey == false {
+		return utils.LEN_UUID * 2
+	}
+	return utils.LEN_UUID + _LEN_KEY_LENGTH + len(key)
 }
 
-func setRawKthKey(raw []byte, key utils.UUID, kth int) {
-	offset := _NODE_HEADER_SIZE + kth*(utils.LEN_UUID*2) + utils.LEN_UUID
-	utils.PutUUID(raw[offset:], key)
+// entriesSize 返回由entries组成的节点的大小
+func entriesSize(varKey bool, entries []nodeEntry) int {
+	size := _NODE_HEADER_SIZE
+	for _, e := range entries {
+		size += entrySize(varKey, e.key)
+	}
+	return size
 }
 
-func getRawKthKey(raw []byte, kth int) utils.UUID {
-	offset := _NODE_HEADER_SIZE + kth*(utils.LEN_UUID*2) + utils.LEN_UUID
-	return utils.ParseUUID(raw[offset:])
+// getRawEntries 解析出节点中所有的entry
+func getRawEntries(raw []byte) []nodeEntry {
+	varKey := getRawVarKey(raw)
+	noKeys := getRawNoKeys(raw)
+	entries := make([]nodeEntry, noKeys)
+	pos := _NODE_HEADER_SIZE
+	for i := 0; i < noKeys; i++ {
+		entries[i].son = utils.ParseUUID(raw[pos:])
+		pos += utils.LEN_UUID
+		if varKey == false {
+			entries[i].key = UUIDToKey(utils.ParseUUID(raw[pos:]))
+			pos += utils.LEN_UUID
+			continue
+		}
+		length := int(utils.ParseUint16(raw[pos:]))
+		pos += _LEN_KEY_LENGTH
+		if length == _INF_KEY_LENGTH {
+			continue
+		}
+		entries[i].key = make([]byte, length)
+		copy(entries[i].key, raw[pos:pos+length])
+		pos += length
+	}
+	return entries
 }
 
-// 将kth位置的key和son向后移动一位
-func shiftRawKth(raw []byte, kth int) {
-	begin := _NODE_HEADER_SIZE + (kth+1)*(utils.LEN_UUID*2)
-	end := _NODE_SIZE - 1
-	for i := end; i >= begin; i-- { // copy(raw, raw) is dangerous
-		raw[i] = raw[i-(utils.LEN_UUID*2)]
+// setRawEntries 将entries写入节点, entries的大小不能超过_NODE_SIZE
+func setRawEntries(raw []byte, entries []nodeEntry) {
+	varKey := getRawVarKey(raw)
+	setRawNoKeys(raw, len(entries))
+	pos := _NODE_HEADER_SIZE
+	for _, e := range entries {
+		utils.PutUUID(raw[pos:], e.son)
+		pos += utils.LEN_UUID
+		if varKey == false {
+			if e.key == nil {
+				utils.PutUUID(raw[pos:], utils.INF)
+			} else {
+				utils.PutUUID(raw[pos:], KeyToUUID(e.key))
+			}
+			pos += utils.LEN_UUID
+			continue
+		}
+		if e.key == nil {
+			utils.PutUint16(raw[pos:], _INF_KEY_LENGTH)
+			pos += _LEN_KEY_LENGTH
+			continue
+		}
+		utils.PutUint16(raw[pos:], uint16(len(e.key)))
+		pos += _LEN_KEY_LENGTH
+		pos += copy(raw[pos:], e.key)
+	}
+	for i := pos; i < len(raw); i++ {
+		raw[i] = 0
 	}
 }
 
-//
-// copyRawFromKth
-//  @Description: 拷贝一个from中的第kth的key-value的据到to中
-//  @param from
-//  @param to
-//  @param kth
-//
-func copyRawFromKth(from, to []byte, kth int) {
-	offset := _NODE_HEADER_SIZE + kth*(utils.LEN_UUID*2)
-	copy(to[_NODE_HEADER_SIZE:], from[offset:])
+// newNodeRaw 新建一个节点, 其内容为entries
+func newNodeRaw(isLeaf, varKey bool, sibling utils.UUID, entries []nodeEntry) []byte {
+	raw := make([]byte, _NODE_SIZE)
+	setRawIsLeaf(raw, isLeaf)
+	setRawVarKey(raw, varKey)
+	setRawSibling(raw, sibling)
+	setRawEntries(raw, entries)
+	return raw
 }
 
 // newRootRaw 新建一个根节点, 该根节点的初始两个子节点为left和right, 初始键值为key
-func newRootRaw(left, right, key utils.UUID) []byte {
-	raw := make([]byte, _NODE_SIZE)
-	setRawIsLeaf(raw, false)
-	setRawNoKeys(raw, 2)
-	setRawSibling(raw, utils.NilUUID)
-	setRawKthSon(raw, left, 0)
-	setRawKthKey(raw, key, 0)
-	setRawKthSon(raw, right, 1)
-	setRawKthKey(raw, utils.INF, 1)
-	return raw
+func newRootRaw(varKey bool, left, right utils.UUID, key []byte) []byte {
+	return newNodeRaw(false, varKey, utils.NilUUID, []nodeEntry{{left, key}, {right, nil}})
 }
 
 // newNilRootRaw 新建一个空的根节点, 返回其二进制内容.
 func newNilRootRaw() []byte {
-	raw := make([]byte, _NODE_SIZE)
-	setRawIsLeaf(raw, true)
-	setRawNoKeys(raw, 0)
-	setRawSibling(raw, utils.NilUUID)
-	return raw
+	return newNodeRaw(true, true, utils.NilUUID, nil)
+}
+
+// maxEntrySize 返回一个entry在节点中最多占用的大小
+func maxEntrySize(varKey bool) int {
+	if varKey == false {
+		return utils.LEN_UUID * 2
+	}
+	return utils.LEN_UUID + _LEN_KEY_LENGTH + MAX_KEY_SIZE
 }
 
 // loadNode 读入一个节点, 其自身地址为selfuuid
@@ -144,73 +218,96 @@ func (u *node) Release() {
 	u.dataItem.Release()
 }
 
+// RLatch 对该节点加读latch, 持有读latch时才能读取节点的内容
+func (u *node) RLatch() {
+	u.dataItem.RLock()
+}
+
+func (u *node) RUnlatch() {
+	u.dataItem.RUnlock()
+}
+
+// Latch 对该节点加写latch, 持有写latch时才能修改节点的内容.
+// 加latch本身不会修改节点, 只有真正修改时才会调用BeforeLocked.
+func (u *node) Latch() {
+	u.dataItem.Lock()
+}
+
+// Unlatch 释放写latch, 如果节点被修改过, 则记录日志
+func (u *node) Unlatch() {
+	if u.dirty {
+		u.dataItem.AfterLocked(tm.SUPER_TRANSACTION_ID)
+		u.dirty = false
+	}
+	u.dataItem.Unlock()
+}
+
+// 以下方法都需要调用者持有该节点的latch, 修改节点的方法需要持有写latch.
+
 // IsLeaf 判断一个节点是否是叶子节点
 func (u *node) IsLeaf() bool {
-	// 加锁
-	u.dataItem.RLock()
-	defer u.dataItem.RUnlock()
 	return getRawIsLeaf(u.raw)
 }
 
-// SearchNext
-// 寻找对应key的son, 如果找不到, 则返回sibling uuid
-func (u *node) SearchNext(key utils.UUID) (utils.UUID, utils.UUID) {
-	// 锁当前节点
-	u.dataItem.RLock()
-	defer u.dataItem.RUnlock()
-	// 获取节点数目
-	noKeys := getRawNoKeys(u.raw)
-	for i := 0; i < noKeys; i++ {
-		// 遍历并读取每一个节点
-		ik := getRawKthKey(u.raw, i)
-		// 如果key小于ik意味着，key在当前的son节点下，返回son
-		if key < ik {
-			return getRawKthSon(u.raw, i), utils.NilUUID
-		}
+// Entries 返回该节点所有的entry
+func (u *node) Entries() []nodeEntry {
+	return getRawEntries(u.raw)
+}
+
+// Sibling 返回该节点的右节点的uuid
+func (u *node) Sibling() utils.UUID {
+	return getRawSibling(u.raw)
+}
+
+// SetEntries 将该节点的内容设置为entries, 并将其sibling设置为sibling
+func (u *node) SetEntries(entries []nodeEntry, sibling utils.UUID) {
+	if u.dirty == false {
+		u.dataItem.BeforeLocked()
+		u.dirty = true
 	}
-	//无法找到，返回兄弟节点的uuid
-	return utils.NilUUID, getRawSibling(u.raw)
+	setRawEntries(u.raw, entries)
+	setRawSibling(u.raw, sibling)
 }
 
-// LeafSearchRange
-// 范围查询
-// 在该节点上查询属于[leftKey, rightKey]的地址,
-// 如果rightKey大于等于该节点的最大的key, 则还返回一个sibling uuid.
-func (u *node) LeafSearchRange(leftKey, rightKey utils.UUID) ([]utils.UUID, utils.UUID) {
-	//锁住当前item
-	u.dataItem.RLock()
-	defer u.dataItem.RUnlock()
-
-	// 读取key数目
-	noKeys := getRawNoKeys(u.raw)
-	var kth int
-	for kth < noKeys {
-		// 遍历所有key
-		ik := getRawKthKey(u.raw, kth)
-		// 找到满足ik
-		if ik >= leftKey {
-			break
+// SearchSon
+// 返回entries中第一个不小于key的位置, 该位置的son是最左边的可能包含key的son, 找不到时返回-1.
+// son中的key都不大于其右边的key, 而相同的key可能在分裂时被分到左右两个节点,
+// 所以选择第一个不小于key的位置, 以保证从最左边可能包含key的节点开始查找.
+// key为nil时表示比所有key都小, 返回最左边的son.
+func (u *node) SearchSon(entries []nodeEntry, key []byte) int {
+	if key == nil {
+		if len(entries) > 0 {
+			return 0
 		}
-		kth++
+		return -1
 	}
-	// 遍历并添加
-	var uuids []utils.UUID
-	for kth < noKeys {
-		ik := getRawKthKey(u.raw, kth)
-		if ik <= rightKey {
-			uuids = append(uuids, getRawKthSon(u.raw, kth))
-			kth++
-		} else {
-			break
+	for i, e := range entries {
+		if u.bPlusTree.compare(key, e.key) <= 0 {
+			return i
 		}
 	}
+	return -1
+}
 
-	var sibling utils.UUID = utils.NilUUID
-	if kth == noKeys {
-		sibling = getRawSibling(u.raw)
+// InsertSafe 判断向该节点插入key, 或者其子节点分裂时, 该节点是否一定不会分裂
+func (u *node) InsertSafe(key []byte) bool {
+	varKey := getRawVarKey(u.raw)
+	size := entriesSize(varKey, getRawEntries(u.raw))
+	if getRawIsLeaf(u.raw) {
+		return size+entrySize(varKey, key) <= _NODE_SIZE
 	}
+	return size+maxEntrySize(varKey) <= _NODE_SIZE
+}
 
-	return uuids, sibling
+// DeleteSafe 判断从该节点删除一个entry之后, 该节点是否一定不会过小
+func (u *node) DeleteSafe() bool {
+	varKey := getRawVarKey(u.raw)
+	return entriesSize(varKey, getRawEntries(u.raw))-maxEntrySize(varKey) >= _MIN_NODE_SIZE
+}
+
+// Underflow 判断该节点是否过小
+func (u *node) Underflow() bool {
+	return entriesSize(getRawVarKey(u.raw), getRawEntries(u.raw)) < _MIN_NODE_SIZE
 }
 
 /*
@@ -219,106 +316,45 @@ func (u *node) LeafSearchRange(leftKey, rightKey utils.UUID) ([]utils.UUID, util
  			 	 v         v
 	p0, k0, p1, k1         p2, k2, p3, INF
 */
-// InsertAndSplit 将对应的数据插入该节点, 并尝试进行分裂.
-// 如果该份数据不应该插入到此节点, 则返回一个sibling uuid.
-func (u *node) InsertAndSplit(uuid, key utils.UUID) (utils.UUID, utils.UUID, utils.UUID, error) {
-	var succ bool
-	var err error
-
-	u.dataItem.Before()
-	defer func() {
-		if err == nil && succ {
-			u.dataItem.After(tm.SUPER_TRANSACTION_ID)
-		} else {
-			// 如果失败, 则复原当前节点
-			u.dataItem.UnBefore()
-		}
-	}()
-
-	succ = u.insert(uuid, key)
-	if succ == false {
-		return getRawSibling(u.raw), utils.NilUUID, utils.NilUUID, nil
+// Update 将该节点的内容设置为entries, 过大时进行分裂.
+// 分裂时返回新节点的uuid, 以及该节点新的上界.
+func (u *node) Update(entries []nodeEntry) (utils.UUID, []byte, error) {
+	if entriesSize(getRawVarKey(u.raw), entries) > _NODE_SIZE {
+		return u.split(entries)
 	}
-
-	if u.needSplit() {
-		var newSon utils.UUID
-		var newKey utils.UUID
-		newSon, newKey, err = u.split()
-		return utils.NilUUID, newSon, newKey, err
-	} else {
-		return utils.NilUUID, utils.NilUUID, utils.NilUUID, nil
-	}
-}
-
-// insert 向一个节点中添加一个key，
-// 如果是叶子节点，添加的就是key-value（uuid，存放value的dataItem的uuid）
-// 如果不是叶子节点，则插入的是key位置，以及key位置右边的son。
-// todo: 需要优化，设置成无论是分裂时插入到非叶子节点，还是添加到叶子节点，insert操作都一样
-func (u *node) insert(uuid utils.UUID, key utils.UUID) bool {
-	// 获取原本key数目
-	noKeys := getRawNoKeys(u.raw)
-	var kth int
-	// 获取插入位置
-	for kth < noKeys {
-		ik := getRawKthKey(u.raw, kth)
-		if ik < key {
-			kth++
-		} else {
-			break
-		}
-	}
-
-	if kth == noKeys && getRawSibling(u.raw) != utils.NilUUID {
-		// 如果该节点有右继节点, 且该key大于该节点所有key
-		// 则让该key被插入到右继节点去
-		return false
-	}
-
-	// 如果是叶子节点,不需要设置
-	if getRawIsLeaf(u.raw) == true {
-		// kth位置向后移动一位
-		shiftRawKth(u.raw, kth)
-		// 设置key
-		setRawKthKey(u.raw, key, kth)
-		// 设置son为uuid
-		setRawKthSon(u.raw, uuid, kth)
-		// 更新节点数目
-		setRawNoKeys(u.raw, noKeys+1)
-	} else {
-		// 非叶子节点，获kth位置的key
-		kk := getRawKthKey(u.raw, kth)
-		// 设置key
-		setRawKthKey(u.raw, key, kth)
-		// 向后kth+1向后移动
-		shiftRawKth(u.raw, kth+1)
-		// 将kk设置到kth+1位置
-		setRawKthKey(u.raw, kk, kth+1)
-		setRawKthSon(u.raw, uuid, kth+1)
-		setRawNoKeys(u.raw, noKeys+1)
-	}
-	return true
+	u.SetEntries(entries, getRawSibling(u.raw))
+	return utils.NilUUID, nil, nil
 }
 
-func (u *node) needSplit() bool {
-	return _BALANCE_NUMBER*2 == getRawNoKeys(u.raw)
-}
+// split 将entries按大小分为两半, 左半部分留在该节点, 右半部分写入新的节点.
+// 返回新节点的uuid, 以及左半部分最后一个key, 它将作为该节点新的上界.
+func (u *node) split(entries []nodeEntry) (utils.UUID, []byte, error) {
+	varKey := getRawVarKey(u.raw)
+	mid := splitPoint(varKey, entries)
 
-func (u *node) split() (utils.UUID, utils.UUID, error) {
 	// 创建并拷贝到新节点
-	nodeRaw := make([]byte, _NODE_SIZE)
-	setRawIsLeaf(nodeRaw, getRawIsLeaf(u.raw))
-	setRawNoKeys(nodeRaw, _BALANCE_NUMBER)
-	setRawSibling(nodeRaw, getRawSibling(u.raw))
-	copyRawFromKth(u.raw, nodeRaw, _BALANCE_NUMBER)
+	nodeRaw := newNodeRaw(getRawIsLeaf(u.raw), varKey, getRawSibling(u.raw), entries[mid:])
 	// 添加到文件中，并获取uuid
 	son, err := u.bPlusTree.DataManager.Insert(tm.SUPER_TRANSACTION_ID, nodeRaw)
-
 	if err != nil {
-		return utils.NilUUID, utils.NilUUID, err
+		return utils.NilUUID, nil, err
 	}
-	// 更新原来节点，更新其兄弟节点为分裂的新节点，更新原节点的key数目
-	setRawNoKeys(u.raw, _BALANCE_NUMBER)
-	setRawSibling(u.raw, son)
+	// 更新原来节点，更新其兄弟节点为分裂的新节点
+	u.SetEntries(entries[:mid], son)
 
-	return son, getRawKthKey(nodeRaw, 0), nil
+	return son, entries[mid-1].key, nil
+}
+
+// splitPoint 返回将entries按大小分为两半的位置, 两半都不为空
+func splitPoint(varKey bool, entries []nodeEntry) int {
+	half := entriesSize(varKey, entries) / 2
+	size, mid := _NODE_HEADER_SIZE, 0
+	for mid < len(entries)-1 && size < half {
+		size += entrySize(varKey, entries[mid].key)
+		mid++
+	}
+	if mid == 0 {
+		mid = 1
+	}
+	return mid
 }
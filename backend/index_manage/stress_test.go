@@ -0,0 +1,324 @@
+/*
+	TestStress 对B+树进行并发压力测试.
+
+	每个writer只插入和删除属于自己的uuid(uuid % writers == 编号), 所以每个writer都知道自己的entry的最终状态.
+	开始前插入一批永久的entry, 它们不会被删除. writer运行期间, reader不断地正序和逆序遍历整棵树,
+	每个永久的entry都应当恰好出现一次, 且遍历的结果有序.
+	结束后检查树的结构(见index_manage.Verify), 并检查树中的内容与所有writer记录的内容一致.
+	之后所有writer并发地删除自己的entry, 再进行同样的检查.
+
+	go test -race -run TestStress ./backend/index_manage
+*/
+package index_manage_test
+
+import (
+	"bytes"
+	"errors"
+	"fansDB/backend/data_manage"
+	im "fansDB/backend/index_manage"
+	tm "fansDB/backend/transaction_manage"
+	"fansDB/backend/utils"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	_DEFAULT_MEM = (1 << 20) * 64 // 64MB
+
+	_WRITERS   = 8   // writer的数目
+	_READERS   = 4   // reader的数目
+	_OPS       = 800 // 每个writer的操作数目
+	_PERMANENT = 300 // 永久的entry的数目
+	_KEYS      = 500 // 不同的key的数目
+)
+
+var (
+	ErrDuplicate = errors.New("Entry appears more than once.")
+	ErrMissing   = errors.New("Entry is missing.")
+	ErrUnsorted  = errors.New("Keys are not sorted.")
+	ErrMismatch  = errors.New("Tree content mismatch.")
+)
+
+// entry 为树中的一个(key, uuid)
+type entry struct {
+	key  []byte
+	uuid utils.UUID
+}
+
+func TestStress(t *testing.T) {
+	writers, readers, ops, permanent, keys := _WRITERS, _READERS, _OPS, _PERMANENT, _KEYS
+	if testing.Short() {
+		ops /= 4
+	}
+
+	path := filepath.Join(t.TempDir(), "stress")
+	tmr := tm.Create(path)
+	dmr := data_manage.Create(path, _DEFAULT_MEM, tmr)
+	defer func() {
+		dmr.Close()
+		tmr.Close()
+	}()
+
+	bootUUID, err := im.Create(dmr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt, err := im.Load(bootUUID, dmr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 永久的entry的uuid大于所有writer使用的uuid
+	fixed := map[utils.UUID][]byte{}
+	rnd := rand.New(rand.NewSource(0))
+	base := utils.UUID(writers) * utils.UUID(ops+1)
+	for i := 0; i < permanent; i++ {
+		uuid := base + utils.UUID(i) + 1
+		key := randomKey(rnd, keys)
+		fixed[uuid] = key
+		if err := bt.InsertKey(key, uuid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 第一轮随机插入和删除, 第二轮删除writer插入的所有entry, 使得树逐渐缩小
+	live := make([]map[utils.UUID][]byte, writers)
+	for w := range live {
+		live[w] = map[utils.UUID][]byte{}
+	}
+	rounds := []func(w int) error{
+		func(w int) error {
+			return write(bt, w, writers, ops, keys, live[w])
+		},
+		func(w int) error {
+			return drain(bt, live[w])
+		},
+	}
+	for i, round := range rounds {
+		err = run(bt, fixed, writers, readers, round)
+		if err != nil {
+			t.Fatalf("round %d: %v", i, err)
+		}
+		result, err := check(bt, fixed, live)
+		if err != nil {
+			t.Fatalf("round %d: %v", i, err)
+		}
+		t.Logf("round %d: %d entries, %d nodes, %d leaves, depth %d",
+			i, result.Entries, result.Nodes, result.Leaves, result.Depth)
+	}
+}
+
+// run 启动writers个writer执行work, 同时启动readers个reader不断检查遍历的结果, 直到所有writer结束
+func run(bt im.BPlusTree, fixed map[utils.UUID][]byte, writers, readers int, work func(w int) error) error {
+	var stop int32
+	var failed atomic.Value
+	fail := func(err error) {
+		failed.Store(err)
+		atomic.StoreInt32(&stop, 1)
+	}
+
+	var readerGroup sync.WaitGroup
+	for r := 0; r < readers; r++ {
+		readerGroup.Add(1)
+		go func(desc bool) {
+			defer readerGroup.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				if err := checkScan(bt, fixed, desc); err != nil {
+					fail(err)
+					return
+				}
+			}
+		}(r%2 == 1)
+	}
+
+	var writerGroup sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		writerGroup.Add(1)
+		go func(w int) {
+			defer writerGroup.Done()
+			if err := work(w); err != nil {
+				fail(err)
+			}
+		}(w)
+	}
+	writerGroup.Wait()
+	atomic.StoreInt32(&stop, 1)
+	readerGroup.Wait()
+
+	if err, ok := failed.Load().(error); ok {
+		return err
+	}
+	return nil
+}
+
+// check 检查树的结构, 以及树中的内容恰好为fixed和live中的entry
+func check(bt im.BPlusTree, fixed map[utils.UUID][]byte, live []map[utils.UUID][]byte) (*im.VerifyResult, error) {
+	result, err := im.Verify(bt)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Violations) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(result.Violations, "\n\t"))
+	}
+
+	expected := map[utils.UUID][]byte{}
+	for uuid, key := range fixed {
+		expected[uuid] = key
+	}
+	for _, m := range live {
+		for uuid, key := range m {
+			expected[uuid] = key
+		}
+	}
+	return result, checkContent(bt, expected)
+}
+
+// randomKey 生成一个随机的key, 它只有keys种取值, 所以会有大量相同的key, 其中一部分key较长
+func randomKey(rnd *rand.Rand, keys int) []byte {
+	n := rnd.Intn(keys)
+	key := []byte(fmt.Sprintf("%d", n))
+	if n%4 == 0 {
+		key = append(key, bytes.Repeat([]byte{'x'}, n%50)...)
+	}
+	return key
+}
+
+// write 第w个writer随机地插入和删除属于自己的entry, 并将最终留在树中的entry记录在live中
+func write(bt im.BPlusTree, w, writers, ops, keys int, live map[utils.UUID][]byte) error {
+	rnd := rand.New(rand.NewSource(int64(w) + 1000))
+	var owned []utils.UUID
+	next := utils.UUID(w)
+	for i := 0; i < ops; i++ {
+		if len(owned) > 0 && rnd.Intn(5) < 2 {
+			kth := rnd.Intn(len(owned))
+			uuid := owned[kth]
+			owned[kth] = owned[len(owned)-1]
+			owned = owned[:len(owned)-1]
+
+			ok, err := bt.DeleteKey(live[uuid], uuid)
+			if err != nil {
+				return err
+			}
+			if ok == false {
+				return ErrMissing
+			}
+			delete(live, uuid)
+			continue
+		}
+
+		next += utils.UUID(writers)
+		key := randomKey(rnd, keys)
+		if err := bt.InsertKey(key, next); err != nil {
+			return err
+		}
+		live[next] = key
+		owned = append(owned, next)
+	}
+	return nil
+}
+
+// drain 删除live中所有的entry
+func drain(bt im.BPlusTree, live map[utils.UUID][]byte) error {
+	for uuid, key := range live {
+		ok, err := bt.DeleteKey(key, uuid)
+		if err != nil {
+			return err
+		}
+		if ok == false {
+			return ErrMissing
+		}
+		delete(live, uuid)
+	}
+	return nil
+}
+
+// checkScan 遍历整棵树, 检查结果有序, 且每个永久的entry恰好出现一次
+func checkScan(bt im.BPlusTree, fixed map[utils.UUID][]byte, desc bool) error {
+	seen := map[utils.UUID]bool{}
+	var last []byte
+	err := bt.ScanKey(nil, nil, desc, func(key []byte, uuid utils.UUID) (bool, error) {
+		if last != nil {
+			c := bytes.Compare(last, key)
+			if (desc == false && c > 0) || (desc && c < 0) {
+				return false, ErrUnsorted
+			}
+		}
+		last = key
+		if seen[uuid] {
+			return false, ErrDuplicate
+		}
+		seen[uuid] = true
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	for uuid := range fixed {
+		if seen[uuid] == false {
+			return ErrMissing
+		}
+	}
+	return nil
+}
+
+// checkContent 检查树中的内容恰好为expected, 且每个key都能被查找到
+func checkContent(bt im.BPlusTree, expected map[utils.UUID][]byte) error {
+	var got []entry
+	err := bt.ScanKey(nil, nil, false, func(key []byte, uuid utils.UUID) (bool, error) {
+		got = append(got, entry{key, uuid})
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(got) != len(expected) {
+		return ErrMismatch
+	}
+	for _, e := range got {
+		key, ok := expected[e.uuid]
+		if ok == false || bytes.Equal(key, e.key) == false {
+			return ErrMismatch
+		}
+	}
+
+	var desc []entry
+	err = bt.ScanKey(nil, nil, true, func(key []byte, uuid utils.UUID) (bool, error) {
+		desc = append(desc, entry{key, uuid})
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(desc) != len(got) {
+		return ErrMismatch
+	}
+	sort.Slice(desc, func(i, j int) bool {
+		return bytes.Compare(desc[i].key, desc[j].key) < 0
+	})
+	for i := range desc {
+		if bytes.Equal(desc[i].key, got[i].key) == false {
+			return ErrMismatch
+		}
+	}
+
+	for uuid, key := range expected {
+		uuids, err := bt.SearchKeyRange(key, key)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, tmp := range uuids {
+			found = found || tmp == uuid
+		}
+		if found == false {
+			return ErrMissing
+		}
+	}
+	return nil
+}
@@ -1,29 +1,75 @@
 package index_manage
 
 import (
+	"bytes"
+	"errors"
 	dm "fansDB/backend/data_manage"
 	tm "fansDB/backend/transaction_manage"
 	"fansDB/backend/utils"
 	"sync"
 )
 
-type BPlusTree interface {
+var (
+	ErrInvalidKey  = errors.New("Invalid index key.")
+	ErrInvalidTree = errors.New("Invalid index tree.")
+	ErrFillFactor  = errors.New("Fill factor must be in [0.5, 1].")
+	ErrInvalidHash = errors.New("Invalid hash index.")
+)
+
+// Index 为以utils.UUID为key的索引, 同一个key可以对应多个uuid.
+// B+树和hash索引(见hash.go)都实现了它. hash索引中的key没有顺序,
+// 区间查找和遍历需要读出整个索引再排序, 所以hash索引只适合等值查找.
+type Index interface {
 	Insert(key, uuid utils.UUID) error
+	Delete(key, uuid utils.UUID) (bool, error)
 	Search(key utils.UUID) ([]utils.UUID, error)
 	SearchRange(leftKey, rightKey utils.UUID) ([]utils.UUID, error)
+	Scan(leftKey, rightKey utils.UUID, desc bool, handle func(key, uuid utils.UUID) (bool, error)) error
+	Iterate(leftKey, rightKey utils.UUID, desc bool) (Iterator, error)
+}
+
+// BPlusTree 以字节串为key, 按照Comparator的顺序组织.
+// 以utils.UUID为key的方法(即Index)将key转换为8字节的大端序, 用于单个uint64的key.
+type BPlusTree interface {
+	Index
+
+	// 以下方法中, leftKey和rightKey为nil时表示无界
+	InsertKey(key []byte, uuid utils.UUID) error
+	DeleteKey(key []byte, uuid utils.UUID) (bool, error)
+	SearchKeyRange(leftKey, rightKey []byte) ([]utils.UUID, error)
+	ScanKey(leftKey, rightKey []byte, desc bool, handle func(key []byte, uuid utils.UUID) (bool, error)) error
+	IterateKey(leftKey, rightKey []byte, desc bool) (Iterator, error)
+
+	// BulkLoad 将entries和树中原有的entry一起, 自底向上地重建整棵树, 见bulk.go
+	BulkLoad(entries []Entry, fillFactor float64) error
 }
 
-//
-// bPlusTree
-// @Description: b+树的一个实现
-// todo: 是否可保证并发安全
-//
+// Comparator 比较两个key, a小于, 等于, 大于b时分别返回负数, 0, 正数.
+type Comparator func(a, b []byte) int
+
+/*
+	bPlusTree 为B+树的一个实现, 通过latch coupling保证并发安全.
+
+	每个节点的latch即其dataitem的读写锁, 根节点的uuid由bootLock保护, 它相当于根节点之上的一个节点.
+	所有的latch都按照从上到下, 同一层从左到右的顺序获取, 所以不会死锁.
+	读者从bootLock开始, 先获取子节点的读latch, 再释放父节点的读latch, 所以读到的父子节点总是一致的.
+	写者以同样的方式获取写latch, 如果子节点是安全的, 即插入后不会分裂, 或删除后不会过小,
+	则它的祖先节点都不会再被修改, 此时释放所有祖先节点的latch; 否则继续持有它们, 直到分裂或合并完成.
+	所以写者持有一个节点的写latch时, 其他写者不会修改它的子树中被它持有latch的部分.
+
+	叶节点之间的遍历(见iterator)不持有两个节点的latch, 而是读出一个节点的内容后再读取其sibling.
+	为此, 节点的sibling只会在持有写latch时被修改, 被合并掉的节点的内容不会再被修改, 且节点不会被释放,
+	所以读者总能从它读到的任何一个节点继续向右遍历, 见rebalance.
+*/
 type bPlusTree struct {
-	bootUUID     utils.UUID  //该uuid位置存储了head的uuid
-	bootDataItem dm.DataItem //根节点数据
-	bootLock     sync.Mutex  //锁根节点的
+	bootUUID     utils.UUID   //该uuid位置存储了head的uuid
+	bootDataItem dm.DataItem  //根节点数据
+	bootLock     sync.RWMutex //根节点uuid的latch
 
 	DataManager dm.DataManager //存储该树的文件的datamanager
+
+	comparator Comparator
+	varKey     bool // 是否为变长key的格式, 旧版本创建的树为定长格式, 只能使用8字节的key
 }
 
 // Create
@@ -45,9 +91,15 @@ func Create(dm dm.DataManager) (utils.UUID, error) {
 	return bootUUID, nil
 }
 
-// Load 通过bootUUIDd读取b+树
+// Load 通过bootUUIDd读取b+树, key按字节序比较
 // todo:是否设置为存储根节点位置在起始位置
 func Load(bootUUID utils.UUID, dm dm.DataManager) (BPlusTree, error) {
+	return LoadWithComparator(bootUUID, dm, bytes.Compare)
+}
+
+// LoadWithComparator 通过bootUUID读取b+树, key按comparator比较.
+// 同一棵树每次都需要以相同的comparator读取.
+func LoadWithComparator(bootUUID utils.UUID, dm dm.DataManager, comparator Comparator) (BPlusTree, error) {
 	bootItem, ok, err := dm.Read(bootUUID)
 	if err != nil {
 		return nil, err
@@ -58,31 +110,57 @@ func Load(bootUUID utils.UUID, dm dm.DataManager) (BPlusTree, error) {
 		bootUUID:     bootUUID,
 		DataManager:  dm,
 		bootDataItem: bootItem,
+		comparator:   comparator,
+	}
+
+	root, err := tree.rLatchRoot()
+	if err != nil {
+		return nil, err
 	}
+	tree.varKey = getRawVarKey(root.raw)
+	root.RUnlatch()
+	root.Release()
 	return tree, nil
 }
 
-// Insert
-// 向树种添加一个key-value
-func (bt *bPlusTree) Insert(key, uuid utils.UUID) error {
-	// 读取根节点
-	rootUUID := bt.rootUUID()
-	// 递归插入
-	newNode, newKey, err := bt.insert(rootUUID, uuid, key)
-	if err != nil {
-		return err
+// UUIDToKey 将uuid转换为8字节的大端序, 使其字节序和数值的顺序一致, 以utils.UUID为key的方法都使用该格式
+func UUIDToKey(uuid utils.UUID) []byte {
+	key := make([]byte, utils.LEN_UUID)
+	for i := utils.LEN_UUID - 1; i >= 0; i-- {
+		key[i] = byte(uuid)
+		uuid >>= 8
 	}
+	return key
+}
 
-	// 如果需要更新根节点
-	// todo: 如果多个线程同时都需要添加怎么办？
-	if newNode != utils.NilUUID { // 更新根节点
-		err := bt.updateRootUUID(rootUUID, newNode, newKey)
-		if err != nil {
-			return err
+// KeyToUUID 为UUIDToKey的逆变换
+func KeyToUUID(key []byte) utils.UUID {
+	var uuid utils.UUID
+	for _, b := range key {
+		uuid = uuid<<8 | utils.UUID(b)
+	}
+	return uuid
+}
+
+// compare 比较两个节点中的key, nil表示INF, 比其他key都大
+func (bt *bPlusTree) compare(a, b []byte) int {
+	if a == nil || b == nil {
+		if a == nil && b == nil {
+			return 0
+		} else if a == nil {
+			return 1
 		}
+		return -1
 	}
+	return bt.comparator(a, b)
+}
 
-	return nil
+func (bt *bPlusTree) Insert(key, uuid utils.UUID) error {
+	return bt.InsertKey(UUIDToKey(key), uuid)
+}
+
+func (bt *bPlusTree) Delete(key, uuid utils.UUID) (bool, error) {
+	return bt.DeleteKey(UUIDToKey(key), uuid)
 }
 
 func (bt *bPlusTree) Search(key utils.UUID) ([]utils.UUID, error) {
@@ -90,159 +168,494 @@ func (bt *bPlusTree) Search(key utils.UUID) ([]utils.UUID, error) {
 }
 
 func (bt *bPlusTree) SearchRange(leftKey, rightKey utils.UUID) ([]utils.UUID, error) {
-	rootUUID := bt.rootUUID()
+	return bt.SearchKeyRange(UUIDToKey(leftKey), UUIDToKey(rightKey))
+}
+
+func (bt *bPlusTree) Scan(leftKey, rightKey utils.UUID, desc bool, handle func(key, uuid utils.UUID) (bool, error)) error {
+	return bt.ScanKey(UUIDToKey(leftKey), UUIDToKey(rightKey), desc, func(key []byte, uuid utils.UUID) (bool, error) {
+		return handle(KeyToUUID(key), uuid)
+	})
+}
+
+func (bt *bPlusTree) Iterate(leftKey, rightKey utils.UUID, desc bool) (Iterator, error) {
+	return bt.IterateKey(UUIDToKey(leftKey), UUIDToKey(rightKey), desc)
+}
+
+// validKey 判断key是否能被存入该树
+func (bt *bPlusTree) validKey(key []byte) bool {
+	if key == nil {
+		return false
+	}
+	if bt.varKey {
+		return len(key) <= MAX_KEY_SIZE
+	}
+	return len(key) == utils.LEN_UUID
+}
+
+// InsertKey
+// 向树种添加一个key-value
+func (bt *bPlusTree) InsertKey(key []byte, uuid utils.UUID) error {
+	if bt.validKey(key) == false {
+		return ErrInvalidKey
+	}
 
-	leafUUID, err := bt.searchLeaf(rootUUID, leftKey)
+	path := &latchPath{bt: bt}
+	defer path.releaseAll()
+	root, err := bt.latchRoot(path)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if root.InsertSafe(key) {
+		path.releaseAbove(root)
 	}
 
-	var uuids []utils.UUID
-	for {
-		// 不断的从leaf向sibling迭代, 将所有满足的uuid都加入
-		leaf, err := loadNode(bt, leafUUID)
+	// 递归插入
+	newNode, newKey, err := bt.insert(path, root, key, uuid)
+	if err != nil {
+		return err
+	}
+
+	// 如果根节点分裂, 此时仍持有bootLock, 创建新的根节点
+	if newNode != utils.NilUUID {
+		rootRaw := newRootRaw(bt.varKey, root.selfUUID, newNode, newKey)
+		newRootUUID, err := bt.DataManager.Insert(tm.SUPER_TRANSACTION_ID, rootRaw)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		tmp, siblingUUID := leaf.LeafSearchRange(leftKey, rightKey)
-		leaf.Release()
-		uuids = append(uuids, tmp...)
+		bt.setRootUUID(newRootUUID)
+	}
+	return nil
+}
 
-		if siblingUUID == utils.NilUUID {
-			break
-		} else {
-			leafUUID = siblingUUID
+// insert 将(key, uuid)插入到u的子树中, 调用者持有u的写latch.
+// 如果u分裂, 则返回分裂产生的新节点, 以及u新的上界.
+func (bt *bPlusTree) insert(path *latchPath, u *node, key []byte, uuid utils.UUID) (utils.UUID, []byte, error) {
+	entries := u.Entries()
+	if u.IsLeaf() {
+		// 获取插入位置, kth位置向后移动一位, 并设置为(uuid, key)
+		kth := 0
+		for kth < len(entries) && bt.compare(entries[kth].key, key) < 0 {
+			kth++
 		}
+		entries = append(entries, nodeEntry{})
+		copy(entries[kth+1:], entries[kth:])
+		entries[kth] = nodeEntry{uuid, key}
+		return u.Update(entries)
 	}
 
-	return uuids, nil
+	kth := u.SearchSon(entries, key)
+	utils.Assert(kth >= 0) // 非叶子节点的最后一个key即为它的上界, 不小于key
+	son, err := bt.latchNode(path, entries[kth].son)
+	if err != nil {
+		return utils.NilUUID, nil, err
+	}
+	if son.InsertSafe(key) {
+		path.releaseAbove(son)
+	}
+
+	newSon, newSonKey, err := bt.insert(path, son, key, uuid)
+	if err != nil || newSon == utils.NilUUID {
+		return utils.NilUUID, nil, err
+	}
+
+	// son分裂, 由于son不安全, 此时仍持有u的写latch.
+	// son原来的上界成为newSon的上界, newSonKey成为son的上界.
+	entries = append(entries, nodeEntry{})
+	copy(entries[kth+2:], entries[kth+1:])
+	entries[kth+1] = nodeEntry{newSon, entries[kth].key}
+	entries[kth].key = newSonKey
+	return u.Update(entries)
 }
 
-// insert 将(uuid, key)插入到B+树中, 如果有分裂, 则将分裂产生的新节点也返回.
-func (bt *bPlusTree) insert(nodeUUID, uuid, key utils.UUID) (newNodeUUID, newNodeKey utils.UUID, err error) {
-	// 读取当前节点
-	var node *node
-	node, err = loadNode(bt, nodeUUID)
+// DeleteKey
+// 从树中删除一个key-value, 返回它是否存在.
+// 删除后过小的节点会从相邻的兄弟节点借入entry, 或者与其合并, 只剩一个son的根节点会被移除.
+func (bt *bPlusTree) DeleteKey(key []byte, uuid utils.UUID) (bool, error) {
+	if bt.validKey(key) == false {
+		return false, ErrInvalidKey
+	}
+
+	path := &latchPath{bt: bt}
+	defer path.releaseAll()
+	root, err := bt.latchRoot(path)
 	if err != nil {
-		return
+		return false, err
+	}
+	// 叶子的根节点不需要平衡, 非叶子的根节点在son合并后只剩一个son时需要被移除
+	if root.IsLeaf() || len(root.Entries()) > 2 {
+		path.releaseAbove(root)
 	}
 
-	isLeaf := node.IsLeaf()
-	node.Release()
+	found, _, err := bt.delete(path, root, key, uuid)
+	if err != nil || found == false || path.boot == false {
+		return found, err
+	}
 
-	if isLeaf {
-		// 如果是叶子节点，无法继续向下寻找，直接插入
-		newNodeUUID, newNodeKey, err = bt.insertAndSplit(nodeUUID, uuid, key)
-	} else {
-		// 获取要插入的下一个节点
-		var next utils.UUID
-		next, err = bt.searchNext(nodeUUID, key)
+	// 仍持有bootLock, 根节点只剩一个son时, 以该son作为新的根节点, 直到根节点为叶子节点
+	// 合并后的son可能仍被持有
+	for root.IsLeaf() == false && len(root.Entries()) == 1 {
+		son := root.Entries()[0].son
+		if root = path.find(son); root == nil {
+			root, err = bt.latchNode(path, son)
+			if err != nil {
+				return true, err
+			}
+		}
+		bt.setRootUUID(son)
+	}
+	return true, nil
+}
+
+// delete 从u的子树中删除(key, uuid), 调用者持有u的写latch.
+// 返回是否找到, 以及删除后u是否过小. 如果u的latch在删除过程中被释放, 则u不会被修改, 也不会过小.
+// 相同的key可能分布在多个son中, 所以从第一个可能包含key的son开始, 依次向右查找.
+func (bt *bPlusTree) delete(path *latchPath, u *node, key []byte, uuid utils.UUID) (bool, bool, error) {
+	entries := u.Entries()
+	if u.IsLeaf() {
+		for i, e := range entries {
+			if e.son == uuid && bt.compare(e.key, key) == 0 {
+				entries = append(entries[:i], entries[i+1:]...)
+				u.SetEntries(entries, u.Sibling())
+				return true, u.Underflow(), nil
+			}
+		}
+		return false, false, nil
+	}
+
+	for kth := u.SearchSon(entries, key); kth >= 0 && kth < len(entries); kth++ {
+		son, err := bt.latchNode(path, entries[kth].son)
 		if err != nil {
-			return
+			return false, false, err
+		}
+		// key小于son的上界时, 右边的son中不会有key, 不需要再回到u
+		last := bt.compare(key, entries[kth].key) < 0
+		if last && son.DeleteSafe() {
+			path.releaseAbove(son)
 		}
-		// 插入下一个节点
-		var newSonUUId utils.UUID
-		var newSonKey utils.UUID
-		newSonUUId, newSonKey, err = bt.insert(next, uuid, key)
+
+		found, underflow, err := bt.delete(path, son, key, uuid)
 		if err != nil {
-			return
+			return false, false, err
 		}
-		// 插入下一个节点以后，如果有分裂，继续插入向上分裂
-		if newSonUUId != utils.NilUUID { // split
-			newNodeUUID, newNodeKey, err = bt.insertAndSplit(nodeUUID, newSonUUId, newSonKey)
+		if found {
+			if path.holds(u) == false || underflow == false {
+				return true, false, nil
+			}
+			err = bt.rebalance(path, u, kth, son)
+			if err != nil {
+				return true, false, err
+			}
+			return true, u.Underflow(), nil
+		}
+		path.release(son)
+		if last {
+			break
 		}
 	}
-	return
+	return false, false, nil
 }
 
-// insertAndSplit
-// 函数从node开始, 不断的向右试探兄弟节点, 直到找到一个节点, 能够插入进对应的值
-func (bt *bPlusTree) insertAndSplit(nodeUUID, uuid, key utils.UUID) (utils.UUID, utils.UUID, error) {
-	for {
-		node, err := loadNode(bt, nodeUUID)
-		if err != nil {
-			return utils.NilUUID, utils.NilUUID, err
-		}
-		siblingSon, newNodeSon, newNodeKey, err := node.InsertAndSplit(uuid, key)
-		node.Release()
+/*
+	rebalance 处理u中第kth个son过小的情况, 调用者持有u和son的写latch.
+	将son与其右边(最右边的son则为左边)的兄弟节点left, right的entry放在一起, 如果一个节点能够容纳,
+	则全部放入left, 否则将它们平分到left和一个新的节点中, 新节点替换right.
+	right的内容不会被修改, 只是不再被引用, 这样读者无论是通过父节点, 还是通过left的sibling到达right,
+	都能读到一份完整的数据. 和被删除的索引一样, right所占用的空间不会被释放.
+	平分后left的上界可能变长, 如果u因此放不下, 则不做平衡, 过小的节点不影响正确性.
+*/
+func (bt *bPlusTree) rebalance(path *latchPath, u *node, kth int, son *node) error {
+	entries := u.Entries()
+	if len(entries) < 2 {
+		return nil
+	}
 
-		if siblingSon != utils.NilUUID { // 继续向sibling尝试
-			nodeUUID = siblingSon
-		} else {
-			return newNodeSon, newNodeKey, err
-		}
+	var left, right *node
+	var err error
+	if kth == len(entries)-1 {
+		kth--
+		left, err = bt.latchNode(path, entries[kth].son)
+		right = son
+	} else {
+		left = son
+		right, err = bt.latchNode(path, entries[kth+1].son)
+	}
+	if err != nil {
+		return err
+	}
+
+	merged := append(left.Entries(), right.Entries()...)
+	if entriesSize(bt.varKey, merged) <= _NODE_SIZE { // 合并到left
+		left.SetEntries(merged, right.Sibling())
+		entries[kth].key = entries[kth+1].key
+		entries = append(entries[:kth+1], entries[kth+2:]...)
+		u.SetEntries(entries, u.Sibling())
+		return nil
 	}
 
+	// 平分到left和新节点中
+	mid := splitPoint(bt.varKey, merged)
+	newEntries := append([]nodeEntry(nil), entries...)
+	newEntries[kth].key = merged[mid-1].key
+	if entriesSize(bt.varKey, newEntries) > _NODE_SIZE {
+		return nil
+	}
+	raw := newNodeRaw(left.IsLeaf(), bt.varKey, right.Sibling(), merged[mid:])
+	newSon, err := bt.DataManager.Insert(tm.SUPER_TRANSACTION_ID, raw)
+	if err != nil {
+		return err
+	}
+	left.SetEntries(merged[:mid], newSon)
+	newEntries[kth+1].son = newSon
+	u.SetEntries(newEntries, u.Sibling())
+	return nil
 }
 
-// searchNext
-// 从nodeUUID对应节点开始, 不断的向右试探兄弟节点, 找到对应key的next uuid
-func (bt *bPlusTree) searchNext(nodeUUID, key utils.UUID) (utils.UUID, error) {
+func (bt *bPlusTree) SearchKeyRange(leftKey, rightKey []byte) ([]utils.UUID, error) {
+	var uuids []utils.UUID
+	err := bt.ScanKey(leftKey, rightKey, false, func(_ []byte, uuid utils.UUID) (bool, error) {
+		uuids = append(uuids, uuid)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uuids, nil
+}
+
+// ScanKey 按key从小到大的顺序, 将[leftKey, rightKey]中的key和uuid依次交给handle,
+// desc为true时则从大到小. handle返回false时停止.
+func (bt *bPlusTree) ScanKey(leftKey, rightKey []byte, desc bool, handle func(key []byte, uuid utils.UUID) (bool, error)) error {
+	it, err := bt.IterateKey(leftKey, rightKey, desc)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
 	for {
-		node, err := loadNode(bt, nodeUUID)
-		if err != nil {
-			return utils.NilUUID, err
+		key, uuid, ok, err := it.Next()
+		if err != nil || ok == false {
+			return err
 		}
-		next, siblingUUID := node.SearchNext(key)
-		node.Release()
-		// 找得到
-		if next != utils.NilUUID {
-			return next, nil
+		goOn, err := handle(key, uuid)
+		if err != nil || goOn == false {
+			return err
 		}
-		// 找不到继续向兄弟节点找
-		nodeUUID = siblingUUID
 	}
 }
 
-// rootUUID
-// 获取根节点地址
-func (bt *bPlusTree) rootUUID() utils.UUID {
-	bt.bootLock.Lock()
-	defer bt.bootLock.Unlock()
-	return utils.ParseUUID(bt.bootDataItem.Data())
+// IterateKey 返回遍历[leftKey, rightKey]的迭代器, desc为true时从大到小遍历
+func (bt *bPlusTree) IterateKey(leftKey, rightKey []byte, desc bool) (Iterator, error) {
+	return newIterator(bt, leftKey, rightKey, desc)
+}
+
+// rLatchRoot
+// 获取根节点的读latch
+func (bt *bPlusTree) rLatchRoot() (*node, error) {
+	bt.bootLock.RLock()
+	defer bt.bootLock.RUnlock()
+
+	root, err := loadNode(bt, utils.ParseUUID(bt.bootDataItem.Data()))
+	if err != nil {
+		return nil, err
+	}
+	root.RLatch()
+	return root, nil
 }
 
-// updateRootUUID
-// 更新该树的根节点
-func (bt *bPlusTree) updateRootUUID(left, right, rightKey utils.UUID) error {
+// latchRoot
+// 获取bootLock和根节点的写latch, 并记录在path中
+func (bt *bPlusTree) latchRoot(path *latchPath) (*node, error) {
 	bt.bootLock.Lock()
-	defer bt.bootLock.Unlock()
+	path.boot = true
+	return bt.latchNode(path, utils.ParseUUID(bt.bootDataItem.Data()))
+}
 
-	// 创建新根节点
-	rootRaw := newRootRaw(left, right, rightKey)
-	// 插入并获取新根节点uuid
-	newRootUUID, err := bt.DataManager.Insert(tm.SUPER_TRANSACTION_ID, rootRaw)
+// latchNode 读入uuid对应的节点, 获取其写latch, 并记录在path中
+func (bt *bPlusTree) latchNode(path *latchPath, uuid utils.UUID) (*node, error) {
+	u, err := loadNode(bt, uuid)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// 拷贝到boot
+	u.Latch()
+	path.nodes = append(path.nodes, u)
+	return u, nil
+}
+
+// setRootUUID
+// 将该树的根节点设置为rootUUID, 调用者持有bootLock的写锁
+func (bt *bPlusTree) setRootUUID(rootUUID utils.UUID) {
 	bt.bootDataItem.Before()
-	copy(bt.bootDataItem.Data(), utils.UUIDToRaw(newRootUUID))
+	copy(bt.bootDataItem.Data(), utils.UUIDToRaw(rootUUID))
 	bt.bootDataItem.After(tm.SUPER_TRANSACTION_ID)
-	return nil
+}
+
+// rLatchSon 在持有u的读latch时, 获取uuid对应的子节点的读latch, 并释放u
+func (bt *bPlusTree) rLatchSon(u *node, uuid utils.UUID) (*node, error) {
+	son, err := loadNode(bt, uuid)
+	if err == nil {
+		son.RLatch()
+	}
+	u.RUnlatch()
+	u.Release()
+	return son, err
 }
 
 // searchLeaf
-// 根据key, 在nodeUUID代表节点的子树中搜索, 直到找到其对应的叶节点地址.
-func (bt *bPlusTree) searchLeaf(nodeUUID, key utils.UUID) (utils.UUID, error) {
-	// 读取node
-	node, err := loadNode(bt, nodeUUID)
+// 从根节点开始, 以latch coupling的方式搜索key所在的叶节点, 返回其entry和sibling.
+// key为nil时找到最左边的叶节点.
+func (bt *bPlusTree) searchLeaf(key []byte) ([]nodeEntry, utils.UUID, error) {
+	u, err := bt.rLatchRoot()
 	if err != nil {
-		return utils.NilUUID, err
+		return nil, utils.NilUUID, err
 	}
-	isLeaf := node.IsLeaf()
-	node.Release()
+	for u.IsLeaf() == false {
+		entries := u.Entries()
+		kth := u.SearchSon(entries, key)
+		utils.Assert(kth >= 0)
+		u, err = bt.rLatchSon(u, entries[kth].son)
+		if err != nil {
+			return nil, utils.NilUUID, err
+		}
+	}
+	entries, sibling := u.Entries(), u.Sibling()
+	u.RUnlatch()
+	u.Release()
+	return entries, sibling, nil
+}
 
-	if isLeaf {
-		// 找到对应的叶子节点
-		return nodeUUID, nil
-	} else {
-		// 找到key所在的下一个节点
-		next, err := bt.searchNext(nodeUUID, key)
+// readLeaf 读出叶节点uuid的entry和sibling
+func (bt *bPlusTree) readLeaf(uuid utils.UUID) ([]nodeEntry, utils.UUID, error) {
+	u, err := loadNode(bt, uuid)
+	if err != nil {
+		return nil, utils.NilUUID, err
+	}
+	u.RLatch()
+	entries, sibling := u.Entries(), u.Sibling()
+	u.RUnlatch()
+	u.Release()
+	return entries, sibling, nil
+}
+
+/*
+	searchBefore 找到最后一个小于key(inclusive为true时为不大于)的entry所在的叶节点,
+	返回该叶节点中所有小于(不大于)key的entry, 不存在这样的entry时返回nil. key为nil时表示INF.
+	son[i]中的key都在[key[i-1], key[i]]之间, 所以从最后一个key[i-1]小于key的son开始, 向左依次查找.
+	查找过程中持有从根节点到当前节点路径上所有节点的读latch.
+*/
+func (bt *bPlusTree) searchBefore(key []byte, inclusive bool) ([]nodeEntry, error) {
+	root, err := bt.rLatchRoot()
+	if err != nil {
+		return nil, err
+	}
+	return bt.searchBeforeIn(root, key, inclusive)
+}
+
+// searchBeforeIn 在u的子树中进行searchBefore, 调用者持有u的读latch, 该函数会释放它.
+func (bt *bPlusTree) searchBeforeIn(u *node, key []byte, inclusive bool) ([]nodeEntry, error) {
+	defer func() {
+		u.RUnlatch()
+		u.Release()
+	}()
+	before := func(k []byte) bool {
+		c := bt.compare(k, key)
+		return c < 0 || (inclusive && c == 0)
+	}
+
+	entries := u.Entries()
+	if u.IsLeaf() {
+		end := 0
+		for end < len(entries) && before(entries[end].key) {
+			end++
+		}
+		if end == 0 {
+			return nil, nil
+		}
+		return entries[:end], nil
+	}
+
+	kth := len(entries) - 1
+	for kth > 0 && before(entries[kth-1].key) == false {
+		kth--
+	}
+	for ; kth >= 0; kth-- {
+		son, err := loadNode(bt, entries[kth].son)
 		if err != nil {
-			return utils.NilUUID, err
+			return nil, err
 		}
-		// 向next中继续查找
-		return bt.searchLeaf(next, key)
+		son.RLatch()
+		result, err := bt.searchBeforeIn(son, key, inclusive)
+		if err != nil || result != nil {
+			return result, err
+		}
+	}
+	return nil, nil
+}
+
+// latchPath 记录了一个写者持有的latch, 按从上到下的顺序排列
+type latchPath struct {
+	bt    *bPlusTree
+	boot  bool    // 是否持有bootLock
+	nodes []*node // 持有写latch的节点
+}
+
+// find 返回持有latch的uuid节点, 没有持有时返回nil
+func (path *latchPath) find(uuid utils.UUID) *node {
+	for _, u := range path.nodes {
+		if u.selfUUID == uuid {
+			return u
+		}
+	}
+	return nil
+}
+
+// holds 判断是否持有u的latch
+func (path *latchPath) holds(u *node) bool {
+	for _, tmp := range path.nodes {
+		if tmp == u {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseAbove 释放u之前获取的所有latch, 包括bootLock
+func (path *latchPath) releaseAbove(u *node) {
+	if path.boot {
+		path.bt.bootLock.Unlock()
+		path.boot = false
+	}
+	for i, tmp := range path.nodes {
+		if tmp == u {
+			for _, v := range path.nodes[:i] {
+				v.Unlatch()
+				v.Release()
+			}
+			path.nodes = append(path.nodes[:0:0], path.nodes[i:]...)
+			return
+		}
+	}
+}
+
+// release 释放u的latch, 如果没有持有则忽略
+func (path *latchPath) release(u *node) {
+	for i, tmp := range path.nodes {
+		if tmp == u {
+			u.Unlatch()
+			u.Release()
+			path.nodes = append(path.nodes[:i:i], path.nodes[i+1:]...)
+			return
+		}
+	}
+}
+
+// releaseAll 释放所有的latch
+func (path *latchPath) releaseAll() {
+	for _, u := range path.nodes {
+		u.Unlatch()
+		u.Release()
+	}
+	path.nodes = nil
+	if path.boot {
+		path.bt.bootLock.Unlock()
+		path.boot = false
 	}
 }
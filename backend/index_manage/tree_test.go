@@ -0,0 +1,172 @@
+package index_manage
+
+import (
+	"bytes"
+	"fansDB/backend/utils"
+	"fmt"
+	"testing"
+)
+
+// scanEntries 通过ScanKey读出[leftKey, rightKey]中的所有entry
+func scanEntries(t *testing.T, bt *bPlusTree, leftKey, rightKey []byte, desc bool) []Entry {
+	var entries []Entry
+	err := bt.ScanKey(leftKey, rightKey, desc, func(key []byte, uuid utils.UUID) (bool, error) {
+		entries = append(entries, Entry{key, uuid})
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// underflowNodes 返回bt中过小的非根节点的数量
+func underflowNodes(t *testing.T, bt *bPlusTree) int {
+	var count func(u *node, isRoot bool) int
+	count = func(u *node, isRoot bool) int {
+		defer func() {
+			u.RUnlatch()
+			u.Release()
+		}()
+		n := 0
+		if isRoot == false && u.Underflow() {
+			n++
+		}
+		if u.IsLeaf() {
+			return n
+		}
+		for _, e := range u.Entries() {
+			son, err := loadNode(bt, e.son)
+			if err != nil {
+				t.Fatal(err)
+			}
+			son.RLatch()
+			n += count(son, false)
+		}
+		return n
+	}
+	root, err := bt.rLatchRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count(root, true)
+}
+
+// mustDelete 从bt中删除第i个key, 其uuid为i+1
+func mustDelete(t *testing.T, bt *bPlusTree, i int) {
+	key := []byte(fmt.Sprintf("key%06d", i))
+	ok, err := bt.DeleteKey(key, utils.UUID(i+1))
+	if err != nil || ok == false {
+		t.Fatalf("delete %s: %v, %v", key, ok, err)
+	}
+}
+
+// TestDeleteRebalance 检查删除后过小的节点与兄弟节点平衡或合并, 树逐渐变矮, 直到根节点为叶节点,
+// 每一步之后树的结构都是正确的, 剩下的entry都能被读到.
+func TestDeleteRebalance(t *testing.T) {
+	const n = 2000
+	bt := newTestTree(t, openTestDM(t))
+	fillTree(t, bt, n)
+	leaves := mustVerify(t, bt).Leaves
+
+	live := make(map[int]bool)
+	for i := 0; i < n; i++ {
+		live[i] = true
+	}
+	phases := []struct {
+		name   string
+		delete func(i int) bool
+	}{
+		{"odd keys", func(i int) bool { return i%2 == 1 }},
+		{"leftmost keys", func(i int) bool { return i < 1200 }},
+		{"rightmost keys", func(i int) bool { return i >= 1800 }},
+		{"all but one", func(i int) bool { return i != 1500 }},
+	}
+	for _, phase := range phases {
+		for i := 0; i < n; i++ {
+			if live[i] && phase.delete(i) {
+				mustDelete(t, bt, i)
+				delete(live, i)
+			}
+		}
+
+		result := mustVerify(t, bt)
+		if len(result.Violations) > 0 {
+			t.Fatalf("%s: got violations %v", phase.name, result.Violations)
+		}
+		if result.Entries != len(live) {
+			t.Errorf("%s: got %d entries, expected %d", phase.name, result.Entries, len(live))
+		}
+		if m := underflowNodes(t, bt); m > 0 {
+			t.Errorf("%s: %d nodes underflow", phase.name, m)
+		}
+		if result.Leaves > leaves {
+			t.Errorf("%s: leaves grew from %d to %d", phase.name, leaves, result.Leaves)
+		}
+		leaves = result.Leaves
+		entries := scanEntries(t, bt, nil, nil, false)
+		for j, e := range entries {
+			i := int(e.UUID) - 1
+			if live[i] == false || bytes.Equal(e.Key, []byte(fmt.Sprintf("key%06d", i))) == false {
+				t.Fatalf("%s: got entry (%s, %d)", phase.name, e.Key, e.UUID)
+			}
+			if j > 0 && int(entries[j-1].UUID) >= int(e.UUID) {
+				t.Fatalf("%s: entries not sorted at %d", phase.name, j)
+			}
+		}
+	}
+
+	result := mustVerify(t, bt)
+	if result.Depth != 1 || result.Nodes != 1 {
+		t.Errorf("got depth %d, %d nodes, expected a single leaf", result.Depth, result.Nodes)
+	}
+	if ok, err := bt.DeleteKey([]byte("key001500"), 9999); err != nil || ok {
+		t.Errorf("delete with another uuid: got %v, %v", ok, err)
+	}
+	if ok, err := bt.DeleteKey([]byte("key000001"), 2); err != nil || ok {
+		t.Errorf("delete a deleted key: got %v, %v", ok, err)
+	}
+	mustDelete(t, bt, 1500)
+	if entries := scanEntries(t, bt, nil, nil, false); len(entries) != 0 {
+		t.Errorf("got %d entries in an empty tree", len(entries))
+	}
+	fillTree(t, bt, 100)
+	if result := mustVerify(t, bt); len(result.Violations) > 0 || result.Entries != 100 {
+		t.Errorf("refill: got %d entries, violations %v", result.Entries, result.Violations)
+	}
+}
+
+// TestDeleteDuplicateKey 检查同一个key的entry跨越多个叶节点时, 可以删除其中任意一个uuid
+func TestDeleteDuplicateKey(t *testing.T) {
+	const n = 1500
+	bt := newTestTree(t, openTestDM(t))
+	fillTree(t, bt, 200)
+	dup := []byte("key000100x")
+	for i := 0; i < n; i++ {
+		if err := bt.InsertKey(dup, utils.UUID(10000+i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result := mustVerify(t, bt); result.Leaves < 3 {
+		t.Fatalf("got %d leaves, expected the key to span several leaves", result.Leaves)
+	}
+
+	for i := n - 1; i >= 0; i -= 3 {
+		ok, err := bt.DeleteKey(dup, utils.UUID(10000+i))
+		if err != nil || ok == false {
+			t.Fatalf("delete uuid %d: %v, %v", 10000+i, ok, err)
+		}
+	}
+	result := mustVerify(t, bt)
+	if len(result.Violations) > 0 {
+		t.Fatalf("got violations %v", result.Violations)
+	}
+	if expected := 200 + n - n/3; result.Entries != expected {
+		t.Errorf("got %d entries, expected %d", result.Entries, expected)
+	}
+	for _, e := range scanEntries(t, bt, dup, dup, false) {
+		if (int(e.UUID)-10000)%3 == (n-1)%3 {
+			t.Errorf("deleted uuid %d is still in the tree", e.UUID)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+/*
+	verify.go 检查一棵B+树的结构是否正确:
+		1. 每个节点中的key有序, 且都在父节点给出的[下界, 上界]之间, 非叶子节点的最后一个key等于其上界;
+		2. 所有叶节点的深度相同;
+		3. 叶节点的sibling依次指向其右边的叶节点, 最右边的叶节点没有sibling;
+		4. 节点的大小不超过_NODE_SIZE, 非叶子节点不为空, 根节点不是只有一个son的非叶子节点.
+	节点过小不影响正确性(见rebalance), 所以不作为错误.
+	hash索引的检查见verifyHash.
+
+	检查时以latch coupling的方式持有从根节点到当前节点路径上所有节点的读latch,
+	所以可以和其他读写并发进行, 检查结果对应于各个子树被访问时的状态.
+*/
+package index_manage
+
+import (
+	"fansDB/backend/utils"
+	"fmt"
+)
+
+// VerifyResult 为一个索引的检查结果.
+// 对hash索引, 节点为包括溢出的bucket在内的所有bucket, 叶节点为链的数目, 深度为level.
+type VerifyResult struct {
+	Nodes      int      // 节点数
+	Leaves     int      // 叶节点数
+	Depth      int      // 叶节点的深度, 根节点为叶节点时为1
+	Entries    int      // 叶节点中entry的总数
+	Violations []string // 发现的问题
+}
+
+// Verify 检查索引ix的结构, 见verify.go
+func Verify(ix Index) (*VerifyResult, error) {
+	if h, ok := ix.(*hashIndex); ok {
+		return verifyHash(h)
+	}
+	bt, ok := ix.(*bPlusTree)
+	if ok == false {
+		return nil, ErrInvalidTree
+	}
+	v := &verifier{bt: bt, result: &VerifyResult{}}
+	root, err := bt.rLatchRoot()
+	if err != nil {
+		return nil, err
+	}
+	err = v.walk(root, nil, nil, 1, true)
+	if err != nil {
+		return nil, err
+	}
+	if v.sibling != utils.NilUUID {
+		v.report("rightmost leaf %d has sibling %d", v.lastLeaf, v.sibling)
+	}
+	return v.result, nil
+}
+
+// verifier 记录了检查过程中的状态
+type verifier struct {
+	bt     *bPlusTree
+	result *VerifyResult
+
+	lastLeaf utils.UUID // 上一个访问的叶节点
+	sibling  utils.UUID // 上一个访问的叶节点的sibling, 应当为下一个叶节点
+}
+
+// _MAX_VIOLATIONS 最多记录的问题数, 之后的问题被忽略
+const _MAX_VIOLATIONS = 100
+
+func (v *verifier) report(format string, args ...interface{}) {
+	if len(v.result.Violations) < _MAX_VIOLATIONS {
+		v.result.Violations = append(v.result.Violations, fmt.Sprintf(format, args...))
+	}
+}
+
+// walk 检查u的子树, u中的key应当在[lower, upper]之间. 调用者持有u的读latch, 该函数会释放它.
+func (v *verifier) walk(u *node, lower, upper []byte, depth int, isRoot bool) error {
+	defer func() {
+		u.RUnlatch()
+		u.Release()
+	}()
+	bt := v.bt
+	entries := u.Entries()
+	v.result.Nodes++
+
+	if entriesSize(bt.varKey, entries) > _NODE_SIZE {
+		v.report("node %d exceeds node size", u.selfUUID)
+	}
+	for i, e := range entries {
+		if i > 0 && bt.compare(entries[i-1].key, e.key) > 0 {
+			v.report("node %d: keys not sorted at %d", u.selfUUID, i)
+		}
+		if (lower != nil && bt.compare(e.key, lower) < 0) || bt.compare(e.key, upper) > 0 {
+			v.report("node %d: key at %d out of bounds", u.selfUUID, i)
+		}
+	}
+
+	if u.IsLeaf() {
+		v.result.Leaves++
+		v.result.Entries += len(entries)
+		if v.result.Depth == 0 {
+			v.result.Depth = depth
+		} else if v.result.Depth != depth {
+			v.report("leaf %d at depth %d, expected %d", u.selfUUID, depth, v.result.Depth)
+		}
+		if v.result.Leaves > 1 && v.sibling != u.selfUUID {
+			v.report("leaf %d has sibling %d, expected %d", v.lastLeaf, v.sibling, u.selfUUID)
+		}
+		v.lastLeaf, v.sibling = u.selfUUID, u.Sibling()
+		return nil
+	}
+
+	if len(entries) == 0 {
+		v.report("internal node %d is empty", u.selfUUID)
+		return nil
+	}
+	if isRoot && len(entries) == 1 {
+		v.report("root %d has a single son", u.selfUUID)
+	}
+	if bt.compare(entries[len(entries)-1].key, upper) != 0 {
+		v.report("internal node %d: last key differs from its bound", u.selfUUID)
+	}
+	for i, e := range entries {
+		son, err := loadNode(bt, e.son)
+		if err != nil {
+			return err
+		}
+		son.RLatch()
+		if i > 0 {
+			lower = entries[i-1].key
+		}
+		err = v.walk(son, lower, e.key, depth+1, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	verifyHash 检查一个hash索引的结构:
+		1. 每个entry都在其hash值对应的bucket中(见hashIndex.bucketOf);
+		2. 每个bucket的entry数不超过_BUCKET_CAPACITY, 且链中没有环.
+	检查时持有dirLock的读锁, 插入和删除只需要它的读锁, 所以检查结果对应于各条链被访问时的状态.
+*/
+func verifyHash(h *hashIndex) (*VerifyResult, error) {
+	h.dirLock.RLock()
+	defer h.dirLock.RUnlock()
+
+	result := &VerifyResult{Depth: h.level(), Leaves: h.buckets()}
+	report := func(format string, args ...interface{}) {
+		if len(result.Violations) < _MAX_VIOLATIONS {
+			result.Violations = append(result.Violations, fmt.Sprintf(format, args...))
+		}
+	}
+	for i := 0; i < result.Leaves; i++ {
+		first, err := h.readSlot(i)
+		if err != nil {
+			return nil, err
+		}
+		visited := map[utils.UUID]bool{}
+		for uuid := first; uuid != utils.NilUUID; {
+			if visited[uuid] {
+				report("bucket %d: chain has a cycle at %d", i, uuid)
+				break
+			}
+			visited[uuid] = true
+			b, err := h.readDataItem(uuid)
+			if err != nil {
+				return nil, err
+			}
+			b.RLock()
+			raw := b.Data()
+			n, next := getBucketCount(raw), getBucketOverflow(raw)
+			var entries []hashEntry
+			if n <= _BUCKET_CAPACITY {
+				entries = getBucketEntries(raw)
+			}
+			b.RUnlock()
+			b.Release()
+
+			result.Nodes++
+			result.Entries += len(entries)
+			if n > _BUCKET_CAPACITY {
+				report("bucket %d: %d exceeds bucket capacity", i, uuid)
+			}
+			for j, e := range entries {
+				if h.bucketOf(hashKey(e.key)) != i {
+					report("bucket %d: entry at %d of %d in wrong bucket", i, j, uuid)
+				}
+			}
+			uuid = next
+		}
+	}
+	return result, nil
+}
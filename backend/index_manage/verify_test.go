@@ -0,0 +1,176 @@
+package index_manage
+
+import (
+	"fansDB/backend/data_manage"
+	tm "fansDB/backend/transaction_manage"
+	"fansDB/backend/utils"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	_TEST_MEM = (1 << 20) * 16 // 16MB
+)
+
+// openTestDM 在临时目录中创建一个DM, 测试结束时被关闭
+func openTestDM(t *testing.T) data_manage.DataManager {
+	path := filepath.Join(t.TempDir(), "index")
+	tmr := tm.Create(path)
+	dmr := data_manage.Create(path, _TEST_MEM, tmr)
+	t.Cleanup(func() {
+		dmr.Close()
+		tmr.Close()
+	})
+	return dmr
+}
+
+// newTestTree 创建一棵空的B+树
+func newTestTree(t *testing.T, dmr data_manage.DataManager) *bPlusTree {
+	bootUUID, err := Create(dmr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bt, err := Load(bootUUID, dmr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bt.(*bPlusTree)
+}
+
+// fillTree 向bt中插入n个不同的key, 第i个entry的uuid为i+1
+func fillTree(t *testing.T, bt *bPlusTree, n int) {
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i))
+		if err := bt.InsertKey(key, utils.UUID(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// mustVerify 检查ix, 返回发现的问题
+func mustVerify(t *testing.T, ix Index) *VerifyResult {
+	result, err := Verify(ix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// expectViolation 检查result中有包含substr的问题
+func expectViolation(t *testing.T, result *VerifyResult, substr string) {
+	for _, v := range result.Violations {
+		if strings.Contains(v, substr) {
+			return
+		}
+	}
+	t.Errorf("got violations %v, expected one containing %q", result.Violations, substr)
+}
+
+// leftmostLeaf 返回bt最左边的叶节点, 调用者需要释放它
+func leftmostLeaf(t *testing.T, bt *bPlusTree) *node {
+	u, err := bt.rLatchRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for u.IsLeaf() == false {
+		son, err := bt.rLatchSon(u, u.Entries()[0].son)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u = son
+	}
+	u.RUnlatch()
+	return u
+}
+
+func TestVerifyTree(t *testing.T) {
+	bt := newTestTree(t, openTestDM(t))
+	fillTree(t, bt, 2000)
+
+	result := mustVerify(t, bt)
+	if len(result.Violations) > 0 {
+		t.Fatalf("got violations %v", result.Violations)
+	}
+	if result.Entries != 2000 {
+		t.Errorf("got %d entries, expected 2000", result.Entries)
+	}
+	if result.Depth < 2 || result.Leaves < 2 || result.Nodes <= result.Leaves {
+		t.Errorf("got depth %d, %d leaves, %d nodes, expected a tree of several levels",
+			result.Depth, result.Leaves, result.Nodes)
+	}
+}
+
+// TestVerifyUnsortedLeaf 检查叶节点中乱序的key被发现
+func TestVerifyUnsortedLeaf(t *testing.T) {
+	bt := newTestTree(t, openTestDM(t))
+	fillTree(t, bt, 2000)
+
+	u := leftmostLeaf(t, bt)
+	u.Latch()
+	entries := u.Entries()
+	entries[0], entries[1] = entries[1], entries[0]
+	u.SetEntries(entries, u.Sibling())
+	u.Unlatch()
+	u.Release()
+
+	expectViolation(t, mustVerify(t, bt), "keys not sorted")
+}
+
+// TestVerifyBrokenSibling 检查叶节点的sibling断开后被发现
+func TestVerifyBrokenSibling(t *testing.T) {
+	bt := newTestTree(t, openTestDM(t))
+	fillTree(t, bt, 2000)
+
+	u := leftmostLeaf(t, bt)
+	u.Latch()
+	u.SetEntries(u.Entries(), utils.NilUUID)
+	u.Unlatch()
+	u.Release()
+
+	expectViolation(t, mustVerify(t, bt), fmt.Sprintf("leaf %d has sibling 0", u.selfUUID))
+}
+
+// TestVerifyHashWrongBucket 检查交换两个bucket的链之后, 其中的entry被发现不在它们的hash值对应的bucket中
+func TestVerifyHashWrongBucket(t *testing.T) {
+	dmr := openTestDM(t)
+	rootUUID, err := CreateHash(dmr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix, err := LoadHash(rootUUID, dmr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 1000; i++ {
+		if err := ix.Insert(utils.UUID(i), utils.UUID(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result := mustVerify(t, ix)
+	if len(result.Violations) > 0 {
+		t.Fatalf("got violations %v", result.Violations)
+	}
+	if result.Entries != 1000 || result.Leaves < 2 {
+		t.Fatalf("got %d entries in %d buckets", result.Entries, result.Leaves)
+	}
+
+	h := ix.(*hashIndex)
+	first, err := h.readSlot(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := h.readSlot(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.writeSlot(0, second); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.writeSlot(1, first); err != nil {
+		t.Fatal(err)
+	}
+	expectViolation(t, mustVerify(t, ix), "in wrong bucket")
+}
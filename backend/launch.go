@@ -1,11 +1,108 @@
-package backend
+/*
+	launch.go 为数据库服务端的启动入口, 服务监听在_ADDRESS.
+
+	go run launch.go -create=/tmp/anyDb   创建一个新的数据库并启动服务
+	go run launch.go -open=/tmp/anyDb     打开一个已有的数据库并启动服务
+	go run launch.go -verify=/tmp/anyDb   打开一个已有的数据库, 检查其中所有的表和索引后退出, 不启动服务,
+	                                      发现问题时以状态码1退出
+	三个参数按-verify, -create, -open的顺序取第一个被指定的, 都没有指定时打开_PATH处已有的数据库并启动服务.
+*/
+package main
+
+import (
+	dm "fansDB/backend/data_manage"
+	statement "fansDB/backend/parser"
+	"fansDB/backend/server"
+	tbm "fansDB/backend/table_manage"
+	tm "fansDB/backend/transaction_manage"
+	sm "fansDB/backend/version_manage"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
 
 const (
 	_NET     = "tcp"
 	_ADDRESS = ":8080"
 	_PATH    = "/anyDb" //存储路径，先写死
+
+	_DEFAULT_MEM = (1 << 20) * 64 // 64MB
 )
 
 func main() {
+	open := flag.String("open", "", "-open DBPath")
+	create := flag.String("create", "", "-create DBPath")
+	verify := flag.String("verify", "", "-verify DBPath")
+	flag.Parse()
+
+	if *verify != "" {
+		if verifyDB(*verify) == false {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var tableManager tbm.TableManager
+	if *create != "" {
+		tableManager = createDB(*create)
+	} else if *open != "" {
+		tableManager = openDB(*open)
+	} else {
+		tableManager = openDB(_PATH)
+	}
+
+	server.NewServer(_NET, _ADDRESS, tableManager).Start()
+}
+
+// createDB 在path处创建TM, DM, SM, TBM
+func createDB(path string) tbm.TableManager {
+	transactionManager := tm.Create(path)
+	dataManager := dm.Create(path, _DEFAULT_MEM, transactionManager)
+	serializabilityManager := sm.NewSerializabilityManager(transactionManager, dataManager)
+	return tbm.Create(path, transactionManager, serializabilityManager, dataManager)
+}
+
+// openDB 打开path处已有的TM, DM, SM, TBM
+func openDB(path string) tbm.TableManager {
+	transactionManager := tm.Open(path)
+	dataManager := dm.Open(path, _DEFAULT_MEM, transactionManager)
+	serializabilityManager := sm.NewSerializabilityManager(transactionManager, dataManager)
+	return tbm.Open(path, transactionManager, serializabilityManager, dataManager)
+}
+
+// verifyDB 打开path处的数据库, 打印所有表的检查结果, 返回是否没有发现问题.
+// 检查结束后关闭DM和TM, 将检查期间的修改(如恢复的结果)写回磁盘.
+func verifyDB(path string) bool {
+	transactionManager := tm.Open(path)
+	dataManager := dm.Open(path, _DEFAULT_MEM, transactionManager)
+	serializabilityManager := sm.NewSerializabilityManager(transactionManager, dataManager)
+	tableManager := tbm.Open(path, transactionManager, serializabilityManager, dataManager)
+	defer func() {
+		dataManager.Close()
+		transactionManager.Close()
+	}()
+
+	xid, _ := tableManager.Begin(&statement.Begin{})
+	problems, err := tableManager.VerifyAll(xid)
+	tableManager.Abort(xid)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
 
+	var names []string
+	for name := range problems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, problem := range problems[name] {
+			fmt.Println(name + ": " + problem)
+		}
+	}
+	if len(problems) == 0 {
+		fmt.Println("ok")
+	}
+	return len(problems) == 0
 }
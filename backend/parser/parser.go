@@ -2,11 +2,13 @@ package parser
 
 import (
 	"errors"
+	"strconv"
 )
 
 var (
-	ErrInvalidStat = errors.New("Invalid command.")
-	ErrHasNoIndex  = errors.New("Table has no index.")
+	ErrInvalidStat       = errors.New("Invalid command.")
+	ErrHasNoIndex        = errors.New("Table has no index.")
+	ErrUnterminatedQuote = errors.New("Unterminated quoted string.")
 )
 
 //
@@ -36,9 +38,17 @@ func Parse(statement []byte) (interface{}, error) {
 	case "abort":
 		stat, staterr = parseAbort(tokener)
 	case "create":
-		stat, staterr = parseCreate(tokener)
+		if isIndex(tokener) {
+			stat, staterr = parseCreateIndex(tokener)
+		} else {
+			stat, staterr = parseCreate(tokener)
+		}
 	case "drop":
-		stat, staterr = parseDrop(tokener)
+		if isIndex(tokener) {
+			stat, staterr = parseDropIndex(tokener)
+		} else {
+			stat, staterr = parseDrop(tokener)
+		}
 	case "read":
 		stat, staterr = parseRead(tokener)
 	case "insert":
@@ -49,6 +59,12 @@ func Parse(statement []byte) (interface{}, error) {
 		stat, staterr = parseUpdate(tokener)
 	case "show":
 		stat, staterr = parseShow(tokener)
+	case "set":
+		stat, staterr = parseSet(tokener)
+	case "vacuum":
+		stat, staterr = parseVacuum(tokener)
+	case "verify":
+		stat, staterr = parseVerify(tokener)
 	default:
 		return nil, ErrInvalidStat
 	}
@@ -74,48 +90,126 @@ func parseShow(tokener *tokener) (*Show, error) {
 	}
 }
 
-// 简单的解析
-// set tablename fieldname = value
-func parseUpdate(tokener *tokener) (*Update, error) {
-	var err error
-	update := new(Update)
-	update.TableName, err = tokener.Peek()
+// 解析vacuum
+// vacuum tablename
+func parseVacuum(tokener *tokener) (*Vacuum, error) {
+	tableName, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
+	if isName(tableName) == false {
+		return nil, ErrInvalidStat
+	}
 	tokener.Pop()
 
-	set, err := tokener.Peek()
+	vacuum := new(Vacuum)
+	vacuum.TableName = tableName
+	return vacuum, nil
+}
+
+// 解析verify
+// verify [tablename]
+func parseVerify(tokener *tokener) (*Verify, error) {
+	tableName, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if set != "set" {
+	verify := new(Verify)
+	if tableName == "" {
+		return verify, nil
+	}
+	if isName(tableName) == false {
 		return nil, ErrInvalidStat
 	}
 	tokener.Pop()
+	verify.TableName = tableName
+	return verify, nil
+}
 
-	// field = value
-	update.FieldName, err = tokener.Peek()
+// 解析会话设置, 目前只支持autocommit
+// set autocommit [=] on|off|1|0
+func parseSet(tokener *tokener) (*SetAutocommit, error) {
+	name, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
+	if name != "autocommit" {
+		return nil, ErrInvalidStat
+	}
 	tokener.Pop()
-	tmp, err := tokener.Peek()
+
+	value, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if value == "=" {
+		tokener.Pop()
+		value, err = tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	set := new(SetAutocommit)
+	if value == "on" || value == "1" {
+		set.On = true
+	} else if value == "off" || value == "0" {
+		set.On = false
+	} else {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	eof, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if tmp != "=" {
+	if eof != "" {
 		return nil, ErrInvalidStat
 	}
+	return set, nil
+}
+
+// 简单的解析
+// set tablename fieldname = value
+func parseUpdate(tokener *tokener) (*Update, error) {
+	var err error
+	update := new(Update)
+	update.TableName, err = tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
 	tokener.Pop()
-	update.Value, err = tokener.Peek()
+
+	set, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
+	if set != "set" {
+		return nil, ErrInvalidStat
+	}
 	tokener.Pop()
 
+	// field = expr, field = expr ...
+	for {
+		assignment, err := parseAssignment(tokener)
+		if err != nil {
+			return nil, err
+		}
+		update.Assignments = append(update.Assignments, assignment)
+
+		comma, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if comma != "," {
+			break
+		}
+		tokener.Pop()
+	}
+
 	// 如果没有where，直接返回
-	tmp, err = tokener.Peek()
+	tmp, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
@@ -133,6 +227,133 @@ func parseUpdate(tokener *tokener) (*Update, error) {
 	return update, nil
 }
 
+// parseAssignment 解析 field = expr
+func parseAssignment(tokener *tokener) (*Assignment, error) {
+	assignment := new(Assignment)
+
+	field, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if isName(field) == false {
+		return nil, ErrInvalidStat
+	}
+	assignment.FieldName = field
+	tokener.Pop()
+
+	eq, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if eq != "=" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	assignment.Value, err = parseExpr(tokener)
+	if err != nil {
+		return nil, err
+	}
+	return assignment, nil
+}
+
+// 解析算术表达式, 语法为:
+// expr   := term {("+" | "-") term}
+// term   := factor {("*" | "/" | "%") factor}
+// factor := "(" expr ")" | value
+func parseExpr(tokener *tokener) (*Expr, error) {
+	left, err := parseTerm(tokener)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if (op == "+" || op == "-") == false || tokener.Quoted() {
+			return left, nil
+		}
+		tokener.Pop()
+
+		right, err := parseTerm(tokener)
+		if err != nil {
+			return nil, err
+		}
+		left = &Expr{Op: op, Left: left, Right: right}
+	}
+}
+
+func parseTerm(tokener *tokener) (*Expr, error) {
+	left, err := parseFactor(tokener)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if (op == "*" || op == "/" || op == "%") == false || tokener.Quoted() {
+			return left, nil
+		}
+		tokener.Pop()
+
+		right, err := parseFactor(tokener)
+		if err != nil {
+			return nil, err
+		}
+		left = &Expr{Op: op, Left: left, Right: right}
+	}
+}
+
+func parseFactor(tokener *tokener) (*Expr, error) {
+	tmp, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	quoted := tokener.Quoted()
+
+	if tmp == "(" && quoted == false {
+		tokener.Pop()
+		expr, err := parseExpr(tokener)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if rp != ")" {
+			return nil, ErrInvalidStat
+		}
+		tokener.Pop()
+		return expr, nil
+	}
+
+	if tmp == "-" && quoted == false {
+		// 负数字面值, 或者 0 - factor
+		tokener.Pop()
+		factor, err := parseFactor(tokener)
+		if err != nil {
+			return nil, err
+		}
+		if factor.Op == "" && factor.Quoted == false && isDigital(factor.Value[0]) {
+			factor.Value = "-" + factor.Value
+			return factor, nil
+		}
+		return &Expr{Op: "-", Left: &Expr{Value: "0"}, Right: factor}, nil
+	}
+
+	if quoted == false && (tmp == "" || isSymbol(tmp[0])) {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+	return &Expr{Value: tmp, Quoted: quoted, Null: isNull(tmp, quoted)}, nil
+}
+
 func parseDelete(tokener *tokener) (*Delete, error) {
 	deleteStat := new(Delete)
 	// from
@@ -190,6 +411,18 @@ func parseInsert(tokener *tokener) (*Insert, error) {
 	insert.TableName = tableName
 	tokener.Pop()
 
+	// (a, b, ...)
+	lp, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if lp == "(" && tokener.Quoted() == false {
+		insert.Fields, err = parseNameList(tokener)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// 读取values
 	values, err := tokener.Peek()
 	if err != nil {
@@ -198,21 +431,107 @@ func parseInsert(tokener *tokener) (*Insert, error) {
 	if values != "values" {
 		return nil, ErrInvalidStat
 	}
+	tokener.Pop()
 
+	lp, err = tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if lp != "(" || tokener.Quoted() {
+		// 旧的格式: values a b c, 只有一行
+		var row []*Value
+		for {
+			value, err := tokener.Peek()
+			if err != nil {
+				return nil, err
+			}
+			if value == "" {
+				break
+			}
+			value, quoted, err := parseLiteral(tokener)
+			if err != nil {
+				return nil, err
+			}
+			row = append(row, newValue(value, quoted))
+		}
+		insert.Rows = append(insert.Rows, row)
+		return insert, nil
+	}
+
+	// (v1, v2, ...), (v1, v2, ...) ...
 	for {
-		tokener.Pop()
-		value, err := tokener.Peek()
+		values, err := parseValueList(tokener)
 		if err != nil {
 			return nil, err
 		}
-		if value == "" {
-			break
-		} else {
-			insert.Values = append(insert.Values, value)
+		var row []*Value
+		for i, value := range values.strs {
+			row = append(row, newValue(value, values.quoted[i]))
+		}
+		insert.Rows = append(insert.Rows, row)
+
+		comma, err := tokener.Peek()
+		if err != nil {
+			return nil, err
 		}
+		if comma != "," {
+			return insert, nil
+		}
+		tokener.Pop()
 	}
+}
 
-	return insert, nil
+// parseNameList 解析 (a, b, ...)
+func parseNameList(tokener *tokener) ([]string, error) {
+	values, err := parseValueList(tokener)
+	if err != nil {
+		return nil, err
+	}
+	for i, name := range values.strs {
+		if values.quoted[i] || isName(name) == false {
+			return nil, ErrInvalidStat
+		}
+	}
+	return values.strs, nil
+}
+
+// valueList 为括号中的一组值, quoted表示对应的值是否在引号中
+type valueList struct {
+	strs   []string
+	quoted []bool
+}
+
+// parseValueList 解析 (v1, v2, ...)
+func parseValueList(tokener *tokener) (*valueList, error) {
+	lp, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if lp != "(" || tokener.Quoted() {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	values := new(valueList)
+	for {
+		value, quoted, err := parseLiteral(tokener)
+		if err != nil {
+			return nil, err
+		}
+		values.strs = append(values.strs, value)
+		values.quoted = append(values.quoted, quoted)
+
+		next, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		tokener.Pop()
+		if next == ")" {
+			return values, nil
+		} else if next != "," {
+			return nil, ErrInvalidStat
+		}
+	}
 }
 
 func parseRead(tokener *tokener) (*Read, error) {
@@ -229,15 +548,12 @@ func parseRead(tokener *tokener) (*Read, error) {
 	} else {
 		// 读取多个字段
 		for {
-			field, err := tokener.Peek()
+			field, aggregate, err := parseColumn(tokener)
 			if err != nil {
 				return nil, err
 			}
-			if isName(field) == false {
-				return nil, ErrInvalidStat
-			}
 			read.Fields = append(read.Fields, field)
-			tokener.Pop()
+			read.Aggregates = append(read.Aggregates, aggregate)
 
 			// 分隔符，如果没有分隔符则退出
 			comma, err := tokener.Peek()
@@ -262,122 +578,548 @@ func parseRead(tokener *tokener) (*Read, error) {
 	}
 	tokener.Pop()
 
-	tableName, err := tokener.Peek()
-	if err != nil {
-		return nil, err
-	}
-	if isName(tableName) == false {
-		return nil, ErrInvalidStat
+	tableName, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if isName(tableName) == false {
+		return nil, ErrInvalidStat
+	}
+	read.TableName = tableName
+	tokener.Pop()
+
+	// join
+	for {
+		join, err := parseJoin(tokener)
+		if err != nil {
+			return nil, err
+		}
+		if join == nil {
+			break
+		}
+		read.Joins = append(read.Joins, join)
+	}
+
+	// where
+	tmp, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if tmp == "where" {
+		where, err := parseWhere(tokener)
+		if err != nil {
+			return nil, err
+		}
+		read.Where = where
+	}
+
+	// group by
+	read.GroupBy, err = parseGroupBy(tokener)
+	if err != nil {
+		return nil, err
+	}
+
+	// having
+	tmp, err = tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if tmp == "having" {
+		tokener.Pop()
+		having, err := parseOrExp(tokener)
+		if err != nil {
+			return nil, err
+		}
+		read.Having = having
+	}
+
+	// order by
+	read.OrderBy, err = parseOrderBy(tokener)
+	if err != nil {
+		return nil, err
+	}
+
+	// limit offset
+	read.Limit, read.Offset, err = parseLimit(tokener)
+	if err != nil {
+		return nil, err
+	}
+	return read, nil
+}
+
+// parseOrderBy 解析 order by a [asc|desc], b [asc|desc] ...
+// 如果没有order by, 则返回nil
+func parseOrderBy(tokener *tokener) ([]OrderBy, error) {
+	order, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if order != "order" {
+		return nil, nil
+	}
+	tokener.Pop()
+
+	by, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if by != "by" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	var orderBy []OrderBy
+	for {
+		field, aggregate, err := parseColumn(tokener)
+		if err != nil {
+			return nil, err
+		}
+
+		ob := OrderBy{Field: field, Aggregate: aggregate}
+		tmp, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if tmp == "asc" || tmp == "desc" {
+			ob.Desc = tmp == "desc"
+			tokener.Pop()
+			tmp, err = tokener.Peek()
+			if err != nil {
+				return nil, err
+			}
+		}
+		orderBy = append(orderBy, ob)
+
+		if tmp != "," {
+			return orderBy, nil
+		}
+		tokener.Pop()
+	}
+}
+
+// parseJoin 解析 [inner] join b on a.x = b.y, 或者 left [outer] join b on a.x = b.y.
+// 如果没有join, 则返回nil
+func parseJoin(tokener *tokener) (*Join, error) {
+	join := new(Join)
+
+	tmp, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	switch tmp {
+	case "join", "inner":
+		join.Type = "inner"
+	case "left":
+		join.Type = "left"
+	default:
+		return nil, nil
+	}
+	if tmp != "join" {
+		tokener.Pop()
+		tmp, err = tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if join.Type == "left" && tmp == "outer" {
+			tokener.Pop()
+			tmp, err = tokener.Peek()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if tmp != "join" {
+			return nil, ErrInvalidStat
+		}
+	}
+	tokener.Pop()
+
+	tableName, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if isName(tableName) == false {
+		return nil, ErrInvalidStat
+	}
+	join.TableName = tableName
+	tokener.Pop()
+
+	on, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if on != "on" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	left, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if isName(left) == false {
+		return nil, ErrInvalidStat
+	}
+	join.Left = left
+	tokener.Pop()
+
+	eq, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if eq != "=" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	right, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if isName(right) == false {
+		return nil, ErrInvalidStat
+	}
+	join.Right = right
+	tokener.Pop()
+
+	return join, nil
+}
+
+// parseGroupBy 解析 group by a, b ...
+// 如果没有group by, 则返回nil
+func parseGroupBy(tokener *tokener) ([]string, error) {
+	group, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if group != "group" {
+		return nil, nil
+	}
+	tokener.Pop()
+
+	by, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if by != "by" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	var groupBy []string
+	for {
+		field, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if isName(field) == false {
+			return nil, ErrInvalidStat
+		}
+		groupBy = append(groupBy, field)
+		tokener.Pop()
+
+		comma, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if comma != "," {
+			return groupBy, nil
+		}
+		tokener.Pop()
+	}
+}
+
+// parseColumn 解析一个字段名, 或者一个聚合函数 count(*), count(a), sum(a), min(a), max(a), avg(a).
+// 对于聚合函数, 返回的名字为其规范的写法, 如"sum(a)".
+func parseColumn(tokener *tokener) (string, *Aggregate, error) {
+	name, err := tokener.Peek()
+	if err != nil {
+		return "", nil, err
+	}
+	if isName(name) == false {
+		return "", nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	if isAggregateFunc(name) == false {
+		return name, nil, nil
+	}
+	lp, err := tokener.Peek()
+	if err != nil {
+		return "", nil, err
+	}
+	if lp != "(" { // 字段名恰好和聚合函数相同
+		return name, nil, nil
+	}
+	tokener.Pop()
+
+	field, err := tokener.Peek()
+	if err != nil {
+		return "", nil, err
+	}
+	if (field == "*" && name != "count") || (field != "*" && isName(field) == false) {
+		return "", nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	rp, err := tokener.Peek()
+	if err != nil {
+		return "", nil, err
+	}
+	if rp != ")" {
+		return "", nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	aggregate := &Aggregate{Func: name, Field: field}
+	return aggregate.String(), aggregate, nil
+}
+
+// parseLimit 解析 limit n [offset m], 或者只有 offset m.
+// 没有limit时, limit为-1.
+func parseLimit(tokener *tokener) (int64, int64, error) {
+	var limit, offset int64 = -1, 0
+
+	tmp, err := tokener.Peek()
+	if err != nil {
+		return 0, 0, err
+	}
+	if tmp == "limit" {
+		tokener.Pop()
+		limit, err = parseCount(tokener)
+		if err != nil {
+			return 0, 0, err
+		}
+		tmp, err = tokener.Peek()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if tmp == "offset" {
+		tokener.Pop()
+		offset, err = parseCount(tokener)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return limit, offset, nil
+}
+
+// parseCount 解析一个非负整数
+func parseCount(tokener *tokener) (int64, error) {
+	tmp, err := tokener.Peek()
+	if err != nil {
+		return 0, err
+	}
+	count, err := strconv.ParseInt(tmp, 10, 64)
+	if err != nil || count < 0 {
+		return 0, ErrInvalidStat
+	}
+	tokener.Pop()
+	return count, nil
+}
+
+// 解析where后面的布尔表达式, 语法为:
+// where   := "where" orExp
+// orExp   := andExp {"or" andExp}
+// andExp  := notExp {"and" notExp}
+// notExp  := "not" notExp | "(" orExp ")" | singleExp
+func parseWhere(tokener *tokener) (*Where, error) {
+	// 读取where
+	whereStr, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if whereStr != "where" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	return parseOrExp(tokener)
+}
+
+func parseOrExp(tokener *tokener) (*Where, error) {
+	left, err := parseAndExp(tokener)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if op != "or" {
+			return left, nil
+		}
+		tokener.Pop()
+
+		right, err := parseAndExp(tokener)
+		if err != nil {
+			return nil, err
+		}
+		left = &Where{LogicOp: "or", Left: left, Right: right}
+	}
+}
+
+func parseAndExp(tokener *tokener) (*Where, error) {
+	left, err := parseNotExp(tokener)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if op != "and" {
+			return left, nil
+		}
+		tokener.Pop()
+
+		right, err := parseNotExp(tokener)
+		if err != nil {
+			return nil, err
+		}
+		left = &Where{LogicOp: "and", Left: left, Right: right}
 	}
-	read.TableName = tableName
-	tokener.Pop()
+}
 
-	// where
+func parseNotExp(tokener *tokener) (*Where, error) {
 	tmp, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if tmp == "" {
-		read.Where = nil
-		return read, nil
+
+	if tmp == "not" {
+		tokener.Pop()
+		exp, err := parseNotExp(tokener)
+		if err != nil {
+			return nil, err
+		}
+		return &Where{LogicOp: "not", Left: exp}, nil
 	}
 
-	where, err := parseWhere(tokener)
-	if err != nil {
-		return nil, err
+	if tmp == "(" {
+		tokener.Pop()
+		exp, err := parseOrExp(tokener)
+		if err != nil {
+			return nil, err
+		}
+		// 右括号
+		tmp, err = tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if tmp != ")" {
+			return nil, ErrInvalidStat
+		}
+		tokener.Pop()
+		return exp, nil
 	}
-	read.Where = where
-	return read, nil
-}
 
-// 解析where后面的表达式
-// 只支持 简单逻辑
-func parseWhere(tokener *tokener) (*Where, error) {
-	where := new(Where)
-	// 读取where
-	whereStr, err := tokener.Peek()
+	singleExp, err := parseSingleExpr(tokener)
 	if err != nil {
 		return nil, err
 	}
-	if whereStr != "where" {
-		return nil, ErrInvalidStat
-	}
-	tokener.Pop()
-	// 读取后面的表达式1
-	sexp1, err := parseSingleExpr(tokener)
+	return &Where{SingleExp: singleExp}, nil
+}
+
+//
+// parseSingleExpr
+// 解析一个简单表达式 a = b, a > b, a < b, a >= b, a <= b, a != b,
+// a between b and c, a in (b, c, ...)
+//
+func parseSingleExpr(tokener *tokener) (*SingleExp, error) {
+	singleExp := new(SingleExp)
+
+	field, aggregate, err := parseColumn(tokener)
 	if err != nil {
 		return nil, err
 	}
-	where.SingleExp1 = sexp1
+	singleExp.Field = field
+	singleExp.Aggregate = aggregate
 
-	// 读取逻辑链接词
-	logicOp, err := tokener.Peek()
+	op, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if logicOp == "" {
-		where.LogicOp = ""
-		return where, nil
+	tokener.Pop()
+	switch op {
+	case "between":
+		singleExp.CmpOp = op
+		singleExp.Values, err = parseBetweenValues(tokener)
+		if err != nil {
+			return nil, err
+		}
+		return singleExp, nil
+	case "in":
+		singleExp.CmpOp = op
+		values, err := parseValueList(tokener)
+		if err != nil {
+			return nil, err
+		}
+		singleExp.Values = values.strs
+		return singleExp, nil
+	case "is":
+		// is [not] null
+		singleExp.CmpOp = "is null"
+		tmp, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if tmp == "not" {
+			singleExp.CmpOp = "is not null"
+			tokener.Pop()
+			tmp, err = tokener.Peek()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if isNull(tmp, tokener.Quoted()) == false {
+			return nil, ErrInvalidStat
+		}
+		tokener.Pop()
+		return singleExp, nil
 	}
-	if isLogicOp(logicOp) == false {
+	if isCmpOp(op) == false {
 		return nil, ErrInvalidStat
 	}
-	where.LogicOp = logicOp
-	tokener.Pop()
-
-	// 解析表达式2
-	sexp2, err := parseSingleExpr(tokener)
-	if err != nil {
-		return nil, err
+	if op == "<>" {
+		op = "!="
 	}
-	where.SingleExp2 = sexp2
+	singleExp.CmpOp = op
 
-	eof, err := tokener.Peek()
+	singleExp.Value, _, err = parseLiteral(tokener)
 	if err != nil {
 		return nil, err
 	}
-	if eof != "" {
-		return nil, ErrInvalidStat
-	}
 
-	return where, nil
+	return singleExp, nil
 }
 
-//
-// parseSingleExpr
-// 解析一个简单表达式 a = b, a > b, a < b
-//
-func parseSingleExpr(tokener *tokener) (*SingleExp, error) {
-	singleExp := new(SingleExp)
-
-	field, err := tokener.Peek()
+// parseBetweenValues 解析between之后的 b and c
+func parseBetweenValues(tokener *tokener) ([]string, error) {
+	low, _, err := parseLiteral(tokener)
 	if err != nil {
 		return nil, err
 	}
-	if isName(field) == false {
-		return nil, ErrInvalidStat
-	}
-	singleExp.Field = field
-	tokener.Pop()
 
-	op, err := tokener.Peek()
+	and, err := tokener.Peek()
 	if err != nil {
 		return nil, err
 	}
-	if isCmpOp(op) == false {
+	if and != "and" {
 		return nil, ErrInvalidStat
 	}
-	singleExp.CmpOp = op
 	tokener.Pop()
 
-	value, err := tokener.Peek()
+	high, _, err := parseLiteral(tokener)
 	if err != nil {
 		return nil, err
 	}
-	singleExp.Value = value
-	tokener.Pop()
 
-	return singleExp, nil
+	return []string{low, high}, nil
 }
 
 // 解析create语句
@@ -435,6 +1177,21 @@ func parseCreate(tokener *tokener) (*Create, error) {
 		create.FieldType = append(create.FieldType, ftype)
 		tokener.Pop()
 
+		// [not null | null] [default value] [unique] [primary key]
+		constraints, err := parseFieldConstraints(tokener)
+		if err != nil {
+			return nil, err
+		}
+		if constraints.primaryKey {
+			if create.PrimaryKey != "" { // 只能有一个主键
+				return nil, ErrInvalidStat
+			}
+			create.PrimaryKey = field
+		}
+		create.FieldNotNull = append(create.FieldNotNull, constraints.notNull)
+		create.FieldDefault = append(create.FieldDefault, constraints.defaultValue)
+		create.FieldUnique = append(create.FieldUnique, constraints.unique)
+
 		// 下一个如果是,继续解析，如果
 		next, err := tokener.Peek()
 		if err != nil {
@@ -443,6 +1200,9 @@ func parseCreate(tokener *tokener) (*Create, error) {
 		if next == "," {
 			// 解析下一个
 		} else if next == "" {
+			if create.hasUnique() { // 唯一约束自带索引, 可以省略索引
+				return create, nil
+			}
 			return nil, ErrHasNoIndex
 		} else if next == "(" {
 			// 解析到左括号退出，说明解析到索引了
@@ -464,21 +1224,48 @@ func parseCreate(tokener *tokener) (*Create, error) {
 	if index != "index" {
 		return nil, ErrInvalidStat
 	}
+	tokener.Pop()
 	for {
 		// 循环遍历每一个index
-		tokener.Pop()
 		field, err := tokener.Peek()
 		if err != nil {
 			return nil, err
 		}
-		// 左括号退出
+		// 右括号退出
 		if field == ")" {
 			break
+		} else if field == "(" {
+			// 组合索引 (a, b, ...)
+			names, err := parseNameList(tokener)
+			if err != nil {
+				return nil, err
+			}
+			if len(names) < 2 {
+				return nil, ErrInvalidStat
+			}
+			create.CompositeIndex = append(create.CompositeIndex, names)
+			continue
+		} else if field == "using" && tokener.Quoted() == false {
+			// using hash a, 字段a的索引为hash索引
+			err = parseUsingHash(tokener)
+			if err != nil {
+				return nil, err
+			}
+			name, err := tokener.Peek()
+			if err != nil {
+				return nil, err
+			}
+			if isName(name) == false || name == "(" || name == ")" {
+				return nil, ErrInvalidStat
+			}
+			create.Index = append(create.Index, name)
+			create.HashIndex = append(create.HashIndex, name)
 		} else if isName(field) == false {
 			return nil, ErrInvalidStat
 		} else {
 			create.Index = append(create.Index, field)
 		}
+		tokener.Pop()
 	}
 	// 弹出右括号
 	tokener.Pop()
@@ -492,6 +1279,69 @@ func parseCreate(tokener *tokener) (*Create, error) {
 	return create, nil
 }
 
+// fieldConstraints 为字段类型之后的约束
+type fieldConstraints struct {
+	notNull      bool
+	defaultValue *Value
+	unique       bool
+	primaryKey   bool
+}
+
+// parseFieldConstraints 解析字段类型之后的 not null, null, default value, unique 和 primary key, 它们的顺序任意.
+func parseFieldConstraints(tokener *tokener) (*fieldConstraints, error) {
+	c := new(fieldConstraints)
+	for {
+		tmp, err := tokener.Peek()
+		if err != nil {
+			return nil, err
+		}
+		if tokener.Quoted() {
+			return nil, ErrInvalidStat
+		}
+
+		switch tmp {
+		case "not":
+			tokener.Pop()
+			null, err := tokener.Peek()
+			if err != nil {
+				return nil, err
+			}
+			if isNull(null, tokener.Quoted()) == false {
+				return nil, ErrInvalidStat
+			}
+			c.notNull = true
+		case "null", "NULL":
+			c.notNull = false
+		case "default":
+			tokener.Pop()
+			value, quoted, err := parseLiteral(tokener)
+			if err != nil {
+				return nil, err
+			}
+			c.defaultValue = newValue(value, quoted)
+			continue
+		case "unique":
+			c.unique = true
+		case "primary":
+			tokener.Pop()
+			key, err := tokener.Peek()
+			if err != nil {
+				return nil, err
+			}
+			if key != "key" || tokener.Quoted() {
+				return nil, ErrInvalidStat
+			}
+			c.primaryKey = true
+		default:
+			if c.primaryKey { // 主键不能为NULL
+				c.notNull = true
+			}
+			return c, nil
+		}
+		tokener.Pop()
+	}
+}
+
 // 删除table
 func parseDrop(tokener *tokener) (*Drop, error) {
 	// table
@@ -528,6 +1378,122 @@ func parseDrop(tokener *tokener) (*Drop, error) {
 	return drop, nil
 }
 
+// isIndex 判断下一个token是否为index, 用于区分create/drop table和create/drop index
+func isIndex(tokener *tokener) bool {
+	token, err := tokener.Peek()
+	return err == nil && token == "index" && tokener.Quoted() == false
+}
+
+// 解析create index, 省略索引名时, 以字段名用"_"连接作为索引名
+// create index [name] on tablename (field1, field2, ...) [using hash]
+func parseCreateIndex(tokener *tokener) (*CreateIndex, error) {
+	tokener.Pop() // index
+
+	create := new(CreateIndex)
+	name, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if name != "on" {
+		if isName(name) == false || tokener.Quoted() {
+			return nil, ErrInvalidStat
+		}
+		create.IndexName = name
+		tokener.Pop()
+	}
+
+	on, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if on != "on" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	tableName, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if isName(tableName) == false {
+		return nil, ErrInvalidStat
+	}
+	create.TableName = tableName
+	tokener.Pop()
+
+	create.Fields, err = parseNameList(tokener)
+	if err != nil {
+		return nil, err
+	}
+	if create.IndexName == "" {
+		create.IndexName = DefaultIndexName(create.Fields)
+	}
+
+	using, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if using == "using" && tokener.Quoted() == false {
+		err = parseUsingHash(tokener)
+		if err != nil {
+			return nil, err
+		}
+		create.Hash = true
+	}
+	return create, nil
+}
+
+// parseUsingHash 解析索引类型 using hash
+func parseUsingHash(tokener *tokener) error {
+	tokener.Pop() // using
+	hash, err := tokener.Peek()
+	if err != nil {
+		return err
+	}
+	if hash != "hash" || tokener.Quoted() {
+		return ErrInvalidStat
+	}
+	tokener.Pop()
+	return nil
+}
+
+// 解析drop index
+// drop index name on tablename
+func parseDropIndex(tokener *tokener) (*DropIndex, error) {
+	tokener.Pop() // index
+
+	drop := new(DropIndex)
+	name, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if isName(name) == false || name == "on" {
+		return nil, ErrInvalidStat
+	}
+	drop.IndexName = name
+	tokener.Pop()
+
+	on, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if on != "on" {
+		return nil, ErrInvalidStat
+	}
+	tokener.Pop()
+
+	tableName, err := tokener.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if isName(tableName) == false {
+		return nil, ErrInvalidStat
+	}
+	drop.TableName = tableName
+	tokener.Pop()
+	return drop, nil
+}
+
 func parseBegin(tokener *tokener) (*Begin, error) {
 	isolation, err := tokener.Peek()
 	if err != nil {
@@ -626,20 +1592,65 @@ func parseAbort(tokener *tokener) (*Abort, error) {
 	}
 }
 
-// 是否是逻辑语句
-func isLogicOp(op string) bool {
-	return op == "and" || op == "or"
-}
-
 func isType(tp string) bool {
-	return tp == "uint32" || tp == "uint64" ||
-		tp == "string"
+	switch tp {
+	case "uint32", "uint64", "int32", "int64", "float64", "bool", "timestamp", "string", "bytes":
+		return true
+	}
+	return false
 }
 
 func isName(name string) bool {
 	return !(len(name) == 1 && isAlphaBeta(name[0]) == false)
 }
 
+// isNull 判断token是否为NULL, 即不在引号中的null
+func isNull(token string, quoted bool) bool {
+	return quoted == false && (token == "null" || token == "NULL")
+}
+
+func newValue(token string, quoted bool) *Value {
+	if isNull(token, quoted) {
+		return &Value{Null: true}
+	}
+	return &Value{Str: token}
+}
+
+func isAggregateFunc(name string) bool {
+	return name == "count" || name == "sum" || name == "min" || name == "max" || name == "avg"
+}
+
 func isCmpOp(op string) bool {
-	return op == "=" || op == ">" || op == "<"
+	return op == "=" || op == ">" || op == "<" ||
+		op == ">=" || op == "<=" || op == "!=" || op == "<>"
+}
+
+// parseLiteral 解析一个字面值, 可以是带有负号的数字, 返回其字符串和是否在引号中.
+func parseLiteral(tokener *tokener) (string, bool, error) {
+	value, err := tokener.Peek()
+	if err != nil {
+		return "", false, err
+	}
+	quoted := tokener.Quoted()
+	if quoted {
+		tokener.Pop()
+		return value, true, nil
+	}
+	if value == "" || (value != "-" && isSymbol(value[0])) {
+		return "", false, ErrInvalidStat
+	}
+	tokener.Pop()
+	if value != "-" {
+		return value, false, nil
+	}
+
+	num, err := tokener.Peek()
+	if err != nil {
+		return "", false, err
+	}
+	if tokener.Quoted() || num == "" || isDigital(num[0]) == false {
+		return "", false, ErrInvalidStat
+	}
+	tokener.Pop()
+	return "-" + num, false, nil
 }
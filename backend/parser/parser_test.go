@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestParseIndexName 检查默认的组合索引名可以被drop index引用
+func TestParseIndexName(t *testing.T) {
+	tests := []struct {
+		stat     string
+		expected interface{}
+	}{
+		{
+			"create index on t (a, b)",
+			&CreateIndex{IndexName: "a_b", TableName: "t", Fields: []string{"a", "b"}},
+		},
+		{
+			"create index by_a_b on t (a, b)",
+			&CreateIndex{IndexName: "by_a_b", TableName: "t", Fields: []string{"a", "b"}},
+		},
+		{
+			"drop index a_b on t",
+			&DropIndex{IndexName: "a_b", TableName: "t"},
+		},
+		{
+			"drop index a_b on my_table",
+			&DropIndex{IndexName: "a_b", TableName: "my_table"},
+		},
+	}
+	for _, test := range tests {
+		stat, err := Parse([]byte(test.stat))
+		if err != nil {
+			t.Errorf("%s: %v", test.stat, err)
+			continue
+		}
+		if reflect.DeepEqual(stat, test.expected) == false {
+			t.Errorf("%s: got %+v, expected %+v", test.stat, stat, test.expected)
+		}
+	}
+}
+
+// TestParseUnderscoreName 检查字段名和表名中可以有下划线
+func TestParseUnderscoreName(t *testing.T) {
+	stat, err := Parse([]byte("create table my_table first_name string, age_2 int64 (index first_name age_2)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	create, ok := stat.(*Create)
+	if ok == false {
+		t.Fatalf("got %T, expected *Create", stat)
+	}
+	if create.TableName != "my_table" {
+		t.Errorf("got table %s, expected my_table", create.TableName)
+	}
+	expected := []string{"first_name", "age_2"}
+	if reflect.DeepEqual(create.FieldName, expected) == false {
+		t.Errorf("got fields %v, expected %v", create.FieldName, expected)
+	}
+	if reflect.DeepEqual(create.Index, expected) == false {
+		t.Errorf("got index %v, expected %v", create.Index, expected)
+	}
+
+	_, err = Parse([]byte("read * from t where a_b = 1"))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// TestParseCompositeIndexName 检查create table中组合索引的默认名字可以被drop index引用
+func TestParseCompositeIndexName(t *testing.T) {
+	stat, err := Parse([]byte("create table t a int64, b_c int64 (index (a, b_c))"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := stat.(*Create)
+	expected := [][]string{{"a", "b_c"}}
+	if reflect.DeepEqual(create.CompositeIndex, expected) == false {
+		t.Fatalf("got composite index %v, expected %v", create.CompositeIndex, expected)
+	}
+
+	name := DefaultIndexName(create.CompositeIndex[0])
+	stat, err = Parse([]byte("drop index " + name + " on t"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drop := stat.(*DropIndex); drop.IndexName != name {
+		t.Errorf("got index %s, expected %s", drop.IndexName, name)
+	}
+}
+
+// TestParseQuote 检查引号中连续的两个引号表示一个引号, 以及没有结束的引号返回ErrUnterminatedQuote
+func TestParseQuote(t *testing.T) {
+	stat, err := Parse([]byte(`insert into t values ('it''s', "say ""hi""", '', '''', 'a"b', "null")`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Insert{
+		TableName: "t",
+		Rows:      [][]*Value{{{Str: "it's"}, {Str: `say "hi"`}, {Str: ""}, {Str: "'"}, {Str: `a"b`}, {Str: "null"}}},
+	}
+	if reflect.DeepEqual(stat, expected) == false {
+		t.Errorf("got %+v, expected %+v", stat, expected)
+	}
+
+	stat, err = Parse([]byte("read * from t where s = 'a''b' or s in ('''', 'c')"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	where := stat.(*Read).Where
+	if where.Left.SingleExp.Value != "a'b" || reflect.DeepEqual(where.Right.SingleExp.Values, []string{"'", "c"}) == false {
+		t.Errorf("got %+v and %+v", where.Left.SingleExp, where.Right.SingleExp)
+	}
+
+	for _, stat := range []string{
+		"insert into t values ('abc",
+		"insert into t values ('it''s)",
+		"read * from t where s = '''",
+		`update t set s = "x`,
+	} {
+		if _, err := Parse([]byte(stat)); err != ErrUnterminatedQuote {
+			t.Errorf("%s: got %v, expected %v", stat, err, ErrUnterminatedQuote)
+		}
+	}
+}
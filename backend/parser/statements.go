@@ -1,5 +1,7 @@
 package parser
 
+import "strings"
+
 type Begin struct {
 	IsRepeatableRead bool
 }
@@ -12,21 +14,106 @@ type Drop struct {
 	TableName string
 }
 
+// CreateIndex 为create index语句, 在已有的表上建立索引.
+// 只有一个字段, 且IndexName与字段名相同时, 建立的是该字段的索引, 否则为组合索引.
+// Hash为true时建立hash索引, 它只能是字段的索引.
+type CreateIndex struct {
+	IndexName string
+	TableName string
+	Fields    []string
+	Hash      bool
+}
+
+// DefaultIndexName 返回没有指定名字的组合索引的名字, 即用下划线连接的字段名, 如a_b.
+// 它总是一个合法的token, 可以被drop index引用.
+func DefaultIndexName(fields []string) string {
+	return strings.Join(fields, "_")
+}
+
+// DropIndex 为drop index语句, IndexName为组合索引的名字, 或有索引的字段的名字.
+type DropIndex struct {
+	IndexName string
+	TableName string
+}
+
 type Show struct {
 }
 
-type Create struct {
+// Vacuum 为vacuum语句, 从表的索引中删除对所有事务都不可见的版本.
+type Vacuum struct {
 	TableName string
-	FieldName []string
-	FieldType []string
-	Index     []string
 }
 
-type Update struct {
+// Verify 为verify语句, 检查表的rows和索引是否正确, TableName为空时检查所有的表.
+type Verify struct {
 	TableName string
+}
+
+type SetAutocommit struct {
+	On bool
+}
+
+// Create 为create语句, FieldNotNull, FieldDefault和FieldUnique与FieldName一一对应,
+// 没有default的字段, 其FieldDefault为nil.
+// PrimaryKey为主键的字段名, 没有主键时为空, 主键也是唯一且不能为NULL的.
+// Index为单字段的索引, CompositeIndex为组合索引, 每个由多个字段名组成.
+// 唯一的字段总是有索引, 不需要出现在Index中.
+// HashIndex为索引是hash索引的字段, 它们也都在Index中.
+type Create struct {
+	TableName      string
+	FieldName      []string
+	FieldType      []string
+	FieldNotNull   []bool
+	FieldDefault   []*Value
+	FieldUnique    []bool
+	PrimaryKey     string
+	Index          []string
+	HashIndex      []string
+	CompositeIndex [][]string
+}
+
+// hasUnique 判断是否有唯一的字段
+func (create *Create) hasUnique() bool {
+	if create.PrimaryKey != "" {
+		return true
+	}
+	for _, unique := range create.FieldUnique {
+		if unique {
+			return true
+		}
+	}
+	return false
+}
+
+// Value 为一个字面值, Null为true时表示NULL, 即不在引号中的null.
+type Value struct {
+	Str  string
+	Null bool
+}
+
+type Update struct {
+	TableName   string
+	Assignments []*Assignment
+	Where       *Where
+}
+
+// Assignment 为update中的一个赋值 FieldName = Value
+type Assignment struct {
 	FieldName string
-	Value     string
-	Where     *Where
+	Value     *Expr
+}
+
+// Expr 为一个算术表达式.
+// Op为"+", "-", "*", "/"或"%"时, Left和Right为它的两个操作数;
+// Op为空时, 该节点为一个值Value. 不在引号中的Value, 如果是表中的字段名, 则表示该字段当前的值.
+// Null为true时, 该节点为NULL.
+type Expr struct {
+	Op     string
+	Left   *Expr
+	Right  *Expr
+	Value  string
+	Quoted bool
+	Null   bool
 }
 
 type Delete struct {
@@ -34,25 +121,76 @@ type Delete struct {
 	Where     *Where
 }
 
+// Insert 为insert语句, Rows中的每一行为要插入的一个entry.
+// Fields为nil时, 每一行需要按表中字段的顺序给出所有字段的值;
+// 否则每一行的值与Fields一一对应, 未给出的字段取默认值.
 type Insert struct {
 	TableName string
-	Values    []string
+	Fields    []string
+	Rows      [][]*Value
 }
 
+// Read 为read语句, 没有limit时Limit为-1.
+// Aggregates与Fields一一对应, 如果该项是一个聚合函数, 则Fields中为其规范的写法, 如"sum(a)",
+// 否则对应的Aggregate为nil.
 type Read struct {
+	TableName  string
+	Joins      []*Join
+	Fields     []string
+	Aggregates []*Aggregate
+	Where      *Where
+	GroupBy    []string
+	Having     *Where
+	OrderBy    []OrderBy
+	Limit      int64
+	Offset     int64
+}
+
+// Join 为 join TableName on Left = Right, Type为"inner"或"left".
+// Left和Right为字段名, 可以带有表名, 如"a.x".
+type Join struct {
+	Type      string
 	TableName string
-	Fields    []string
-	Where     *Where
+	Left      string
+	Right     string
+}
+
+// Aggregate 为一个聚合函数, count(*)的Field为"*"
+type Aggregate struct {
+	Func  string
+	Field string
+}
+
+func (a *Aggregate) String() string {
+	return a.Func + "(" + a.Field + ")"
 }
 
+type OrderBy struct {
+	Field     string
+	Aggregate *Aggregate
+	Desc      bool
+}
+
+// Where 为where之后的布尔表达式树.
+// LogicOp为"and"或"or"时, Left和Right为它的两个子表达式;
+// LogicOp为"not"时, 只有Left;
+// LogicOp为空时, 该节点为一个简单表达式SingleExp.
 type Where struct {
-	SingleExp1 *SingleExp
-	LogicOp    string
-	SingleExp2 *SingleExp
+	LogicOp   string
+	Left      *Where
+	Right     *Where
+	SingleExp *SingleExp
 }
 
+// SingleExp 为一个简单表达式.
+// CmpOp为"between"时, Values为上下界两个值; CmpOp为"in"时, Values为所有候选值;
+// 其余情况下, 比较的值为Value.
+// CmpOp为"is null"或"is not null"时, 没有比较的值.
+// 在having中, 比较的对象可以是一个聚合函数, 此时Aggregate不为nil.
 type SingleExp struct {
-	Field string
-	CmpOp string
-	Value string
+	Field     string
+	Aggregate *Aggregate
+	CmpOp     string
+	Value     string
+	Values    []string
 }
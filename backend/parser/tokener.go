@@ -10,13 +10,15 @@ type tokener struct {
 
 	curToken   string //当前token
 	flushToken bool   // 是否需要刷新token
+	curQuoted  bool   // 当前token是否为引号中的字符串
+	quoted     bool   // 最近读取的token是否为引号中的字符串
 
 	err error
 }
 
 func newTokener(stat []byte) *tokener {
 	return &tokener{
-		stat, 0, "", true, nil,
+		stat, 0, "", true, false, false, nil,
 	}
 }
 
@@ -33,12 +35,18 @@ func (tk *tokener) Peek() (string, error) {
 			return "", err
 		}
 		tk.curToken = token
+		tk.curQuoted = tk.quoted
 		tk.flushToken = false
 	}
 
 	return tk.curToken, nil
 }
 
+// Quoted 返回当前的token是否为引号中的字符串, 需要先调用Peek
+func (tk *tokener) Quoted() bool {
+	return tk.curQuoted
+}
+
 // Pop 弹出当前的token
 func (tk *tokener) Pop() {
 	tk.flushToken = true
@@ -80,6 +88,7 @@ func (tk *tokener) next() (string, error) {
 
 // 读取下一个元数据（token和符号）
 func (tk *tokener) nextMetaState() (string, error) {
+	tk.quoted = false
 	for {
 		b, eof := tk.peekByte()
 		if eof == true {
@@ -92,12 +101,14 @@ func (tk *tokener) nextMetaState() (string, error) {
 	}
 
 	b, _ := tk.peekByte()
-	if isSymbol(b) {
+	if b == '>' || b == '<' || b == '!' {
+		return tk.nextCmpOpState()
+	} else if isSymbol(b) {
 		tk.popByte()
 		return string(b), nil
 	} else if b == '"' || b == '\'' {
 		return tk.nextQuoteState()
-	} else if isAlphaBeta(b) || isDigital(b) {
+	} else if isIdentifier(b) {
 		return tk.nextTokenState()
 	} else {
 		tk.err = ErrInvalidStat
@@ -109,7 +120,7 @@ func (tk *tokener) nextTokenState() (string, error) {
 	var tmp []byte
 	for {
 		b, eof := tk.peekByte()
-		if eof == true || (isAlphaBeta(b) || isDigital(b)) == false {
+		if eof == true || (isIdentifier(b) || b == '.') == false {
 			if isBlank(b) {
 				tk.popByte()
 			}
@@ -120,22 +131,44 @@ func (tk *tokener) nextTokenState() (string, error) {
 	}
 }
 
+// nextCmpOpState
+// 解析比较符号 >, <, >=, <=, !=, <>
+func (tk *tokener) nextCmpOpState() (string, error) {
+	b, _ := tk.peekByte()
+	tk.popByte()
+
+	next, eof := tk.peekByte()
+	if eof == false && (next == '=' || (b == '<' && next == '>')) {
+		tk.popByte()
+		return string([]byte{b, next}), nil
+	}
+	if b == '!' {
+		tk.err = ErrInvalidStat
+		return "", tk.err
+	}
+	return string(b), nil
+}
+
 // nextQuoteState
-// 解析引号
+// 解析引号, 引号中连续的两个引号表示一个引号, 如'it''s'为it's
 func (tk *tokener) nextQuoteState() (string, error) {
 	quote, _ := tk.peekByte()
 	tk.popByte()
+	tk.quoted = true
 
 	var tmp []byte
 	for {
 		b, eof := tk.peekByte()
 		if eof == true {
-			tk.err = ErrInvalidStat
+			tk.err = ErrUnterminatedQuote
 			return "", tk.err
 		}
 		if b == quote {
 			tk.popByte()
-			break
+			next, eof := tk.peekByte()
+			if eof == true || next != quote {
+				break
+			}
 		}
 		tmp = append(tmp, b)
 		tk.popByte()
@@ -155,7 +188,8 @@ func (tk *tokener) ErrStat() []byte {
 // 检验是否是符号
 func isSymbol(b byte) bool {
 	return b == '>' || b == '<' || b == '=' || b == '*' ||
-		b == ',' || b == '(' || b == ')'
+		b == ',' || b == '(' || b == ')' ||
+		b == '+' || b == '-' || b == '/' || b == '%'
 }
 
 // 是否是字母
@@ -168,6 +202,11 @@ func isDigital(b byte) bool {
 	return b >= '0' && b <= '9'
 }
 
+// 是否可以出现在token中, 即字母, 数字和下划线, 如默认的组合索引名a_b
+func isIdentifier(b byte) bool {
+	return isAlphaBeta(b) || isDigital(b) || b == '_'
+}
+
 func isBlank(b byte) bool {
 	return b == '\n' || b == ' ' || b == '\t'
 }
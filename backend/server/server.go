@@ -0,0 +1,82 @@
+/*
+	server 负责接收客户端的连接, 并为每个连接创建一个session.
+	每一个session独立的维护自己的状态, 如当前所处的事务.
+
+	语句在server中的处理流程为:
+	[Packager] --> [session] --> [parser] --> [TableManager]
+*/
+package server
+
+import (
+	tbm "fansDB/backend/table_manage"
+	"fansDB/backend/utils"
+	"fansDB/transporter"
+	"net"
+)
+
+type Server interface {
+	Start()
+}
+
+type server struct {
+	network string
+	address string
+	tbm     tbm.TableManager
+}
+
+func NewServer(network, address string, tbm tbm.TableManager) *server {
+	return &server{
+		network: network,
+		address: address,
+		tbm:     tbm,
+	}
+}
+
+// Start 开始监听, 该函数不会返回.
+func (s *server) Start() {
+	listener, err := net.Listen(s.network, s.address)
+	if err != nil {
+		panic(err)
+	}
+
+	utils.Info("Server listen on " + s.address)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			utils.Info(err)
+			continue
+		}
+		go s.serve(conn)
+	}
+}
+
+// serve 处理一个连接, 直到客户端断开.
+func (s *server) serve(conn net.Conn) {
+	utils.Info("Establish connection: ", conn.RemoteAddr())
+
+	tr := transporter.NewHexTransporter(conn)
+	pr := transporter.NewProtocoler()
+	pk := transporter.NewPackager(tr, pr)
+	defer pk.Close()
+
+	se := newSession(s.tbm)
+	defer se.Close() // 连接断开时, 回滚其未完成的事务
+
+	for {
+		pkg, err := pk.Receive()
+		if err != nil {
+			utils.Info(err)
+			break
+		}
+
+		result, err := se.Execute(pkg.Data())
+		err = pk.Send(transporter.NewPackage(result, err))
+		if err != nil {
+			utils.Info(err)
+			break
+		}
+	}
+
+	utils.Info("Close connection: ", conn.RemoteAddr())
+}
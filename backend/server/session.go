@@ -0,0 +1,160 @@
+package server
+
+import (
+	"errors"
+	statement "fansDB/backend/parser"
+	tbm "fansDB/backend/table_manage"
+	tm "fansDB/backend/transaction_manage"
+)
+
+var (
+	ErrNestedTransaction = errors.New("Nested transaction not supported.")
+	ErrNoTransaction     = errors.New("Not in transaction.")
+	ErrUnsupportedStat   = errors.New("Unsupported statement.")
+)
+
+//
+// session
+// @Description: 一个连接所对应的会话状态
+//
+// 在事务外执行的语句, 会根据autocommit的设置来处理:
+// autocommit开启时, 该语句在一个隐式事务中执行, 成功则提交, 失败则回滚;
+// autocommit关闭时, 该语句会隐式的开启一个事务, 该事务直到commit或abort才结束.
+//
+// 同一时刻只能有一个事务执行DDL(create, drop, create index, drop index), 见tableManager.acquireDDL.
+// 其他会话的DDL不会等待, 而是立即返回table_manage.ErrConcurrentDDL, autocommit时其隐式事务被回滚,
+// 在前一个DDL事务结束之后重试即可.
+//
+type session struct {
+	xid        tm.TransactionID // 当前所处的事务, SUPER_TRANSACTION_ID表示不在事务中
+	autocommit bool
+	tbm        tbm.TableManager
+}
+
+func newSession(tbm tbm.TableManager) *session {
+	return &session{
+		xid:        tm.SUPER_TRANSACTION_ID,
+		autocommit: true,
+		tbm:        tbm,
+	}
+}
+
+// Close 关闭session, 如果还有未结束的事务, 则将其回滚.
+func (s *session) Close() {
+	if s.xid != tm.SUPER_TRANSACTION_ID {
+		s.tbm.Abort(s.xid)
+		s.xid = tm.SUPER_TRANSACTION_ID
+	}
+}
+
+// Execute 解析并执行一条语句, 返回执行结果
+func (s *session) Execute(stat []byte) ([]byte, error) {
+	parsed, err := statement.Parse(stat)
+	if err != nil {
+		return nil, err
+	}
+
+	switch st := parsed.(type) {
+	case *statement.Begin:
+		if s.xid != tm.SUPER_TRANSACTION_ID {
+			return nil, ErrNestedTransaction
+		}
+		xid, result := s.tbm.Begin(st)
+		s.xid = xid
+		return result, nil
+	case *statement.Commit:
+		if s.xid == tm.SUPER_TRANSACTION_ID {
+			return nil, ErrNoTransaction
+		}
+		result, err := s.tbm.Commit(s.xid)
+		if err != nil { // 提交失败的事务只能被回滚
+			s.tbm.Abort(s.xid)
+		}
+		s.xid = tm.SUPER_TRANSACTION_ID
+		return result, err
+	case *statement.Abort:
+		if s.xid == tm.SUPER_TRANSACTION_ID {
+			return nil, ErrNoTransaction
+		}
+		result := s.tbm.Abort(s.xid)
+		s.xid = tm.SUPER_TRANSACTION_ID
+		return result, nil
+	case *statement.SetAutocommit:
+		return s.setAutocommit(st.On)
+	default:
+		if s.xid != tm.SUPER_TRANSACTION_ID {
+			return s.execute(s.xid, parsed)
+		}
+		if s.autocommit == false { // 隐式的开启一个事务, 由用户来结束它
+			s.xid, _ = s.tbm.Begin(new(statement.Begin))
+			return s.execute(s.xid, parsed)
+		}
+		return s.executeAutocommit(parsed)
+	}
+}
+
+// executeAutocommit 在一个临时事务中执行语句, 成功则提交, 否则回滚.
+// 如果该事务已经被SM自动回滚(ErrCannotSR), Abort仍然会将其注销.
+func (s *session) executeAutocommit(parsed interface{}) ([]byte, error) {
+	xid, _ := s.tbm.Begin(new(statement.Begin))
+	result, err := s.execute(xid, parsed)
+	if err != nil {
+		s.tbm.Abort(xid)
+		return nil, err
+	}
+	_, err = s.tbm.Commit(xid)
+	if err != nil {
+		s.tbm.Abort(xid)
+		return nil, err
+	}
+	return result, nil
+}
+
+// setAutocommit 设置autocommit, 如果开启autocommit时还有未结束的事务, 则将其提交.
+func (s *session) setAutocommit(on bool) ([]byte, error) {
+	if on && s.xid != tm.SUPER_TRANSACTION_ID {
+		_, err := s.tbm.Commit(s.xid)
+		if err != nil {
+			s.tbm.Abort(s.xid)
+		}
+		s.xid = tm.SUPER_TRANSACTION_ID
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.autocommit = on
+	if on {
+		return []byte("autocommit on"), nil
+	}
+	return []byte("autocommit off"), nil
+}
+
+// execute 在xid中执行非事务控制类的语句
+func (s *session) execute(xid tm.TransactionID, parsed interface{}) ([]byte, error) {
+	switch st := parsed.(type) {
+	case *statement.Show:
+		return s.tbm.Show(xid), nil
+	case *statement.Create:
+		return s.tbm.Create(xid, st)
+	case *statement.Drop:
+		return s.tbm.Drop(xid, st)
+	case *statement.CreateIndex:
+		return s.tbm.CreateIndex(xid, st)
+	case *statement.DropIndex:
+		return s.tbm.DropIndex(xid, st)
+	case *statement.Insert:
+		return s.tbm.Insert(xid, st)
+	case *statement.Read:
+		return s.tbm.Read(xid, st)
+	case *statement.Update:
+		return s.tbm.Update(xid, st)
+	case *statement.Delete:
+		return s.tbm.Delete(xid, st)
+	case *statement.Vacuum:
+		return s.tbm.Vacuum(xid, st)
+	case *statement.Verify:
+		return s.tbm.Verify(xid, st)
+	default:
+		return nil, ErrUnsupportedStat
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	dm "fansDB/backend/data_manage"
+	tbm "fansDB/backend/table_manage"
+	tm "fansDB/backend/transaction_manage"
+	sm "fansDB/backend/version_manage"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	_TEST_MEM = (1 << 20) * 16 // 16MB
+
+	_BLOCK_TIMEOUT = time.Second // 超过该时间没有返回的语句被认为在等待
+)
+
+// openTestTBM 在临时目录中创建一个数据库, 测试结束时被关闭
+func openTestTBM(t *testing.T) tbm.TableManager {
+	path := filepath.Join(t.TempDir(), "db")
+	transactionManager := tm.Create(path)
+	dataManager := dm.Create(path, _TEST_MEM, transactionManager)
+	serializabilityManager := sm.NewSerializabilityManager(transactionManager, dataManager)
+	t.Cleanup(func() {
+		dataManager.Close()
+		transactionManager.Close()
+	})
+	return tbm.Create(path, transactionManager, serializabilityManager, dataManager)
+}
+
+// mustExecute 在s中执行stat, 出错时结束测试
+func mustExecute(t *testing.T, s *session, stat string) string {
+	result, err := s.Execute([]byte(stat))
+	if err != nil {
+		t.Fatalf("%s: %v", stat, err)
+	}
+	return string(result)
+}
+
+// executeNoWait 在s中执行stat, 如果它在_BLOCK_TIMEOUT内没有返回, 则结束测试
+func executeNoWait(t *testing.T, s *session, stat string) (string, error) {
+	type result struct {
+		result []byte
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		r, err := s.Execute([]byte(stat))
+		done <- result{r, err}
+	}()
+	select {
+	case r := <-done:
+		return string(r.result), r.err
+	case <-time.After(_BLOCK_TIMEOUT):
+		t.Fatalf("%s: statement is waiting", stat)
+		return "", nil
+	}
+}
+
+// TestConcurrentDDL 检查另一个会话正在执行DDL事务时, autocommit的DDL立即失败并被回滚,
+// 其他语句不受影响, 前一个DDL事务提交后重试即可成功.
+func TestConcurrentDDL(t *testing.T) {
+	tableManager := openTestTBM(t)
+	a, b := newSession(tableManager), newSession(tableManager)
+	defer a.Close()
+	defer b.Close()
+
+	mustExecute(t, a, "create table z k int64 (index k)")
+	mustExecute(t, a, "set autocommit off")
+	mustExecute(t, a, "create table x k int64 (index k)")
+
+	for _, stat := range []string{"create table y k int64 (index k)", "drop table z"} {
+		_, err := executeNoWait(t, b, stat)
+		if err != tbm.ErrConcurrentDDL {
+			t.Errorf("%s: got %v, expected %v", stat, err, tbm.ErrConcurrentDDL)
+		}
+		if b.xid != tm.SUPER_TRANSACTION_ID {
+			t.Errorf("%s: implicit transaction %d was not ended", stat, b.xid)
+		}
+	}
+
+	if result, err := executeNoWait(t, b, "show"); err != nil || result != "{z: (k, int64, Index)}\n" {
+		t.Errorf("show: got %q, %v", result, err)
+	}
+	mustExecute(t, b, "begin")
+	mustExecute(t, b, "show")
+	if _, err := executeNoWait(t, b, "commit"); err != nil {
+		t.Errorf("commit: %v", err)
+	}
+
+	mustExecute(t, a, "commit")
+	if result, err := executeNoWait(t, b, "create table y k int64 (index k)"); err != nil {
+		t.Errorf("retry: got %q, %v", result, err)
+	}
+	mustExecute(t, b, "insert into x values (1)")
+	if result := mustExecute(t, a, "read * from x"); result != "[1]\n" {
+		t.Errorf("got %q", result)
+	}
+}
+
+// TestImplicitTransaction 检查事务外的语句在autocommit开启时立即提交, 出错时被回滚;
+// 关闭autocommit时隐式地开启一个事务, 直到commit, abort或者重新开启autocommit才结束.
+func TestImplicitTransaction(t *testing.T) {
+	tableManager := openTestTBM(t)
+	a, b := newSession(tableManager), newSession(tableManager)
+	defer b.Close()
+
+	mustExecute(t, a, "create table t k int64 primary key")
+	steps := []struct {
+		s        *session
+		stat     string
+		expected error
+		visible  string // 之后b看到的内容
+	}{
+		{a, "insert into t values (1)", nil, "[1]\n"},
+		{a, "insert into t values (2), (1)", tbm.ErrDuplicatedKey, "[1]\n"},
+		{a, "set autocommit off", nil, "[1]\n"},
+		{a, "insert into t values (3)", nil, "[1]\n"},
+		{a, "abort", nil, "[1]\n"},
+		{a, "insert into t values (4)", nil, "[1]\n"},
+		{a, "set autocommit on", nil, "[1]\n[4]\n"},
+		{a, "commit", ErrNoTransaction, "[1]\n[4]\n"},
+		{a, "abort", ErrNoTransaction, "[1]\n[4]\n"},
+		{a, "begin", nil, "[1]\n[4]\n"},
+		{a, "begin", ErrNestedTransaction, "[1]\n[4]\n"},
+		{a, "insert into t values (5)", nil, "[1]\n[4]\n"},
+	}
+	for _, step := range steps {
+		_, err := step.s.Execute([]byte(step.stat))
+		if err != step.expected {
+			t.Errorf("%s: got %v, expected %v", step.stat, err, step.expected)
+		}
+		if result := mustExecute(t, b, "read * from t order by k"); result != step.visible {
+			t.Errorf("after %s: got %q, expected %q", step.stat, result, step.visible)
+		}
+	}
+
+	if result := mustExecute(t, a, "read * from t order by k"); result != "[1]\n[4]\n[5]\n" {
+		t.Errorf("got %q", result)
+	}
+	a.Close() // 未结束的事务被回滚
+	if result := mustExecute(t, b, "read * from t order by k"); result != "[1]\n[4]\n" {
+		t.Errorf("after close: got %q", result)
+	}
+}
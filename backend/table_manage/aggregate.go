@@ -0,0 +1,488 @@
+/*
+	aggregate.go 实现了带有聚合函数, group by或having的read语句.
+
+	read的每一列, having和order by中引用的每一项, 都被转换为一个column:
+	group by中的字段, 或者一个聚合函数.
+	满足where的entry按group by的字段分组, 每个分组中为每个聚合函数维护一个aggState,
+	最后每个分组产生一行结果, 结果中的值以column的名字为key.
+	没有group by时, 所有的entry属于同一个分组, 即使没有entry, 也会产生一行结果.
+
+	如果没有group by, 且所有的聚合函数都是有索引的字段上的min或max,
+	则直接从索引的一端开始查找第一个可见且满足where的entry, 不需要扫描全表.
+*/
+package table_manage
+
+import (
+	"errors"
+	statement "fansDB/backend/parser"
+	tm "fansDB/backend/transaction_manage"
+	"fansDB/backend/utils"
+	"sort"
+)
+
+var (
+	ErrNotGrouped       = errors.New("Field is not in group by.")
+	ErrInvalidAggregate = errors.New("Invalid aggregate function.")
+)
+
+// column 为聚合结果中的一列
+type column struct {
+	name string
+	fd   *field // count(*)时为nil
+	agg  string // 聚合函数, 为空时表示group by中的字段
+}
+
+// aggState 为一个聚合函数在一个分组中的中间状态
+type aggState struct {
+	count uint64
+	sum   uint64 // 无符号整数的和
+	isum  int64  // 有符号整数的和
+	fsum  float64
+	value interface{} // min和max的当前值
+}
+
+type group struct {
+	values entry // group by中字段的值
+	states []*aggState
+}
+
+// isAggregateRead 判断read是否需要聚合
+func isAggregateRead(read *statement.Read) bool {
+	if len(read.GroupBy) > 0 || read.Having != nil {
+		return true
+	}
+	for _, aggregate := range read.Aggregates {
+		if aggregate != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *table) readAggregate(xid tm.TransactionID, read *statement.Read) (string, error) {
+	err := t.checkWhere(read.Where)
+	if err != nil {
+		return "", err
+	}
+
+	var groupBy []*field
+	for _, name := range read.GroupBy {
+		fd := t.fieldByName(name)
+		if fd == nil {
+			return "", ErrNoThatField
+		}
+		groupBy = append(groupBy, fd)
+	}
+
+	var columns []*column // 所有引用到的列
+	var aggs []*column    // 其中的聚合函数
+	newColumn := func(name string, aggregate *statement.Aggregate) (*column, error) {
+		if c := columnByName(columns, name); c != nil {
+			return c, nil
+		}
+		c, err := t.newColumn(name, aggregate, groupBy)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+		if c.agg != "" {
+			aggs = append(aggs, c)
+		}
+		return c, nil
+	}
+
+	var outputs []*column
+	for i, name := range read.Fields {
+		var aggregate *statement.Aggregate
+		if i < len(read.Aggregates) {
+			aggregate = read.Aggregates[i]
+		}
+		if name == "*" {
+			for _, fd := range t.fields {
+				c, err := newColumn(fd.FName, nil)
+				if err != nil {
+					return "", err
+				}
+				outputs = append(outputs, c)
+			}
+			continue
+		}
+		c, err := newColumn(name, aggregate)
+		if err != nil {
+			return "", err
+		}
+		outputs = append(outputs, c)
+	}
+	err = walkWhere(read.Having, func(exp *statement.SingleExp) error {
+		_, err := newColumn(exp.Field, exp.Aggregate)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	var orderBy []*column
+	for _, ob := range read.OrderBy {
+		c, err := newColumn(ob.Field, ob.Aggregate)
+		if err != nil {
+			return "", err
+		}
+		orderBy = append(orderBy, c)
+	}
+
+	rows, ok, err := t.aggregateByIndex(xid, read.Where, groupBy, aggs)
+	if err != nil {
+		return "", err
+	}
+	if ok == false {
+		rows, err = t.aggregate(xid, read.Where, groupBy, aggs)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// having
+	var results []entry
+	for _, row := range rows {
+		match, err := evalWhere(read.Having, func(exp *statement.SingleExp) (logic, error) {
+			c := columnByName(columns, exp.Field)
+			return matchValue(c, row[c.name], exp)
+		})
+		if err != nil {
+			return "", err
+		}
+		if match {
+			results = append(results, row)
+		}
+	}
+
+	// order by
+	sort.SliceStable(results, func(i, j int) bool {
+		for k, c := range orderBy {
+			cmp := c.Compare(results[i][c.name], results[j][c.name])
+			if cmp == 0 {
+				continue
+			}
+			if read.OrderBy[k].Desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+
+	// limit offset
+	if read.Offset >= int64(len(results)) {
+		results = nil
+	} else {
+		results = results[read.Offset:]
+	}
+	if read.Limit >= 0 && read.Limit < int64(len(results)) {
+		results = results[:read.Limit]
+	}
+
+	str := ""
+	for _, row := range results {
+		str += rowPrint(row, outputs) + "\n"
+	}
+	return str, nil
+}
+
+// newColumn 将name转换为对应的column, 不是聚合函数的字段必须在groupBy中.
+func (t *table) newColumn(name string, aggregate *statement.Aggregate, groupBy []*field) (*column, error) {
+	if aggregate == nil {
+		for _, fd := range groupBy {
+			if fd.FName == name {
+				return &column{name: name, fd: fd}, nil
+			}
+		}
+		if t.fieldByName(name) == nil {
+			return nil, ErrNoThatField
+		}
+		return nil, ErrNotGrouped
+	}
+
+	c := &column{name: name, agg: aggregate.Func}
+	if aggregate.Field == "*" {
+		if aggregate.Func != "count" {
+			return nil, ErrInvalidAggregate
+		}
+		return c, nil
+	}
+	c.fd = t.fieldByName(aggregate.Field)
+	if c.fd == nil {
+		return nil, ErrNoThatField
+	}
+	if (c.agg == "sum" || c.agg == "avg") && isArithmeticType(c.fd.FType) == false {
+		return nil, ErrInvalidAggregate
+	}
+	return c, nil
+}
+
+// aggregate 扫描所有满足where的entry, 分组并计算聚合函数.
+func (t *table) aggregate(xid tm.TransactionID, where *statement.Where, groupBy []*field, aggs []*column) ([]entry, error) {
+	groups := make(map[string]*group)
+	var order []*group // 分组第一次出现的顺序
+
+	newGroup := func(e entry) *group {
+		g := &group{values: entry{}}
+		for _, fd := range groupBy {
+			g.values[fd.FName] = e[fd.FName]
+		}
+		for range aggs {
+			g.states = append(g.states, new(aggState))
+		}
+		order = append(order, g)
+		return g
+	}
+	if len(groupBy) == 0 {
+		groups[""] = newGroup(nil)
+	}
+
+	err := t.filterEntries(xid, where, nil, func(_ utils.UUID, e entry) (bool, error) {
+		var key []byte
+		for _, fd := range groupBy {
+			if e[fd.FName] == nil { // NULL为一个单独的分组
+				key = append(key, 0)
+			} else {
+				key = append(key, 1)
+				key = append(key, fd.ValueToRaw(e[fd.FName])...)
+			}
+		}
+		g, ok := groups[string(key)]
+		if ok == false {
+			g = newGroup(e)
+			groups[string(key)] = g
+		}
+		for i, c := range aggs {
+			err := c.accumulate(g.states[i], e)
+			if err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []entry
+	for _, g := range order {
+		row := g.values
+		for i, c := range aggs {
+			row[c.name] = c.result(g.states[i])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// aggregateByIndex 尝试通过索引计算min和max, 如果无法通过索引计算, 则返回false.
+func (t *table) aggregateByIndex(xid tm.TransactionID, where *statement.Where, groupBy []*field, aggs []*column) ([]entry, bool, error) {
+	if len(groupBy) > 0 || len(aggs) == 0 {
+		return nil, false, nil
+	}
+	for _, c := range aggs {
+		if c.agg != "min" && c.agg != "max" {
+			return nil, false, nil
+		}
+		if c.fd.IsIndexed() == false || c.fd.IsHashIndexed() || c.fd.IsKeyOrdered() == false {
+			return nil, false, nil
+		}
+	}
+
+	row := entry{}
+	for _, c := range aggs {
+		// key不能唯一确定值时, 和第一个满足条件的entry的key相同的entry都需要比较
+		var value interface{}
+		var found bool
+		var first utils.UUID
+		err := c.fd.idx.Scan(0, utils.INF, c.agg == "max", func(key, uuid utils.UUID) (bool, error) {
+			if found && (c.fd.IsKeyExact() || key != first) {
+				return false, nil
+			}
+			e, ok, err := t.readEntry(xid, uuid, where)
+			if err != nil || ok == false {
+				return true, err
+			}
+			v := e[c.fd.FName]
+			cmp := c.fd.Compare(v, value)
+			if found == false || (c.agg == "min" && cmp < 0) || (c.agg == "max" && cmp > 0) {
+				value = v
+			}
+			found, first = true, key
+			return true, nil
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		row[c.name] = value
+	}
+	return []entry{row}, true, nil
+}
+
+// accumulate 将e加入到聚合函数的状态st中, 除了count(*)外, 聚合函数都忽略NULL.
+func (c *column) accumulate(st *aggState, e entry) error {
+	if c.fd == nil {
+		st.count++
+		return nil
+	}
+
+	v := e[c.fd.FName]
+	if v == nil {
+		return nil
+	}
+	st.count++
+	switch c.agg {
+	case "sum", "avg":
+		switch v := widen(v).(type) {
+		case uint64:
+			if c.agg == "sum" && st.sum+v < st.sum {
+				return ErrOverflow
+			}
+			st.sum += v
+			st.fsum += float64(v)
+		case int64:
+			if c.agg == "sum" {
+				sum, err := calInt64("+", st.isum, v)
+				if err != nil {
+					return err
+				}
+				st.isum = sum
+			}
+			st.fsum += float64(v)
+		case float64:
+			st.fsum += v
+		}
+	case "min":
+		if st.value == nil || c.fd.Compare(v, st.value) < 0 {
+			st.value = v
+		}
+	case "max":
+		if st.value == nil || c.fd.Compare(v, st.value) > 0 {
+			st.value = v
+		}
+	}
+	return nil
+}
+
+// result 返回聚合函数的结果, 没有值时返回nil
+func (c *column) result(st *aggState) interface{} {
+	switch c.agg {
+	case "count":
+		return st.count
+	case "sum":
+		if st.count == 0 {
+			return nil
+		}
+		switch c.valueType() {
+		case "int64":
+			return st.isum
+		case "float64":
+			return st.fsum
+		}
+		return st.sum
+	case "avg":
+		if st.count == 0 {
+			return nil
+		}
+		return st.fsum / float64(st.count)
+	default:
+		return st.value
+	}
+}
+
+// valueType 返回该列的值的类型
+func (c *column) valueType() string {
+	switch c.agg {
+	case "count":
+		return "uint64"
+	case "sum":
+		switch c.fd.FType {
+		case "int32", "int64":
+			return "int64"
+		case "float64":
+			return "float64"
+		}
+		return "uint64"
+	case "avg":
+		return "float64"
+	default:
+		return c.fd.FType
+	}
+}
+
+func (c *column) StrToValue(valStr string) (interface{}, error) {
+	switch c.valueType() {
+	case "uint64":
+		return utils.StrToUint64(valStr)
+	case "int64":
+		return utils.StrToInt64(valStr)
+	case "float64":
+		return utils.StrToFloat64(valStr)
+	default:
+		return c.fd.StrToValue(valStr)
+	}
+}
+
+// Compare 比较两个值, nil比其他值都小
+func (c *column) Compare(v0, v1 interface{}) int {
+	if c.fd != nil && c.valueType() == c.fd.FType {
+		return c.fd.Compare(v0, v1)
+	}
+	if v0 == nil || v1 == nil {
+		if v0 == nil && v1 == nil {
+			return 0
+		} else if v0 == nil {
+			return -1
+		}
+		return 1
+	}
+
+	switch c.valueType() {
+	case "uint64":
+		return compareUint64(v0.(uint64), v1.(uint64))
+	case "int64":
+		return compareInt64(v0.(int64), v1.(int64))
+	default:
+		return compareFloat64(v0.(float64), v1.(float64))
+	}
+}
+
+func (c *column) ValuePrint(v interface{}) string {
+	if v == nil {
+		return "NULL"
+	}
+	switch c.valueType() {
+	case "uint64":
+		return utils.Uint64ToStr(v.(uint64))
+	case "int64":
+		return utils.Int64ToStr(v.(int64))
+	case "float64":
+		return utils.Float64ToStr(v.(float64))
+	default:
+		return c.fd.ValuePrint(v)
+	}
+}
+
+func columnByName(columns []*column, name string) *column {
+	for _, c := range columns {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// rowPrint 按columns的顺序打印row中对应的值
+func rowPrint(row entry, columns []*column) string {
+	str := "["
+	for i, c := range columns {
+		str += c.ValuePrint(row[c.name])
+		if i == len(columns)-1 {
+			str += "]"
+		} else {
+			str += ", "
+		}
+	}
+	return str
+}
@@ -0,0 +1,127 @@
+package table_manage
+
+import (
+	statement "fansDB/backend/parser"
+	"testing"
+)
+
+// aggregateTable 创建表g并写入几行, 其中name有三个值的前8个字节相同
+func aggregateTable(db *testDB) {
+	db.mustRun(
+		"create table g k int64, dept string, sal int64, name string (index k sal name)",
+		"insert into g values (1, 'a', 100, 'abcdefghZ'), (2, 'a', 300, 'abcdefghA'), (3, 'b', 200, 'b'), "+
+			"(4, 'b', null, 'c'), (5, 'c', null, null), (6, 'c', -50, 'abcdefgh')",
+	)
+}
+
+// TestGroupByHaving 检查group by, having, 以及聚合结果的order by, limit和offset.
+// NULL为一个单独的分组, 聚合函数忽略NULL, 没有group by时即使没有entry也产生一行结果.
+func TestGroupByHaving(t *testing.T) {
+	db := openTestDB(t)
+	aggregateTable(db)
+
+	tests := []struct {
+		stat     string
+		expected string
+	}{
+		{
+			"read dept, count(*), count(sal), sum(sal), min(name) from g group by dept order by dept",
+			"[a, 2, 2, 400, abcdefghA]\n[b, 2, 1, 200, b]\n[c, 2, 1, -50, abcdefgh]\n",
+		},
+		{"read dept from g group by dept having count(sal) = 1 order by dept", "[b]\n[c]\n"},
+		{"read dept from g group by dept having sum(sal) > 100 and min(name) != 'b'", "[a]\n"},
+		{"read dept, sum(sal) from g where k > 3 group by dept order by dept", "[b, NULL]\n[c, -50]\n"},
+		{"read sal, count(*) from g group by sal order by sal", "[NULL, 2]\n[-50, 1]\n[100, 1]\n[200, 1]\n[300, 1]\n"},
+		{"read dept, max(sal) from g group by dept order by max(sal) desc limit 2", "[a, 300]\n[b, 200]\n"},
+		{"read dept from g group by dept order by dept limit 5 offset 2", "[c]\n"},
+		{"read avg(sal) from g where dept = 'a'", "[200]\n"},
+		{"read count(*), max(sal) from g where k > 100", "[0, NULL]\n"},
+		{"read count(*) from g where k > 100 group by dept", ""},
+	}
+	for _, test := range tests {
+		result, err := db.run(test.stat)
+		if err != nil {
+			t.Errorf("%s: %v", test.stat, err)
+		} else if result != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.stat, result, test.expected)
+		}
+	}
+
+	errTests := []struct {
+		stat     string
+		expected error
+	}{
+		{"read name, count(*) from g group by dept", ErrNotGrouped},
+		{"read dept from g group by dept having sal > 1", ErrNotGrouped},
+		{"read sum(name) from g", ErrInvalidAggregate},
+		{"read count(nope) from g", ErrNoThatField},
+		{"read count(*) from g group by nope", ErrNoThatField},
+	}
+	for _, test := range errTests {
+		_, err := db.run(test.stat)
+		if err != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.stat, err, test.expected)
+		}
+	}
+}
+
+// TestAggregateByIndex 检查通过索引计算的min和max与扫描全表的结果相同,
+// 包括where过滤, key相同但值不同的string, 以及其他事务未提交的entry.
+func TestAggregateByIndex(t *testing.T) {
+	db := openTestDB(t)
+	aggregateTable(db)
+	db.mustRun("insert into g values (7, 'd', 0, 'abcdefghB')")
+	tb := db.tableManager.tableCacher["g"]
+
+	other := db.begin()
+	db.mustExec(other, "insert into g values (0, 'z', -1000, 'a')")
+	defer db.tableManager.Abort(other)
+
+	tests := []struct {
+		agg, field, where string
+		expected          interface{}
+	}{
+		{"min", "sal", "", int64(-50)},
+		{"max", "sal", "", int64(300)},
+		{"min", "name", "", "abcdefgh"},
+		{"max", "name", "", "c"},
+		{"min", "k", "dept = 'b'", int64(3)},
+		{"max", "sal", "dept = 'c'", int64(-50)},
+		{"max", "sal", "dept = 'z'", nil},
+		{"min", "name", "k < 6", "abcdefghA"},
+		{"min", "name", "k != 6", "abcdefghA"},
+		{"max", "name", "name < 'b'", "abcdefghZ"},
+	}
+	xid := db.begin()
+	defer db.tableManager.Abort(xid)
+	for _, test := range tests {
+		var where *statement.Where
+		if test.where != "" {
+			where = parse(t, "read k from g where "+test.where).(*statement.Read).Where
+		}
+		aggs := []*column{{name: test.agg, fd: tb.fieldByName(test.field), agg: test.agg}}
+
+		rows, ok, err := tb.aggregateByIndex(xid, where, nil, aggs)
+		if err != nil || ok == false {
+			t.Errorf("%s(%s) where %s: got %v, %v", test.agg, test.field, test.where, ok, err)
+			continue
+		}
+		full, err := tb.aggregate(xid, where, nil, aggs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rows[0][test.agg] != test.expected || full[0][test.agg] != test.expected {
+			t.Errorf("%s(%s) where %s: got %v by index, %v by scan, expected %v",
+				test.agg, test.field, test.where, rows[0][test.agg], full[0][test.agg], test.expected)
+		}
+	}
+
+	aggs := []*column{{name: "min", fd: tb.fieldByName("dept"), agg: "min"}}
+	if _, ok, _ := tb.aggregateByIndex(xid, nil, nil, aggs); ok {
+		t.Errorf("min of a field without index was computed by index")
+	}
+	aggs = []*column{{name: "count", fd: tb.fieldByName("k"), agg: "count"}}
+	if _, ok, _ := tb.aggregateByIndex(xid, nil, nil, aggs); ok {
+		t.Errorf("count was computed by index")
+	}
+}
@@ -0,0 +1,225 @@
+/*
+	expr.go 实现了update中算术表达式的类型检查和求值.
+
+	表达式的结果必须和被赋值的字段类型相同, 其中引用的字段也必须是该类型,
+	例外是uint64的表达式中可以引用uint32的字段, int64的表达式中可以引用int32的字段.
+	字面值按该类型进行解析. 只有整数和float64能参与运算, 其余类型只能直接赋值.
+	有一个操作数为NULL时, 运算的结果为NULL.
+	整数的运算结果超出范围时, 返回ErrOverflow, 无符号整数减法结果为负数时也是如此;
+	float64的运算结果为无穷大时也返回ErrOverflow. 除数为0时返回ErrDivideByZero.
+*/
+package table_manage
+
+import (
+	statement "fansDB/backend/parser"
+	"math"
+)
+
+// exprField 如果exp是对该表某个字段的引用, 则返回该字段
+func (t *table) exprField(exp *statement.Expr) *field {
+	if exp.Op != "" || exp.Quoted || exp.Null {
+		return nil
+	}
+	return t.fieldByName(exp.Value)
+}
+
+// checkExpr 检查exp能否赋值给fd
+func (t *table) checkExpr(fd *field, exp *statement.Expr) error {
+	if exp.Null {
+		return nil
+	}
+	if exp.Op == "" {
+		if ref := t.exprField(exp); ref != nil {
+			if ref.FType != fd.FType && canWiden(ref.FType, fd.FType) == false {
+				return ErrTypeMismatch
+			}
+			return nil
+		}
+		_, err := fd.StrToValue(exp.Value)
+		return err
+	}
+
+	if isArithmeticType(fd.FType) == false {
+		return ErrTypeMismatch
+	}
+	err := t.checkExpr(fd, exp.Left)
+	if err != nil {
+		return err
+	}
+	return t.checkExpr(fd, exp.Right)
+}
+
+// evalExpr 在e上对exp求值, 结果的类型为fd的类型, exp需要先通过checkExpr的检查.
+func (t *table) evalExpr(fd *field, exp *statement.Expr, e entry) (interface{}, error) {
+	if exp.Null {
+		return nil, nil
+	}
+	if exp.Op == "" {
+		if ref := t.exprField(exp); ref != nil {
+			if ref.FType != fd.FType && e[ref.FName] != nil {
+				return widen(e[ref.FName]), nil
+			}
+			return e[ref.FName], nil
+		}
+		return fd.StrToValue(exp.Value)
+	}
+
+	left, err := t.evalExpr(fd, exp.Left, e)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.evalExpr(fd, exp.Right, e)
+	if err != nil {
+		return nil, err
+	}
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	switch fd.FType {
+	case "uint32":
+		v, err := calUint64(exp.Op, uint64(left.(uint32)), uint64(right.(uint32)))
+		if err != nil {
+			return nil, err
+		}
+		if v > math.MaxUint32 {
+			return nil, ErrOverflow
+		}
+		return uint32(v), nil
+	case "uint64":
+		return calUint64(exp.Op, left.(uint64), right.(uint64))
+	case "int32":
+		v, err := calInt64(exp.Op, int64(left.(int32)), int64(right.(int32)))
+		if err != nil {
+			return nil, err
+		}
+		if v > math.MaxInt32 || v < math.MinInt32 {
+			return nil, ErrOverflow
+		}
+		return int32(v), nil
+	case "int64":
+		return calInt64(exp.Op, left.(int64), right.(int64))
+	case "float64":
+		return calFloat64(exp.Op, left.(float64), right.(float64))
+	default:
+		return nil, ErrTypeMismatch
+	}
+}
+
+// calUint64 计算a op b, 并检查溢出
+func calUint64(op string, a, b uint64) (uint64, error) {
+	switch op {
+	case "+":
+		if a+b < a {
+			return 0, ErrOverflow
+		}
+		return a + b, nil
+	case "-":
+		if b > a {
+			return 0, ErrOverflow
+		}
+		return a - b, nil
+	case "*":
+		if a != 0 && (a*b)/a != b {
+			return 0, ErrOverflow
+		}
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, ErrDivideByZero
+		}
+		if op == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	default:
+		return 0, ErrInvalidValues
+	}
+}
+
+// calInt64 计算a op b, 并检查溢出
+func calInt64(op string, a, b int64) (int64, error) {
+	switch op {
+	case "+":
+		if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+			return 0, ErrOverflow
+		}
+		return a + b, nil
+	case "-":
+		if (b < 0 && a > math.MaxInt64+b) || (b > 0 && a < math.MinInt64+b) {
+			return 0, ErrOverflow
+		}
+		return a - b, nil
+	case "*":
+		if a != 0 && ((a*b)/a != b || (a == -1 && b == math.MinInt64)) {
+			return 0, ErrOverflow
+		}
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, ErrDivideByZero
+		}
+		if op == "/" {
+			if a == math.MinInt64 && b == -1 {
+				return 0, ErrOverflow
+			}
+			return a / b, nil
+		}
+		return a % b, nil
+	default:
+		return 0, ErrInvalidValues
+	}
+}
+
+// calFloat64 计算a op b, 结果为无穷大时返回ErrOverflow
+func calFloat64(op string, a, b float64) (float64, error) {
+	var v float64
+	switch op {
+	case "+":
+		v = a + b
+	case "-":
+		v = a - b
+	case "*":
+		v = a * b
+	case "/", "%":
+		if b == 0 {
+			return 0, ErrDivideByZero
+		}
+		if op == "/" {
+			v = a / b
+		} else {
+			v = math.Mod(a, b)
+		}
+	default:
+		return 0, ErrInvalidValues
+	}
+	if math.IsInf(v, 0) {
+		return 0, ErrOverflow
+	}
+	return normalizeFloat64(v), nil
+}
+
+// isArithmeticType 判断ftype类型的值能否参与运算
+func isArithmeticType(ftype string) bool {
+	switch ftype {
+	case "uint32", "uint64", "int32", "int64", "float64":
+		return true
+	}
+	return false
+}
+
+// canWiden 判断from类型的值能否无损地转换为to类型
+func canWiden(from, to string) bool {
+	return (from == "uint32" && to == "uint64") || (from == "int32" && to == "int64")
+}
+
+// widen 将uint32和int32的值分别转换为uint64和int64
+func widen(v interface{}) interface{} {
+	switch v := v.(type) {
+	case uint32:
+		return uint64(v)
+	case int32:
+		return int64(v)
+	}
+	return v
+}
@@ -0,0 +1,47 @@
+package table_manage
+
+import (
+	"testing"
+)
+
+// TestUpdateExpr 检查update中算术表达式的溢出, 除以0, NULL的传递, 以及uint32到uint64的转换.
+// 出错的update不会修改任何值.
+func TestUpdateExpr(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table e k int64, a int64, b uint64, c uint32, d float64, n int64, s int32 (index k)",
+		"insert into e values (1, 9223372036854775807, 0, 4294967295, 2, null, 7)",
+	)
+
+	tests := []struct {
+		stat     string
+		expected error
+	}{
+		{"update e set a = a + 1", ErrOverflow},
+		{"update e set a = a * 2", ErrOverflow},
+		{"update e set a = 0 - a - 2", ErrOverflow},
+		{"update e set b = b - 1", ErrOverflow},
+		{"update e set c = c + 1", ErrOverflow},
+		{"update e set s = s * 1000000000", ErrOverflow},
+		{"update e set d = d * 1e308", ErrOverflow},
+		{"update e set a = a / 0", ErrDivideByZero},
+		{"update e set b = b % 0", ErrDivideByZero},
+		{"update e set d = d / 0", ErrDivideByZero},
+		{"update e set c = b", ErrTypeMismatch},
+		{"update e set a = d", ErrTypeMismatch},
+		{"update e set n = n * 2 + 1", nil},
+		{"update e set k = k + n", nil},
+		{"update e set b = c + 1", nil},
+	}
+	for _, test := range tests {
+		_, err := db.run(test.stat)
+		if err != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.stat, err, test.expected)
+		}
+	}
+
+	expected := "[NULL, 9223372036854775807, 4294967296, 4294967295, 2, NULL, 7]\n"
+	if result := db.mustRun("read * from e"); result != expected {
+		t.Errorf("got %s, expected %s", result, expected)
+	}
+}
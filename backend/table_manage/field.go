@@ -2,20 +2,45 @@
 // @Author: fzw
 // @Create: ${YEAR}-${MONTH}-${DAY} ${HOUR}:${MINUTE}
 // @Description: 字段管理，管理具体字段
-// 格式为 [Field Name] [Type Name] [Index UUID]
+// 格式为 [Field Name] [Type Name] [Index UUID] [Flags] [Default]
+// 其中Flags为1字节, 由_FIELD_NOT_NULL, _FIELD_HAS_DEFAULT, _FIELD_PREFIX_KEY, _FIELD_UNIQUE, _FIELD_PRIMARY_KEY
+// 和_FIELD_HASH_INDEX组成, 有默认值时才有Default, 为VarStr. 旧版本创建的字段没有Flags和Default.
+// 有_FIELD_HASH_INDEX时, Index UUID指向一个hash索引(见index_manage.LoadHash), 否则指向一棵B+树.
+//
+// 支持的类型及其在entry中的格式:
+// uint32, int32: 4字节; uint64, int64: 8字节; float64: 8字节的IEEE 754; bool: 1字节;
+// timestamp: 8字节, 为距1970-01-01 00:00:00 UTC的微秒数; string, bytes: VarStr.
+// 除了string和bytes, 各类型的索引key都保持了值的顺序, 且能唯一确定值.
+// string和bytes的key为值的前8个字节(_FIELD_PREFIX_KEY), 保持了顺序, 但不同的值可能有相同的key,
+// 所以查找的结果都需要再用完整的值过滤. 旧版本创建的string和bytes字段的key为hash值, 没有顺序.
+// hash索引和B+树使用相同的key, 但只按key等值查找, 所以只用于"="和"in".
 package table_manage
 
 import (
+	"bytes"
 	"errors"
 	im "fansDB/backend/index_manage"
 	statement "fansDB/backend/parser"
 	tm "fansDB/backend/transaction_manage"
 	"fansDB/backend/utils"
+	"math"
+	"strings"
+	"time"
 )
 
 var (
 	ErrInvalidFieldType  = errors.New("Invalid field type.")
 	ErrInvalidFieldValue = errors.New("Invalid field value.")
+	ErrNotNull           = errors.New("Field cannot be null.")
+)
+
+const (
+	_FIELD_NOT_NULL    = 1 << 0
+	_FIELD_HAS_DEFAULT = 1 << 1
+	_FIELD_PREFIX_KEY  = 1 << 2
+	_FIELD_UNIQUE      = 1 << 3
+	_FIELD_PRIMARY_KEY = 1 << 4
+	_FIELD_HASH_INDEX  = 1 << 5
 )
 
 type field struct {
@@ -25,7 +50,15 @@ type field struct {
 	FName string
 	FType string
 	index utils.UUID
-	bt    im.BPlusTree
+	idx   im.Index // 索引, 为B+树或hash索引
+
+	notNull      bool
+	hasDefault   bool
+	defaultValue interface{}
+	prefixKey    bool // string和bytes的key是否为前缀
+	unique       bool // 唯一的字段总是有索引, 通过索引检查冲突, 见table.checkUnique
+	primaryKey   bool // 主键是唯一且不能为NULL的
+	hashIndex    bool // 索引是否为hash索引
 }
 
 /*
@@ -53,39 +86,98 @@ func (f *field) parseSelf(raw []byte) {
 	f.FType, shift = utils.ParseVarStr(raw[pos:])
 	pos += shift
 	f.index = utils.ParseUUID(raw[pos:])
+	pos += utils.LEN_UUID
+
+	if pos < len(raw) {
+		flags := raw[pos]
+		pos++
+		f.notNull = flags&_FIELD_NOT_NULL != 0
+		f.prefixKey = flags&_FIELD_PREFIX_KEY != 0
+		f.unique = flags&_FIELD_UNIQUE != 0
+		f.primaryKey = flags&_FIELD_PRIMARY_KEY != 0
+		f.hashIndex = flags&_FIELD_HASH_INDEX != 0
+		if flags&_FIELD_HAS_DEFAULT != 0 {
+			var defaultStr string
+			defaultStr, shift = utils.ParseVarStr(raw[pos:])
+			pos += shift
+			v, err := f.StrToValue(defaultStr)
+			if err != nil {
+				panic(err)
+			}
+			f.hasDefault = true
+			f.defaultValue = v
+		}
+	}
+
 	if f.index != utils.NilUUID {
-		var err error
-		f.bt, err = im.Load(f.index, f.table.TableManager.DataManager)
+		err := f.loadIndex()
 		if err != nil {
 			panic(err)
 		}
 	}
 }
 
-func CreateField(tb *table, xid tm.TransactionID, fname, ftype string, indexed bool) (*field, error) {
+// createIndex 为f新建一个空的索引, hash为true时为hash索引, 否则为B+树
+func (f *field) createIndex(hash bool) error {
+	var err error
+	if hash {
+		f.index, err = im.CreateHash(f.table.TableManager.DataManager)
+	} else {
+		f.index, err = im.Create(f.table.TableManager.DataManager)
+	}
+	if err != nil {
+		return err
+	}
+	f.hashIndex = hash
+	return f.loadIndex()
+}
+
+// loadIndex 按索引的类型读取f.index指向的索引
+func (f *field) loadIndex() error {
+	var err error
+	if f.hashIndex {
+		f.idx, err = im.LoadHash(f.index, f.table.TableManager.DataManager)
+	} else {
+		f.idx, err = im.Load(f.index, f.table.TableManager.DataManager)
+	}
+	return err
+}
+
+// CreateField 创建一个字段, defaultValue为nil时表示没有默认值.
+// 主键总是唯一且不能为NULL, 唯一的字段总是有索引. hash为true时, 字段的索引为hash索引.
+func CreateField(tb *table, xid tm.TransactionID, fname, ftype string, indexed, hash bool,
+	notNull, unique, primaryKey bool, defaultValue *statement.Value) (*field, error) {
 	err := typeCheck(ftype)
 	if err != nil {
 		return nil, err
 	}
 
 	f := &field{
-		table: tb,
-		FName: fname,
-		FType: ftype,
-		index: utils.NilUUID,
+		table:      tb,
+		FName:      fname,
+		FType:      ftype,
+		index:      utils.NilUUID,
+		notNull:    notNull || primaryKey,
+		prefixKey:  ftype == "string" || ftype == "bytes",
+		unique:     unique || primaryKey,
+		primaryKey: primaryKey,
 	}
 
-	if indexed {
-		index, err := im.Create(tb.TableManager.DataManager)
+	if defaultValue != nil && defaultValue.Null == false {
+		f.hasDefault = true
+		f.defaultValue, err = f.StrToValue(defaultValue.Str)
 		if err != nil {
 			return nil, err
 		}
-		bt, err := im.Load(index, tb.TableManager.DataManager)
+	} else if defaultValue != nil && f.notNull {
+		return nil, ErrNotNull
+	}
+
+	if indexed || f.unique {
+		err = f.createIndex(hash)
 		if err != nil {
 			return nil, err
 		}
-		f.index = index
-		f.bt = bt
 	}
 
 	err = f.persistSelf(xid)
@@ -101,6 +193,29 @@ func (f *field) persistSelf(xid tm.TransactionID) error {
 	raw := utils.VarStrToRaw(f.FName)
 	raw = append(raw, utils.VarStrToRaw(f.FType)...)
 	raw = append(raw, utils.UUIDToRaw(f.index)...)
+	var flags byte
+	if f.notNull {
+		flags |= _FIELD_NOT_NULL
+	}
+	if f.hasDefault {
+		flags |= _FIELD_HAS_DEFAULT
+	}
+	if f.prefixKey {
+		flags |= _FIELD_PREFIX_KEY
+	}
+	if f.unique {
+		flags |= _FIELD_UNIQUE
+	}
+	if f.primaryKey {
+		flags |= _FIELD_PRIMARY_KEY
+	}
+	if f.hashIndex {
+		flags |= _FIELD_HASH_INDEX
+	}
+	raw = append(raw, flags)
+	if f.hasDefault {
+		raw = append(raw, utils.VarStrToRaw(f.ValuePrint(f.defaultValue))...)
+	}
 	self, err := f.table.TableManager.SerializabilityManager.Insert(xid, raw)
 	if err != nil {
 		return err
@@ -109,38 +224,141 @@ func (f *field) persistSelf(xid tm.TransactionID) error {
 	return nil
 }
 
+// Drop 在xid中删除该字段的记录.
+// DM不支持回收空间, 所以索引占用的dataitem不会被释放, 只是在提交后不再被任何表引用.
+func (f *field) Drop(xid tm.TransactionID) error {
+	_, err := f.table.TableManager.SerializabilityManager.Delete(xid, f.SelfUUID)
+	return err
+}
+
 func typeCheck(ftype string) error {
-	if ftype != "uint32" && ftype != "uint64" && ftype != "string" {
-		return ErrInvalidFieldType
+	switch ftype {
+	case "uint32", "uint64", "int32", "int64", "float64", "bool", "timestamp", "string", "bytes":
+		return nil
 	}
-	return nil
+	return ErrInvalidFieldType
 }
 
 func (f *field) Print() string {
 	str := "("
 	str += f.FName
 	str += ", " + f.FType
-	if f.index != utils.NilUUID {
+	if f.hashIndex {
+		str += ", HashIndex"
+	} else if f.index != utils.NilUUID {
 		str += ", Index"
 	} else {
 		str += ", NoIndex"
 	}
+	if f.primaryKey {
+		str += ", PrimaryKey"
+	} else if f.unique {
+		str += ", Unique"
+	}
+	if f.notNull && f.primaryKey == false {
+		str += ", NotNull"
+	}
+	if f.hasDefault {
+		str += ", Default " + f.ValuePrint(f.defaultValue)
+	}
 	str += ")"
 	return str
 }
 
+// withIndex 在xid中为f写入一条新的记录, 返回f在表tb中的新版本.
+// indexed为true时, 新版本带有一个新建的空索引, hash为true时为hash索引, 否则为B+树; indexed为false时没有索引.
+func (f *field) withIndex(xid tm.TransactionID, tb *table, indexed, hash bool) (*field, error) {
+	nf := *f
+	nf.table = tb
+	nf.index = utils.NilUUID
+	nf.idx = nil
+	nf.hashIndex = false
+	if indexed {
+		err := nf.createIndex(hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := nf.persistSelf(xid)
+	if err != nil {
+		return nil, err
+	}
+	return &nf, nil
+}
+
 func (f *field) IsIndexed() bool {
 	return f.index != utils.NilUUID
 }
 
+// IsHashIndexed 返回该字段的索引是否为hash索引, hash索引只适合等值查找, 区间查找需要读出整个索引.
+func (f *field) IsHashIndexed() bool {
+	return f.hashIndex
+}
+
+// IsKeyOrdered 返回该字段索引的key是否保持了值的顺序.
+// 旧版本的string和bytes的key为hash值, 只能用于等值查找, 即"="和"in".
+func (f *field) IsKeyOrdered() bool {
+	return f.IsKeyExact() || f.prefixKey
+}
+
+// IsKeyExact 返回该字段索引的key是否能唯一确定值, 即不同的值有不同的key.
+func (f *field) IsKeyExact() bool {
+	return f.FType != "string" && f.FType != "bytes"
+}
+
 // Insert 将(key, uuid)这键值对插入到该field的索引中
 func (f *field) Insert(key interface{}, uuid utils.UUID) error {
 	ukey := f.ValueToUUID(key)
-	return f.bt.Insert(ukey, uuid)
+	return f.idx.Insert(ukey, uuid)
+}
+
+// insertEntry 将entry e中该字段的值加入到索引中, NULL不会被加入
+func (f *field) insertEntry(e entry, uuid utils.UUID) error {
+	if e[f.FName] == nil {
+		return nil
+	}
+	return f.Insert(e[f.FName], uuid)
+}
+
+// indexKey 返回entry e在该字段的索引中的key, NULL不会被加入索引
+func (f *field) indexKey(e entry) ([]byte, bool) {
+	if e[f.FName] == nil {
+		return nil, false
+	}
+	return im.UUIDToKey(f.ValueToUUID(e[f.FName])), true
+}
+
+// bulkLoad 将entries批量加载到该字段的索引中, hash索引没有批量加载, 逐个插入
+func (f *field) bulkLoad(entries []im.Entry) error {
+	if bt, ok := f.idx.(im.BPlusTree); ok {
+		return bt.BulkLoad(entries, im.DEFAULT_FILL_FACTOR)
+	}
+	for _, e := range entries {
+		err := f.idx.Insert(im.KeyToUUID(e.Key), e.UUID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteEntry 将entry e中该字段的值从索引中删除
+func (f *field) deleteEntry(e entry, uuid utils.UUID) error {
+	if e[f.FName] == nil {
+		return nil
+	}
+	_, err := f.idx.Delete(f.ValueToUUID(e[f.FName]), uuid)
+	return err
 }
 
 func (f *field) Search(left, right utils.UUID) ([]utils.UUID, error) {
-	return f.bt.SearchRange(left, right)
+	return f.idx.SearchRange(left, right)
+}
+
+// Iterate 返回按key的顺序遍历[left, right]的迭代器, desc为true时从大到小遍历
+func (f *field) Iterate(left, right utils.UUID, desc bool) (im.Iterator, error) {
+	return f.idx.Iterate(left, right, desc)
 }
 
 func (f *field) StrToValue(valStr string) (interface{}, error) {
@@ -151,8 +369,25 @@ func (f *field) StrToValue(valStr string) (interface{}, error) {
 		v, err = utils.StrToUint32(valStr)
 	case "uint64":
 		v, err = utils.StrToUint64(valStr)
+	case "int32":
+		v, err = utils.StrToInt32(valStr)
+	case "int64":
+		v, err = utils.StrToInt64(valStr)
+	case "float64":
+		var fv float64
+		fv, err = utils.StrToFloat64(valStr)
+		if err == nil && (math.IsNaN(fv) || math.IsInf(fv, 0)) {
+			err = ErrInvalidFieldValue
+		}
+		v = normalizeFloat64(fv)
+	case "bool":
+		v, err = utils.StrToBool(valStr)
+	case "timestamp":
+		v, err = utils.StrToTimestamp(valStr)
 	case "string":
 		v = valStr
+	case "bytes":
+		v, err = utils.StrToBytes(valStr)
 	}
 	if err != nil {
 		return nil, err
@@ -160,6 +395,43 @@ func (f *field) StrToValue(valStr string) (interface{}, error) {
 	return v, nil
 }
 
+// Nullable 返回该字段的值能否为NULL.
+// 旧版本的表中, entry没有null bitmap, 所以其字段都不能为NULL.
+func (f *field) Nullable() bool {
+	return f.notNull == false && f.table.hasNullBitmap()
+}
+
+// DefaultValue 返回insert时未给出该字段的值时, 该字段的值.
+// 没有指定默认值时, 可以为NULL的字段取NULL, 旧版本的表中的字段取其类型的零值,
+// 否则返回ErrNotNull.
+func (f *field) DefaultValue() (interface{}, error) {
+	if f.hasDefault {
+		return f.defaultValue, nil
+	}
+	if f.Nullable() {
+		return nil, nil
+	}
+	if f.table.hasNullBitmap() {
+		return nil, ErrNotNull
+	}
+	switch f.FType {
+	case "uint32":
+		return uint32(0), nil
+	case "uint64":
+		return uint64(0), nil
+	default:
+		return "", nil
+	}
+}
+
+// CheckValue 检查v能否作为该字段的值
+func (f *field) CheckValue(v interface{}) error {
+	if v == nil && f.Nullable() == false {
+		return ErrNotNull
+	}
+	return nil
+}
+
 func (f *field) ValueToRaw(v interface{}) []byte {
 	var raw []byte
 	switch f.FType {
@@ -167,8 +439,23 @@ func (f *field) ValueToRaw(v interface{}) []byte {
 		raw = utils.Uint32ToRaw(v.(uint32))
 	case "uint64":
 		raw = utils.Uint64ToRaw(v.(uint64))
+	case "int32":
+		raw = utils.Int32ToRaw(v.(int32))
+	case "int64":
+		raw = utils.Int64ToRaw(v.(int64))
+	case "float64":
+		raw = utils.Float64ToRaw(v.(float64))
+	case "bool":
+		raw = []byte{0}
+		if v.(bool) {
+			raw[0] = 1
+		}
+	case "timestamp":
+		raw = utils.Int64ToRaw(utils.TimestampToMicro(v.(time.Time)))
 	case "string": // 转换为VarStr
 		raw = utils.VarStrToRaw(v.(string))
+	case "bytes":
+		raw = utils.VarBytesToRaw(v.([]byte))
 	}
 	return raw
 }
@@ -183,8 +470,25 @@ func (f *field) ParseValue(raw []byte) (interface{}, int) {
 	case "uint64":
 		v = utils.ParseUint64(raw)
 		shift = 8
+	case "int32":
+		v = utils.ParseInt32(raw)
+		shift = 4
+	case "int64":
+		v = utils.ParseInt64(raw)
+		shift = 8
+	case "float64":
+		v = utils.ParseFloat64(raw)
+		shift = 8
+	case "bool":
+		v = raw[0] != 0
+		shift = 1
+	case "timestamp":
+		v = utils.MicroToTimestamp(utils.ParseInt64(raw))
+		shift = 8
 	case "string": // 解析出VarStr
 		v, shift = utils.ParseVarStr(raw)
+	case "bytes":
+		v, shift = utils.ParseVarBytes(raw)
 	}
 	return v, shift
 }
@@ -196,54 +500,217 @@ func (f *field) ValueToUUID(v interface{}) utils.UUID {
 		uuid = utils.UUID(v.(uint32))
 	case "uint64":
 		uuid = utils.UUID(v.(uint64))
-	case "string": // 解析出VarStr
-		uuid = utils.StrToUUID(v.(string))
+	case "int32":
+		uuid = utils.Int64ToKey(int64(v.(int32)))
+	case "int64":
+		uuid = utils.Int64ToKey(v.(int64))
+	case "float64":
+		uuid = utils.Float64ToKey(v.(float64))
+	case "bool":
+		uuid = utils.BoolToKey(v.(bool))
+	case "timestamp":
+		uuid = utils.Int64ToKey(utils.TimestampToMicro(v.(time.Time)))
+	case "string":
+		uuid = f.strToKey(v.(string))
+	case "bytes":
+		uuid = f.strToKey(string(v.([]byte)))
 	}
 	return uuid
 }
 
+func (f *field) strToKey(str string) utils.UUID {
+	if f.prefixKey {
+		return utils.StrToPrefixKey(str)
+	}
+	return utils.StrToUUID(str)
+}
+
 func (f *field) ValuePrint(v interface{}) string {
+	if v == nil {
+		return "NULL"
+	}
 	var str string
 	switch f.FType {
 	case "uint32":
 		str = utils.Uint32ToStr(v.(uint32))
 	case "uint64":
 		str = utils.Uint64ToStr(v.(uint64))
+	case "int32":
+		str = utils.Int32ToStr(v.(int32))
+	case "int64":
+		str = utils.Int64ToStr(v.(int64))
+	case "float64":
+		str = utils.Float64ToStr(v.(float64))
+	case "bool":
+		str = utils.BoolToStr(v.(bool))
+	case "timestamp":
+		str = utils.TimestampToStr(v.(time.Time))
 	case "string": // 解析出VarStr
 		str = v.(string)
+	case "bytes":
+		str = utils.BytesToStr(v.([]byte))
 	}
 	return str
 }
 
-/*
-	TODO: right为0和left为INF会有问题
-*/
-func (f *field) CalExp(exp *statement.SingleExp) (left, right utils.UUID, err error) {
-	var v interface{}
-	if exp.CmpOp == "<" {
-		left = 0
-		v, err = f.StrToValue(exp.Value)
-		if err != nil {
-			return
+// Compare 比较该字段的两个值, v0小于, 等于, 大于v1时分别返回-1, 0, 1.
+// NULL比其他值都小.
+func (f *field) Compare(v0, v1 interface{}) int {
+	if v0 == nil || v1 == nil {
+		if v0 == nil && v1 == nil {
+			return 0
+		} else if v0 == nil {
+			return -1
+		}
+		return 1
+	}
+
+	switch f.FType {
+	case "uint32":
+		return compareUint64(uint64(v0.(uint32)), uint64(v1.(uint32)))
+	case "uint64":
+		return compareUint64(v0.(uint64), v1.(uint64))
+	case "int32":
+		return compareInt64(int64(v0.(int32)), int64(v1.(int32)))
+	case "int64":
+		return compareInt64(v0.(int64), v1.(int64))
+	case "float64":
+		return compareFloat64(v0.(float64), v1.(float64))
+	case "bool":
+		return compareUint64(uint64(utils.BoolToKey(v0.(bool))), uint64(utils.BoolToKey(v1.(bool))))
+	case "timestamp":
+		t0, t1 := v0.(time.Time), v1.(time.Time)
+		if t0.Before(t1) {
+			return -1
+		} else if t0.After(t1) {
+			return 1
 		}
-		right = f.ValueToUUID(v)
-		if right > 0 {
-			right--
+		return 0
+	case "string":
+		return strings.Compare(v0.(string), v1.(string))
+	case "bytes":
+		return bytes.Compare(v0.([]byte), v1.([]byte))
+	}
+	return 0
+}
+
+func compareUint64(a, b uint64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareInt64(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareFloat64(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// normalizeFloat64 将-0转换为0, 使相等的值有相同的raw和key
+func normalizeFloat64(v float64) float64 {
+	if v == 0 {
+		return 0
+	}
+	return v
+}
+
+// CalExp 计算exp在该字段索引上对应的区间, 区间为闭区间, 且已排好序, 互不相交.
+// 返回空的区间表示没有值能满足exp. key不能唯一确定值时, 和边界的key相同的值也可能满足exp,
+// 所以区间总是包含边界的key.
+func (f *field) CalExp(exp *statement.SingleExp) ([]keyRange, error) {
+	switch exp.CmpOp {
+	case "between":
+		if len(exp.Values) != 2 {
+			return nil, ErrInvalidValues
 		}
-	} else if exp.CmpOp == "=" {
-		v, err = f.StrToValue(exp.Value)
+		left, err := f.strToUUID(exp.Values[0])
 		if err != nil {
-			return
+			return nil, err
 		}
-		left = f.ValueToUUID(v)
-		right = left
-	} else if exp.CmpOp == ">" {
-		right = utils.INF
-		v, err = f.StrToValue(exp.Value)
+		right, err := f.strToUUID(exp.Values[1])
 		if err != nil {
-			return
+			return nil, err
+		}
+		if left > right {
+			return nil, nil
+		}
+		return []keyRange{{left, right}}, nil
+	case "in":
+		var ranges []keyRange
+		for _, value := range exp.Values {
+			key, err := f.strToUUID(value)
+			if err != nil {
+				return nil, err
+			}
+			ranges = append(ranges, keyRange{key, key})
+		}
+		return unionRanges(ranges, nil), nil
+	}
+
+	key, err := f.strToUUID(exp.Value)
+	if err != nil {
+		return nil, err
+	}
+	if f.IsKeyExact() == false {
+		switch exp.CmpOp {
+		case "<", "<=":
+			return []keyRange{{0, key}}, nil
+		case ">", ">=":
+			return []keyRange{{key, utils.INF}}, nil
+		case "!=":
+			return []keyRange{{0, utils.INF}}, nil
 		}
-		left = f.ValueToUUID(v) + 1
 	}
-	return
+	switch exp.CmpOp {
+	case "=":
+		return []keyRange{{key, key}}, nil
+	case "<":
+		if key == 0 {
+			return nil, nil
+		}
+		return []keyRange{{0, key - 1}}, nil
+	case "<=":
+		return []keyRange{{0, key}}, nil
+	case ">":
+		if key == utils.INF {
+			return nil, nil
+		}
+		return []keyRange{{key + 1, utils.INF}}, nil
+	case ">=":
+		return []keyRange{{key, utils.INF}}, nil
+	case "!=":
+		var ranges []keyRange
+		if key > 0 {
+			ranges = append(ranges, keyRange{0, key - 1})
+		}
+		if key < utils.INF {
+			ranges = append(ranges, keyRange{key + 1, utils.INF})
+		}
+		return ranges, nil
+	default:
+		return nil, ErrInvalidValues
+	}
+}
+
+func (f *field) strToUUID(valStr string) (utils.UUID, error) {
+	v, err := f.StrToValue(valStr)
+	if err != nil {
+		return utils.NilUUID, err
+	}
+	return f.ValueToUUID(v), nil
 }
@@ -0,0 +1,202 @@
+package table_manage
+
+import (
+	im "fansDB/backend/index_manage"
+	statement "fansDB/backend/parser"
+	"fansDB/backend/utils"
+	"testing"
+)
+
+// TestNullBitmap 检查NULL只在null bitmap中记录, 不写入值, 且跨越多个字节的bitmap在重新打开后仍然正确
+func TestNullBitmap(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table n f0 int64, f1 int64, f2 int64, f3 int64, f4 int64, f5 int64, f6 int64, f7 int64, f8 int64, f9 int64 (index f9)",
+		"insert into n values (null, 1, 2, 3, 4, 5, 6, 7, null, 9)",
+	)
+
+	tb := db.tableManager.tableCacher["n"]
+	e, err := tb.strToEntry(nil, []*statement.Value{
+		{Null: true}, {Str: "1"}, {Str: "2"}, {Str: "3"}, {Str: "4"}, {Str: "5"}, {Str: "6"}, {Str: "7"}, {Null: true}, {Str: "9"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := tb.entryToRaw(e)
+	if len(raw) != 2+8*8 {
+		t.Errorf("got %d bytes, expected a 2 byte bitmap and 8 values", len(raw))
+	}
+	if raw[0] != 1<<0 || raw[1] != 1<<0 {
+		t.Errorf("got bitmap %08b %08b", raw[0], raw[1])
+	}
+
+	expected := "[NULL, 1, 2, 3, 4, 5, 6, 7, NULL, 9]\n"
+	for i := 0; i < 2; i++ {
+		if result := db.mustRun("read * from n where f8 is null"); result != expected {
+			t.Errorf("reopened=%v: got %s, expected %s", i > 0, result, expected)
+		}
+		db.reopen()
+	}
+}
+
+// TestNotNullDefault 检查不能为NULL的字段(包括主键)不能以NULL为默认值, 也不能写入NULL
+func TestNotNullDefault(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.run("create table t a int64 not null default null (index a)"); err != ErrNotNull {
+		t.Errorf("not null default null: got %v, expected %v", err, ErrNotNull)
+	}
+
+	xid := db.begin()
+	_, err := db.tableManager.Create(xid, &statement.Create{
+		TableName:    "t",
+		FieldName:    []string{"a"},
+		FieldType:    []string{"int64"},
+		FieldNotNull: []bool{false},
+		FieldDefault: []*statement.Value{{Null: true}},
+		FieldUnique:  []bool{false},
+		PrimaryKey:   "a",
+		Index:        []string{"a"},
+	})
+	db.tableManager.Abort(xid)
+	if err != ErrNotNull {
+		t.Errorf("primary key default null: got %v, expected %v", err, ErrNotNull)
+	}
+
+	db.mustRun("create table t a int64 not null, b int64 default 5, c int64 (index a)")
+	tests := []struct {
+		stat     string
+		expected error
+	}{
+		{"insert into t (c) values (1)", ErrNotNull},
+		{"insert into t values (null, 1, 1)", ErrNotNull},
+		{"insert into t (a) values (1)", nil},
+		{"update t set a = null where a = 1", ErrNotNull},
+	}
+	for _, test := range tests {
+		_, err := db.run(test.stat)
+		if err != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.stat, err, test.expected)
+		}
+	}
+	if result := db.mustRun("read * from t"); result != "[1, 5, NULL]\n" {
+		t.Errorf("got %s", result)
+	}
+}
+
+// createVersion1Table 以_TABLE_VERSION_ROWS的格式写入一张表v1 (a uint64 (index a), b string),
+// 其字段也是没有Flags的旧格式, 然后重新打开数据库.
+func createVersion1Table(t *testing.T, db *testDB) {
+	tbm := db.tableManager
+	xid := db.begin()
+	rows, err := im.Create(tbm.DataManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := im.Create(tbm.DataManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := utils.VarStrToRaw("v1")
+	raw = append(raw, utils.UUIDToRaw(utils.NilUUID)...)
+	raw = append(raw, utils.UUIDToRaw(utils.NilUUID)...)
+	raw = append(raw, utils.Uint16ToRaw(_TABLE_VERSION_ROWS)...)
+	raw = append(raw, utils.UUIDToRaw(rows)...)
+	for _, f := range []struct {
+		name, ftype string
+		index       utils.UUID
+	}{{"a", "uint64", index}, {"b", "string", utils.NilUUID}} {
+		fraw := utils.VarStrToRaw(f.name)
+		fraw = append(fraw, utils.VarStrToRaw(f.ftype)...)
+		fraw = append(fraw, utils.UUIDToRaw(f.index)...)
+		uuid, err := tbm.SerializabilityManager.Insert(xid, fraw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = append(raw, utils.UUIDToRaw(uuid)...)
+	}
+	self, err := tbm.SerializabilityManager.Insert(xid, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.commit(xid)
+	tbm.updateFirstTableUUID(self)
+	db.reopen()
+}
+
+// TestLoadVersion1Table 检查没有null bitmap的旧版本的表可以被读入和写入, 其字段不能为NULL, 未给出的值取零值
+func TestLoadVersion1Table(t *testing.T) {
+	db := openTestDB(t)
+	createVersion1Table(t, db)
+
+	tb := db.tableManager.tableCacher["v1"]
+	if tb == nil || tb.version != _TABLE_VERSION_ROWS || tb.hasNullBitmap() {
+		t.Fatalf("got table %+v", tb)
+	}
+	db.mustRun("insert into v1 values (1, 'x')", "insert into v1 (a) values (2)")
+
+	tests := []struct {
+		stat     string
+		expected error
+	}{
+		{"insert into v1 values (3, null)", ErrNotNull},
+		{"create index on v1 (a, b)", ErrOldTable},
+	}
+	for _, test := range tests {
+		_, err := db.run(test.stat)
+		if err != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.stat, err, test.expected)
+		}
+	}
+
+	expected := "[1, x]\n[2, ]\n"
+	for i := 0; i < 2; i++ {
+		if result := db.mustRun("read * from v1 order by a"); result != expected {
+			t.Errorf("reopened=%v: got %q, expected %q", i > 0, result, expected)
+		}
+		if result := db.mustRun("read b from v1 where a = 2"); result != "[]\n" {
+			t.Errorf("reopened=%v: got %q", i > 0, result)
+		}
+		db.reopen()
+	}
+}
+
+// TestTypedIndexOrder 检查各种类型的索引按值的顺序查找: 负数排在正数之前, -0和0相等,
+// 1970年之前的timestamp排在之后的之前.
+func TestTypedIndexOrder(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table ty i int32, f float64 unique, b bool, ts timestamp (index i b ts)",
+		"insert into ty values (-2147483648, -1.5, true, '1969-12-31 23:59:59'), (-1, -0, false, '1900-01-01'), "+
+			"(0, 1e300, true, '1970-01-01'), (2147483647, -1e300, false, '2038-01-19 03:14:08'), (7, 2.5, null, null)",
+	)
+
+	tests := []struct {
+		stat     string
+		expected string
+	}{
+		{"read i from ty order by i", "[-2147483648]\n[-1]\n[0]\n[7]\n[2147483647]\n"},
+		{"read i from ty where i < 0 order by i desc", "[-1]\n[-2147483648]\n"},
+		{"read i from ty where i between -1 and 7 order by i", "[-1]\n[0]\n[7]\n"},
+		{"read i from ty order by f", "[2147483647]\n[-2147483648]\n[-1]\n[7]\n[0]\n"},
+		{"read i from ty where f = 0", "[-1]\n"},
+		{"read i from ty where f > -1 and f < 1", "[-1]\n"},
+		{"read i from ty where f >= -1e300 and f < 0 order by f", "[2147483647]\n[-2147483648]\n"},
+		{"read i from ty where b = false order by i", "[-1]\n[2147483647]\n"},
+		{"read i from ty order by b, i", "[7]\n[-1]\n[2147483647]\n[-2147483648]\n[0]\n"},
+		{"read i from ty where ts < '1970-01-01' order by ts", "[-1]\n[-2147483648]\n"},
+		{"read i from ty order by ts desc limit 2", "[2147483647]\n[0]\n"},
+	}
+	for _, test := range tests {
+		result, err := db.run(test.stat)
+		if err != nil {
+			t.Errorf("%s: %v", test.stat, err)
+		} else if result != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.stat, result, test.expected)
+		}
+	}
+
+	if _, err := db.run("insert into ty values (1, 0, true, null)"); err != ErrDuplicatedKey {
+		t.Errorf("0 after -0: got %v, expected %v", err, ErrDuplicatedKey)
+	}
+}
@@ -0,0 +1,434 @@
+/*
+	index.go 实现了组合索引, 即建立在多个字段上的索引.
+
+	组合索引的二进制格式为:
+	[Index Name] [Tree UUID] [NoFields] uint16 [Field1 Name] ... [FieldN Name]
+
+	索引的key由各个字段的值依次拼接而成, 每个字段占9字节:
+	[Null Flag] 1字节, NULL为0, 否则为1
+	[Key]       8字节, 该字段的值在单字段索引中的key(field.ValueToUUID), 大端序
+	所以key按各字段的值的字典序排列, NULL排在最前面. 和单字段索引一样, string和bytes只取了前8个字节,
+	查找的结果仍需要用完整的值过滤.
+
+	此外, 本文件还实现了create index和drop index.
+	表的索引被修改时, 会为执行DDL的事务复制出表的一个新版本, 在提交之前, 只有该事务能看到这个版本,
+	提交时由tableManager用它替换原来的表, 见tableManager.CreateIndex.
+	写入entry时需要更新的索引记录在indexSet中, 由表的各个版本共享, 新建的索引在建立之前就会被加入,
+	所以建立索引时, 其他事务可以继续写入该表, 它们写入的entry不会被遗漏.
+*/
+package table_manage
+
+import (
+	"errors"
+	im "fansDB/backend/index_manage"
+	statement "fansDB/backend/parser"
+	tm "fansDB/backend/transaction_manage"
+	"fansDB/backend/utils"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrIndexExists   = errors.New("Index already exists.")
+	ErrIndexTooLarge = errors.New("Too many fields in index.")
+	ErrNoThatIndex   = errors.New("No that index.")
+	ErrOldTable      = errors.New("Table is too old to have composite indexes.")
+	ErrLastIndex     = errors.New("Cannot drop the last index of a table without rows.")
+	ErrUniqueIndex   = errors.New("Cannot drop the index of a unique field.")
+	ErrHashIndex     = errors.New("Hash index must be on a single field, and either unnamed or named after that field.")
+)
+
+const (
+	_INDEX_KEY_PART_SIZE = 1 + utils.LEN_UUID // 每个字段在key中所占的大小
+)
+
+type index struct {
+	SelfUUID utils.UUID
+	table    *table
+
+	Name   string
+	fields []*field
+	tree   utils.UUID
+	bt     im.BPlusTree
+}
+
+/*
+	LoadIndex 从DB中读入index的内容.
+	panic的原因和LoadTable类似.
+*/
+func LoadIndex(tb *table, uuid utils.UUID) *index {
+	raw, ok, err := tb.TableManager.SerializabilityManager.Read(tm.SUPER_TRANSACTION_ID, uuid)
+	utils.Assert(ok)
+	if err != nil {
+		panic(err)
+	}
+	ix := &index{
+		SelfUUID: uuid,
+		table:    tb,
+	}
+	ix.parseSelf(raw)
+	return ix
+}
+
+func (ix *index) parseSelf(raw []byte) {
+	var pos, shift int
+	ix.Name, shift = utils.ParseVarStr(raw[pos:])
+	pos += shift
+	ix.tree = utils.ParseUUID(raw[pos:])
+	pos += utils.LEN_UUID
+	noFields := int(utils.ParseUint16(raw[pos:]))
+	pos += 2
+	for i := 0; i < noFields; i++ {
+		var name string
+		name, shift = utils.ParseVarStr(raw[pos:])
+		pos += shift
+		fd := ix.table.fieldByName(name)
+		utils.Assert(fd != nil)
+		ix.fields = append(ix.fields, fd)
+	}
+
+	var err error
+	ix.bt, err = im.Load(ix.tree, ix.table.TableManager.DataManager)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// CreateIndex 在tb的fields上创建一个名为name的组合索引
+func CreateIndex(tb *table, xid tm.TransactionID, name string, fields []*field) (*index, error) {
+	if len(fields)*_INDEX_KEY_PART_SIZE > im.MAX_KEY_SIZE {
+		return nil, ErrIndexTooLarge
+	}
+	tree, err := im.Create(tb.TableManager.DataManager)
+	if err != nil {
+		return nil, err
+	}
+	bt, err := im.Load(tree, tb.TableManager.DataManager)
+	if err != nil {
+		return nil, err
+	}
+
+	ix := &index{
+		table:  tb,
+		Name:   name,
+		fields: fields,
+		tree:   tree,
+		bt:     bt,
+	}
+	err = ix.persistSelf(xid)
+	if err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+func (ix *index) persistSelf(xid tm.TransactionID) error {
+	raw := utils.VarStrToRaw(ix.Name)
+	raw = append(raw, utils.UUIDToRaw(ix.tree)...)
+	raw = append(raw, utils.Uint16ToRaw(uint16(len(ix.fields)))...)
+	for _, fd := range ix.fields {
+		raw = append(raw, utils.VarStrToRaw(fd.FName)...)
+	}
+	self, err := ix.table.TableManager.SerializabilityManager.Insert(xid, raw)
+	if err != nil {
+		return err
+	}
+	ix.SelfUUID = self
+	return nil
+}
+
+// Drop 在xid中删除该索引的记录, 和field.Drop一样, 索引占用的空间不会被释放.
+func (ix *index) Drop(xid tm.TransactionID) error {
+	_, err := ix.table.TableManager.SerializabilityManager.Delete(xid, ix.SelfUUID)
+	return err
+}
+
+func (ix *index) Print() string {
+	names := make([]string, len(ix.fields))
+	for i, fd := range ix.fields {
+		names[i] = fd.FName
+	}
+	return "Index " + ix.Name + "(" + strings.Join(names, ", ") + ")"
+}
+
+// insertEntry 将entry e加入到索引中, 其uuid为uuid
+func (ix *index) insertEntry(e entry, uuid utils.UUID) error {
+	return ix.bt.InsertKey(ix.entryKey(e), uuid)
+}
+
+// deleteEntry 将entry e从索引中删除, 其uuid为uuid
+func (ix *index) deleteEntry(e entry, uuid utils.UUID) error {
+	_, err := ix.bt.DeleteKey(ix.entryKey(e), uuid)
+	return err
+}
+
+// indexKey 返回entry e在该索引中的key, 组合索引包含所有的entry
+func (ix *index) indexKey(e entry) ([]byte, bool) {
+	return ix.entryKey(e), true
+}
+
+// bulkLoad 将entries批量加载到索引中
+func (ix *index) bulkLoad(entries []im.Entry) error {
+	return ix.bt.BulkLoad(entries, im.DEFAULT_FILL_FACTOR)
+}
+
+// entryKey 返回entry e在该索引中的key
+func (ix *index) entryKey(e entry) []byte {
+	var key []byte
+	for _, fd := range ix.fields {
+		v := e[fd.FName]
+		if v == nil {
+			key = append(key, make([]byte, _INDEX_KEY_PART_SIZE)...)
+		} else {
+			key = appendKeyPart(key, fd.ValueToUUID(v))
+		}
+	}
+	return key
+}
+
+// Iterate 返回按key的顺序遍历[left, right]的迭代器, right为nil时表示没有上界
+func (ix *index) Iterate(left, right []byte, desc bool) (im.Iterator, error) {
+	return ix.bt.IterateKey(left, right, desc)
+}
+
+// appendKeyPart 将一个非NULL的字段的key加入到prefix之后
+func appendKeyPart(prefix []byte, key utils.UUID) []byte {
+	result := make([]byte, len(prefix), len(prefix)+_INDEX_KEY_PART_SIZE)
+	copy(result, prefix)
+	result = append(result, 1)
+	for i := utils.LEN_UUID - 1; i >= 0; i-- {
+		result = append(result, byte(key>>(uint(i)*8)))
+	}
+	return result
+}
+
+// prefixEnd 返回比所有以prefix开头的key都大的最小的key, 不存在时返回nil.
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xFF {
+			end := make([]byte, i+1)
+			copy(end, prefix)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// indexWriter 为写入entry时需要更新的索引, 即有索引的字段或组合索引.
+type indexWriter interface {
+	insertEntry(e entry, uuid utils.UUID) error
+	deleteEntry(e entry, uuid utils.UUID) error
+	// indexKey 返回entry在索引中的key, entry不需要被加入索引时返回false
+	indexKey(e entry) ([]byte, bool)
+	// bulkLoad 将entries批量加载到索引中, 调用者需保证期间没有其他对该索引的读写
+	bulkLoad(entries []im.Entry) error
+}
+
+// indexSet 记录了写入entry时需要更新的所有索引, 由表的各个版本共享.
+// 写入entry时持有读锁, 为已有的entry建立索引时持有写锁, 见buildIndex.
+type indexSet struct {
+	lock    sync.RWMutex
+	writers []indexWriter
+}
+
+func (is *indexSet) add(w indexWriter) {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+	is.writers = append(is.writers, w)
+}
+
+func (is *indexSet) remove(w indexWriter) {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+	for i, tmp := range is.writers {
+		if tmp == w {
+			is.writers = append(is.writers[:i:i], is.writers[i+1:]...)
+			return
+		}
+	}
+}
+
+// reset 将需要更新的索引设置为t的索引, 在修改索引的事务结束时调用.
+func (is *indexSet) reset(t *table) {
+	is.lock.Lock()
+	defer is.lock.Unlock()
+	is.writers = t.writers()
+}
+
+// writers 返回t的所有索引
+func (t *table) writers() []indexWriter {
+	var writers []indexWriter
+	for _, f := range t.fields {
+		if f.IsIndexed() {
+			writers = append(writers, f)
+		}
+	}
+	for _, ix := range t.indexes {
+		writers = append(writers, ix)
+	}
+	return writers
+}
+
+// clone 复制出t的一个新版本, 其字段和索引的列表可以被单独修改.
+func (t *table) clone() *table {
+	nt := *t
+	nt.fields = append([]*field(nil), t.fields...)
+	nt.indexes = append([]*index(nil), t.indexes...)
+	nt.prev = t
+	return &nt
+}
+
+/*
+	buildIndex 为t中已有的所有entry建立索引w.
+	w在扫描t之前被加入indexSet, 而扫描和写入entry互斥, 所以每个entry要么在扫描时被找到,
+	要么在写入时被加入w, 既不会遗漏, 也不会重复.
+	扫描到的entry的key被收集起来, 最后批量加载到w中, 批量加载时持有indexSet的写锁,
+	此前其他事务写入w的entry会和它们合并在一起.
+	和字段的索引一样, 所有版本的entry都会被加入索引, 查找时再判断其可见性.
+*/
+func (t *table) buildIndex(w indexWriter) error {
+	t.indexSet.lock.Lock()
+	t.indexSet.writers = append(t.indexSet.writers, w)
+	uuids, err := t.scanAll()
+	t.indexSet.lock.Unlock()
+
+	var entries []im.Entry
+	for i := 0; err == nil && i < len(uuids); i++ {
+		var raw []byte
+		var ok bool
+		raw, ok, err = t.TableManager.SerializabilityManager.ReadVersion(uuids[i])
+		if err != nil || ok == false {
+			continue
+		}
+		if key, ok := w.indexKey(t.parseEntry(raw)); ok {
+			entries = append(entries, im.Entry{Key: key, UUID: uuids[i]})
+		}
+	}
+	if err == nil {
+		t.indexSet.lock.Lock()
+		err = w.bulkLoad(entries)
+		t.indexSet.lock.Unlock()
+	}
+	if err != nil {
+		t.indexSet.remove(w)
+		return err
+	}
+	return nil
+}
+
+// CreateIndex 在xid中按create建立索引, 返回建立了索引之后的新版本.
+// 只有一个字段, 且索引名与字段名相同时, 建立该字段的索引, 否则建立组合索引.
+// 只有字段的索引可以是hash索引, 组合索引总是B+树. 字段的索引没有自己的名字, 所以
+// create index ix on t (a) using hash会返回ErrHashIndex, 应写作create index on t (a) using hash.
+func (t *table) CreateIndex(xid tm.TransactionID, create *statement.CreateIndex) (*table, error) {
+	var fields []*field
+	for _, name := range create.Fields {
+		fd := t.fieldByName(name)
+		if fd == nil {
+			return nil, ErrNoThatField
+		}
+		for _, f := range fields {
+			if f == fd {
+				return nil, ErrInvalidValues
+			}
+		}
+		fields = append(fields, fd)
+	}
+
+	nt := t.clone()
+	if len(fields) == 1 && fields[0].FName == create.IndexName {
+		fd := fields[0]
+		if fd.IsIndexed() {
+			return nil, ErrIndexExists
+		}
+		nf, err := fd.withIndex(xid, nt, true, create.Hash)
+		if err != nil {
+			return nil, err
+		}
+		err = nt.buildIndex(nf)
+		if err != nil {
+			return nil, err
+		}
+		err = nt.replaceField(xid, fd, nf)
+		if err != nil {
+			return nil, err
+		}
+		return nt, nil
+	}
+
+	if create.Hash {
+		return nil, ErrHashIndex
+	}
+	if t.version < _TABLE_VERSION_NULLS { // 更早的版本的entry格式不同, 无法升级
+		return nil, ErrOldTable
+	}
+	if t.indexByName(create.IndexName) != nil || t.fieldByName(create.IndexName) != nil {
+		return nil, ErrIndexExists
+	}
+	ix, err := CreateIndex(nt, xid, create.IndexName, fields)
+	if err != nil {
+		return nil, err
+	}
+	err = nt.buildIndex(ix)
+	if err != nil {
+		return nil, err
+	}
+	nt.version = _TABLE_VERSION_INDEXES
+	nt.indexes = append(nt.indexes, ix)
+	return nt, nil
+}
+
+// DropIndex 在xid中删除名为name的组合索引或字段的索引, 返回删除了索引之后的新版本.
+// 在xid提交之前, 其他事务仍然会使用和更新该索引.
+func (t *table) DropIndex(xid tm.TransactionID, name string) (*table, error) {
+	nt := t.clone()
+	if ix := t.indexByName(name); ix != nil {
+		err := ix.Drop(xid)
+		if err != nil {
+			return nil, err
+		}
+		for i, tmp := range nt.indexes {
+			if tmp == ix {
+				nt.indexes = append(nt.indexes[:i:i], nt.indexes[i+1:]...)
+				break
+			}
+		}
+		return nt, nil
+	}
+
+	fd := t.fieldByName(name)
+	if fd == nil || fd.IsIndexed() == false {
+		return nil, ErrNoThatIndex
+	}
+	if fd.unique { // 唯一约束需要通过索引检查
+		return nil, ErrUniqueIndex
+	}
+	if t.rowTree == nil && len(t.writers()) == 1 { // 没有rows的表只能通过索引扫描
+		return nil, ErrLastIndex
+	}
+	nf, err := fd.withIndex(xid, nt, false, false)
+	if err != nil {
+		return nil, err
+	}
+	err = nt.replaceField(xid, fd, nf)
+	if err != nil {
+		return nil, err
+	}
+	return nt, nil
+}
+
+// replaceField 用nf替换t中的fd, 并在xid中删除fd的记录.
+// 字段的记录被它所在的表引用, 所以只有在新的表记录被写入的事务中才能删除它.
+func (t *table) replaceField(xid tm.TransactionID, fd, nf *field) error {
+	_, err := t.TableManager.SerializabilityManager.Delete(xid, fd.SelfUUID)
+	if err != nil {
+		return err
+	}
+	for i, f := range t.fields {
+		if f == fd {
+			t.fields[i] = nf
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package table_manage
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCreateHashIndex 检查只有字段的索引可以是hash索引, 且索引名必须为空或与字段名相同
+func TestCreateHashIndex(t *testing.T) {
+	db := openTestDB(t)
+	xid := db.begin()
+	db.mustExec(xid, "create table t a int64, b int64 (index b)")
+
+	tests := []struct {
+		stat     string
+		expected error
+	}{
+		{"create index ix on t (a) using hash", ErrHashIndex},
+		{"create index on t (a, b) using hash", ErrHashIndex},
+		{"create index on t (a) using hash", nil},
+		{"create index a on t (a) using hash", ErrIndexExists},
+	}
+	for _, test := range tests {
+		_, err := db.exec(xid, test.stat)
+		if err != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.stat, err, test.expected)
+		}
+	}
+
+	if show := db.mustExec(xid, "show"); strings.Contains(show, "(a, int64, HashIndex") == false {
+		t.Errorf("a should have a hash index: %s", show)
+	}
+	db.mustExec(xid, "drop index a on t")
+	db.commit(xid)
+}
@@ -0,0 +1,379 @@
+/*
+	join.go 实现了多个表之间的join.
+
+	join按从左到右的顺序进行, 每一步把已经join的结果作为外表, 新join进来的表作为内表.
+	如果内表的join字段有索引, 则对外表的每一行, 通过索引查找内表中对应的entry(index nested loop);
+	否则先扫描内表, 以join字段的值建立hash表, 再用外表的每一行去查找(hash join).
+	left join时, 如果内表中没有对应的entry, 则该行内表的字段都为nil, 打印为NULL.
+	第一个表的每一行依次与各个内表join, 结果不会整体缓存在内存中; order by时结果交给entrySorter进行外部排序,
+	为此join的结果被看作一张字段名为"表名.字段名"的表的entry, 见joinSchema.
+
+	join结果中的每一行以"表名.字段名"为key. 读取的字段, where和order by中,
+	字段名可以带有表名; 不带表名时, 只能有一个表含有该字段.
+	where中只涉及第一个表的and项, 在扫描第一个表时就会进行过滤, 以便利用其索引.
+*/
+package table_manage
+
+import (
+	"errors"
+	statement "fansDB/backend/parser"
+	tm "fansDB/backend/transaction_manage"
+	"fansDB/backend/utils"
+	"strings"
+)
+
+var (
+	ErrAmbiguousField    = errors.New("Field is ambiguous.")
+	ErrInvalidJoin       = errors.New("Invalid join condition.")
+	ErrUnsupportedOnJoin = errors.New("Aggregate on join is not supported.")
+)
+
+// joinColumn 为join结果中的一列
+type joinColumn struct {
+	tb   *table
+	fd   *field
+	name string // 表名.字段名
+}
+
+// joinRow 为join结果中的一行, 以joinColumn.name为key
+type joinRow map[string]interface{}
+
+// readJoin 对tables执行带有join的read语句, tables[0]为from的表, 其余的依次为join的表.
+// 第一个表的每一行依次与其余的表join, 满足where的结果直接输出, 或者交给entrySorter排序, 不会整体缓存在内存中.
+func readJoin(xid tm.TransactionID, read *statement.Read, tables []*table) (string, error) {
+	if isAggregateRead(read) {
+		return "", ErrUnsupportedOnJoin
+	}
+	for i := range tables {
+		for j := 0; j < i; j++ {
+			if tables[i].Name == tables[j].Name {
+				return "", ErrInvalidJoin
+			}
+		}
+	}
+
+	var outputs []*joinColumn
+	for _, name := range read.Fields {
+		if name == "*" {
+			for _, tb := range tables {
+				for _, fd := range tb.fields {
+					outputs = append(outputs, newJoinColumn(tb, fd))
+				}
+			}
+			continue
+		}
+		c, err := resolveJoinColumn(tables, name)
+		if err != nil {
+			return "", err
+		}
+		outputs = append(outputs, c)
+	}
+	err := walkWhere(read.Where, func(exp *statement.SingleExp) error {
+		_, err := resolveJoinColumn(tables, exp.Field)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	schema := joinSchema(tables)
+	var keys []orderKey
+	for _, ob := range read.OrderBy {
+		c, err := resolveJoinColumn(tables, ob.Field)
+		if err != nil {
+			return "", err
+		}
+		keys = append(keys, orderKey{fd: schema.fieldByName(c.name), desc: ob.Desc})
+	}
+
+	var joiners []*joiner
+	for i, join := range read.Joins {
+		j, err := newJoiner(xid, tables[:i+1], tables[i+1], join)
+		if err != nil {
+			return "", err
+		}
+		joiners = append(joiners, j)
+	}
+
+	var result strings.Builder
+	limit, offset := read.Limit, read.Offset
+	emit := func(row joinRow) (bool, error) {
+		if limit == 0 {
+			return false, nil
+		}
+		if offset > 0 {
+			offset--
+			return true, nil
+		}
+		joinRowPrint(&result, row, outputs)
+		result.WriteByte('\n')
+		if limit > 0 {
+			limit--
+		}
+		return limit != 0, nil
+	}
+	if limit == 0 {
+		return "", nil
+	}
+
+	handle := emit
+	var sorter *entrySorter
+	if len(keys) > 0 {
+		sorter = newEntrySorter(schema, keys)
+		defer sorter.Close()
+		handle = func(row joinRow) (bool, error) {
+			return true, sorter.Add(entry(row))
+		}
+	}
+
+	// 第i个joiner之前的表已经join到row中
+	var process func(row joinRow, i int) (bool, error)
+	process = func(row joinRow, i int) (bool, error) {
+		if i < len(joiners) {
+			return joiners[i].join(row, func(row joinRow) (bool, error) {
+				return process(row, i+1)
+			})
+		}
+		match, err := evalWhere(read.Where, func(exp *statement.SingleExp) (logic, error) {
+			c, _ := resolveJoinColumn(tables, exp.Field)
+			return matchValue(c.fd, row[c.name], exp)
+		})
+		if err != nil || match == false {
+			return err == nil, err
+		}
+		return handle(row)
+	}
+
+	base := tables[0]
+	err = base.filterEntries(xid, pushDownWhere(tables, read.Where), nil, func(_ utils.UUID, e entry) (bool, error) {
+		return process(newJoinRow(nil, base, e), 0)
+	})
+	if err != nil {
+		return "", err
+	}
+	if sorter != nil {
+		err = sorter.Sort(func(e entry) (bool, error) {
+			return emit(joinRow(e))
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return result.String(), nil
+}
+
+// joinSchema 返回join结果的格式: 一张只在内存中的表, 其字段依次为tables的字段, 名字为"表名.字段名".
+// joinRow可以作为它的entry, 从而借助entrySorter对join的结果进行外部排序.
+func joinSchema(tables []*table) *table {
+	schema := &table{version: _TABLE_VERSION_INDEXES}
+	for _, tb := range tables {
+		for _, fd := range tb.fields {
+			f := *fd
+			f.table = schema
+			f.FName = tb.Name + "." + fd.FName
+			schema.fields = append(schema.fields, &f)
+		}
+	}
+	return schema
+}
+
+// joiner 将外表的每一行与内表inner进行join
+type joiner struct {
+	inner    *table
+	left     *joinColumn // 外表的join字段
+	leftJoin bool
+	matches  func(v interface{}) ([]entry, error) // 返回内表中join字段等于v的entry
+}
+
+// newJoiner 准备将已经join了joined的行与inner进行join, 内表的join字段没有索引时, 在此时建立hash表.
+func newJoiner(xid tm.TransactionID, joined []*table, inner *table, join *statement.Join) (*joiner, error) {
+	left, err := resolveJoinColumn(append(joined, inner), join.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := resolveJoinColumn(append(joined, inner), join.Right)
+	if err != nil {
+		return nil, err
+	}
+	// 一边为内表的字段, 另一边为外表的字段
+	if left.tb == inner {
+		left, right = right, left
+	}
+	if left.tb == inner || right.tb != inner || left.fd.FType != right.fd.FType {
+		return nil, ErrInvalidJoin
+	}
+
+	j := &joiner{inner: inner, left: left, leftJoin: join.Type == "left"}
+	if right.fd.IsIndexed() {
+		j.matches = func(v interface{}) ([]entry, error) {
+			key := right.fd.ValueToUUID(v)
+			uuids, err := right.fd.Search(key, key)
+			if err != nil {
+				return nil, err
+			}
+			var entries []entry
+			for _, uuid := range uuids {
+				e, ok, err := inner.readEntry(xid, uuid, nil)
+				if err != nil {
+					return nil, err
+				}
+				if ok && right.fd.Compare(e[right.fd.FName], v) == 0 {
+					entries = append(entries, e)
+				}
+			}
+			return entries, nil
+		}
+		return j, nil
+	}
+
+	hash := make(map[string][]entry)
+	err = inner.filterEntries(xid, nil, nil, func(_ utils.UUID, e entry) (bool, error) {
+		if e[right.fd.FName] == nil { // NULL不和任何值相等
+			return true, nil
+		}
+		key := string(right.fd.ValueToRaw(e[right.fd.FName]))
+		hash[key] = append(hash[key], e)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	j.matches = func(v interface{}) ([]entry, error) {
+		return hash[string(right.fd.ValueToRaw(v))], nil
+	}
+	return j, nil
+}
+
+// join 将row与内表中对应的每个entry合并后交给handle, handle返回false时停止并返回false.
+func (j *joiner) join(row joinRow, handle func(row joinRow) (bool, error)) (bool, error) {
+	var entries []entry
+	if v := row[j.left.name]; v != nil {
+		var err error
+		entries, err = j.matches(v)
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, e := range entries {
+		goOn, err := handle(newJoinRow(row, j.inner, e))
+		if err != nil || goOn == false {
+			return false, err
+		}
+	}
+	if len(entries) == 0 && j.leftJoin {
+		return handle(newJoinRow(row, j.inner, nil))
+	}
+	return true, nil
+}
+
+// resolveJoinColumn 在tables中找到名为name的字段, name可以为"表名.字段名"或者"字段名".
+func resolveJoinColumn(tables []*table, name string) (*joinColumn, error) {
+	tableName, fieldName := "", name
+	if pos := strings.LastIndexByte(name, '.'); pos >= 0 {
+		tableName, fieldName = name[:pos], name[pos+1:]
+	}
+
+	var result *joinColumn
+	for _, tb := range tables {
+		if tableName != "" && tb.Name != tableName {
+			continue
+		}
+		fd := tb.fieldByName(fieldName)
+		if fd == nil {
+			continue
+		}
+		if result != nil {
+			return nil, ErrAmbiguousField
+		}
+		result = newJoinColumn(tb, fd)
+	}
+	if result == nil {
+		return nil, ErrNoThatField
+	}
+	return result, nil
+}
+
+func newJoinColumn(tb *table, fd *field) *joinColumn {
+	return &joinColumn{
+		tb:   tb,
+		fd:   fd,
+		name: tb.Name + "." + fd.FName,
+	}
+}
+
+// newJoinRow 在row的基础上加入tb的entry e, e为nil时tb的字段都为nil.
+func newJoinRow(row joinRow, tb *table, e entry) joinRow {
+	result := make(joinRow, len(row)+len(tb.fields))
+	for k, v := range row {
+		result[k] = v
+	}
+	for _, fd := range tb.fields {
+		if e == nil {
+			result[tb.Name+"."+fd.FName] = nil
+		} else {
+			result[tb.Name+"."+fd.FName] = e[fd.FName]
+		}
+	}
+	return result
+}
+
+// pushDownWhere 返回where中只涉及tables[0]的and项, 其中的字段名都被改写为不带表名的形式.
+func pushDownWhere(tables []*table, where *statement.Where) *statement.Where {
+	if where == nil {
+		return nil
+	}
+	if where.LogicOp == "and" {
+		left := pushDownWhere(tables, where.Left)
+		right := pushDownWhere(tables, where.Right)
+		if left == nil {
+			return right
+		} else if right == nil {
+			return left
+		}
+		return &statement.Where{LogicOp: "and", Left: left, Right: right}
+	}
+
+	base := true
+	walkWhere(where, func(exp *statement.SingleExp) error {
+		c, err := resolveJoinColumn(tables, exp.Field)
+		if err != nil || c.tb != tables[0] {
+			base = false
+		}
+		return nil
+	})
+	if base == false {
+		return nil
+	}
+	return rewriteWhere(where, func(exp *statement.SingleExp) *statement.SingleExp {
+		c, _ := resolveJoinColumn(tables, exp.Field)
+		tmp := *exp
+		tmp.Field = c.fd.FName
+		return &tmp
+	})
+}
+
+// rewriteWhere 复制where, 其中的简单表达式由rewrite进行改写
+func rewriteWhere(where *statement.Where, rewrite func(exp *statement.SingleExp) *statement.SingleExp) *statement.Where {
+	if where == nil {
+		return nil
+	}
+	if where.LogicOp == "" {
+		return &statement.Where{SingleExp: rewrite(where.SingleExp)}
+	}
+	return &statement.Where{
+		LogicOp: where.LogicOp,
+		Left:    rewriteWhere(where.Left, rewrite),
+		Right:   rewriteWhere(where.Right, rewrite),
+	}
+}
+
+// joinRowPrint 按columns的顺序将row中对应的值打印到result中
+func joinRowPrint(result *strings.Builder, row joinRow, columns []*joinColumn) {
+	result.WriteByte('[')
+	for i, c := range columns {
+		if i > 0 {
+			result.WriteString(", ")
+		}
+		result.WriteString(c.fd.ValuePrint(row[c.name]))
+	}
+	result.WriteByte(']')
+}
@@ -0,0 +1,150 @@
+package table_manage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestJoin 检查inner join和left join的结果, NULL的join字段不和任何值相等.
+// 内表的join字段有索引(index nested loop)和没有索引(hash join)时, 结果相同.
+func TestJoin(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table emp id int64, name string, dept int64 (index id)",
+		"insert into emp values (1, 'e1', 10), (2, 'e2', 20), (3, 'e3', null), (4, 'e4', 99), (5, 'e5', 10)",
+		"create table dix id int64, title string (index id)",
+		"insert into dix values (10, 'dev'), (20, 'ops'), (null, 'none')",
+		"create table dhash id int64, title string (index title)",
+		"insert into dhash values (10, 'dev'), (20, 'ops'), (null, 'none')",
+	)
+
+	for _, d := range []string{"dix", "dhash"} {
+		tests := []struct {
+			stat     string
+			expected string
+		}{
+			{
+				"read emp.name, D.title from emp join D on emp.dept = D.id order by emp.id",
+				"[e1, dev]\n[e2, ops]\n[e5, dev]\n",
+			},
+			{
+				"read name, title from emp left join D on D.id = emp.dept order by emp.id",
+				"[e1, dev]\n[e2, ops]\n[e3, NULL]\n[e4, NULL]\n[e5, dev]\n",
+			},
+			{
+				"read title, name from D left join emp on D.id = emp.dept order by title, name",
+				"[dev, e1]\n[dev, e5]\n[none, NULL]\n[ops, e2]\n",
+			},
+			{
+				"read name from emp join D on emp.dept = D.id where title = 'dev' and emp.id > 1",
+				"[e5]\n",
+			},
+			{
+				"read name from emp left join D on emp.dept = D.id where title is null order by name desc",
+				"[e4]\n[e3]\n",
+			},
+			{
+				"read name from emp left join D on emp.dept = D.id order by name desc limit 2 offset 1",
+				"[e4]\n[e3]\n",
+			},
+			{
+				"read D.id, emp.id from emp join D on emp.dept = D.id order by D.id desc, emp.id desc limit 2",
+				"[20, 2]\n[10, 5]\n",
+			},
+		}
+		for _, test := range tests {
+			stat := strings.Replace(test.stat, "D", d, -1)
+			result, err := db.run(stat)
+			if err != nil {
+				t.Errorf("%s: %v", stat, err)
+			} else if result != test.expected {
+				t.Errorf("%s: got\n%s\nexpected\n%s", stat, result, test.expected)
+			}
+		}
+
+		stat := "read name from emp join " + d + " on emp.dept = " + d + ".id limit 1"
+		if result := db.mustRun(stat); strings.Count(result, "\n") != 1 {
+			t.Errorf("%s: got %s", stat, result)
+		}
+	}
+}
+
+// TestJoinErrors 检查不带表名的字段同时属于多个表时返回ErrAmbiguousField, 以及不合法的join条件
+func TestJoinErrors(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table emp id int64, name string, dept int64 (index id)",
+		"create table dept id int64, title string (index id)",
+	)
+
+	tests := []struct {
+		stat     string
+		expected error
+	}{
+		{"read id from emp join dept on emp.dept = dept.id", ErrAmbiguousField},
+		{"read name from emp join dept on emp.dept = dept.id where id = 1", ErrAmbiguousField},
+		{"read name from emp join dept on emp.dept = dept.id order by id", ErrAmbiguousField},
+		{"read name from emp join dept on dept = id", ErrAmbiguousField},
+		{"read name from emp join dept on emp.name = dept.id", ErrInvalidJoin},
+		{"read name from emp join dept on emp.id = emp.dept", ErrInvalidJoin},
+		{"read name from emp join emp on emp.id = emp.dept", ErrInvalidJoin},
+		{"read name from emp join dept on emp.dept = dept.nope", ErrNoThatField},
+		{"read count(*) from emp join dept on emp.dept = dept.id", ErrUnsupportedOnJoin},
+	}
+	for _, test := range tests {
+		_, err := db.run(test.stat)
+		if err != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.stat, err, test.expected)
+		}
+	}
+}
+
+// TestJoinOrderBySpill 检查join的结果超过_SORT_MEMORY时, 通过entrySorter的临时文件排序, 结果仍然有序
+func TestJoinOrderBySpill(t *testing.T) {
+	if testing.Short() {
+		t.Skip("large join")
+	}
+	const rows = 3000
+	pad := strings.Repeat("x", 2000) // 每行约2KB, 共约6MB, 超过_SORT_MEMORY
+	if rows*len(pad) <= _SORT_MEMORY {
+		t.Fatal("join result does not spill")
+	}
+
+	db := openTestDB(t)
+	db.mustRun(
+		"create table a id int64, s string (index id)",
+		"create table b id int64, v int64 (index v)",
+	)
+	keys := make([]string, rows)
+	for i := 0; i < rows; i += 100 {
+		var as, bs []string
+		for j := i; j < i+100; j++ {
+			keys[j] = fmt.Sprintf("%s%05d", pad, (j*7919)%rows)
+			as = append(as, fmt.Sprintf("(%d, '%s')", j, keys[j]))
+			bs = append(bs, fmt.Sprintf("(%d, %d)", j, j))
+		}
+		db.mustRun(
+			"insert into a values "+strings.Join(as, ", "),
+			"insert into b values "+strings.Join(bs, ", "),
+		)
+	}
+
+	ids := make([]int, rows)
+	for i := range ids {
+		ids[i] = i
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return keys[ids[i]] > keys[ids[j]]
+	})
+	var expected strings.Builder
+	for _, id := range ids {
+		fmt.Fprintf(&expected, "[%d, %d]\n", id, id)
+	}
+
+	result := db.mustRun("read a.id, v from a join b on a.id = b.id order by s desc")
+	if result != expected.String() {
+		t.Errorf("got %d bytes, expected %d bytes", len(result), expected.Len())
+	}
+}
@@ -0,0 +1,241 @@
+/*
+	sort.go 实现了order by所需的外部排序.
+
+	entry先缓存在内存中, 当缓存的大小超过_SORT_MEMORY时, 将其排序后写入一个临时文件, 称为一个run.
+	所有entry都加入后, 如果没有产生run, 则直接在内存中排序;
+	否则将剩余的entry也写成一个run, 再对所有的run进行多路归并.
+	run中每个entry的格式为:
+	[Length] uint32
+	[Raw]    table.entryToRaw的结果
+	排序是稳定的, 比较相同的entry保持加入时的顺序.
+*/
+package table_manage
+
+import (
+	"bufio"
+	"container/heap"
+	"fansDB/backend/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+const (
+	_SORT_MEMORY = 4 << 20 // 内存中最多缓存的entry大小
+)
+
+// orderKey 为排序所依据的一个字段
+type orderKey struct {
+	fd   *field
+	desc bool
+}
+
+type entrySorter struct {
+	t    *table
+	keys []orderKey
+
+	buf  []entry
+	size int // buf中entry的大小之和
+
+	runs []*os.File
+}
+
+func newEntrySorter(t *table, keys []orderKey) *entrySorter {
+	return &entrySorter{
+		t:    t,
+		keys: keys,
+	}
+}
+
+// less 按照keys比较a和b
+func (s *entrySorter) less(a, b entry) bool {
+	for _, k := range s.keys {
+		cmp := k.fd.Compare(a[k.fd.FName], b[k.fd.FName])
+		if cmp == 0 {
+			continue
+		}
+		if k.desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	return false
+}
+
+// Add 加入一个entry, 缓存满时会将其写入临时文件
+func (s *entrySorter) Add(e entry) error {
+	s.buf = append(s.buf, e)
+	s.size += len(s.t.entryToRaw(e))
+	if s.size >= _SORT_MEMORY {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill 将buf排序后写入一个新的run
+func (s *entrySorter) spill() error {
+	sort.SliceStable(s.buf, func(i, j int) bool {
+		return s.less(s.buf[i], s.buf[j])
+	})
+
+	file, err := ioutil.TempFile("", "fansdb-sort-")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, file)
+
+	writer := bufio.NewWriter(file)
+	for _, e := range s.buf {
+		raw := s.t.entryToRaw(e)
+		_, err = writer.Write(utils.Uint32ToRaw(uint32(len(raw))))
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(raw)
+		if err != nil {
+			return err
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	s.buf = nil
+	s.size = 0
+	return nil
+}
+
+// Sort 结束加入, 按顺序将所有entry交给handle, handle返回false时停止.
+func (s *entrySorter) Sort(handle func(e entry) (bool, error)) error {
+	if len(s.runs) == 0 {
+		sort.SliceStable(s.buf, func(i, j int) bool {
+			return s.less(s.buf[i], s.buf[j])
+		})
+		for _, e := range s.buf {
+			goOn, err := handle(e)
+			if err != nil || goOn == false {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(s.buf) > 0 {
+		err := s.spill()
+		if err != nil {
+			return err
+		}
+	}
+	return s.merge(handle)
+}
+
+// merge 对所有的run进行多路归并
+func (s *entrySorter) merge(handle func(e entry) (bool, error)) error {
+	h := &runHeap{sorter: s}
+	for i, file := range s.runs {
+		r := &runReader{no: i, reader: bufio.NewReader(file)}
+		ok, err := r.next(s.t)
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.readers = append(h.readers, r)
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		r := h.readers[0]
+		goOn, err := handle(r.head)
+		if err != nil || goOn == false {
+			return err
+		}
+
+		ok, err := r.next(s.t)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// Close 删除所有的临时文件
+func (s *entrySorter) Close() {
+	for _, file := range s.runs {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	s.runs = nil
+	s.buf = nil
+}
+
+// runReader 顺序读取一个run
+type runReader struct {
+	no     int // run的序号, 用于保证归并的稳定
+	reader *bufio.Reader
+	head   entry
+}
+
+// next 读取下一个entry到head中, 如果run已经读完, 则返回false
+func (r *runReader) next(t *table) (bool, error) {
+	lenRaw := make([]byte, 4)
+	_, err := io.ReadFull(r.reader, lenRaw)
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	raw := make([]byte, utils.ParseUint32(lenRaw))
+	_, err = io.ReadFull(r.reader, raw)
+	if err != nil {
+		return false, err
+	}
+	r.head = t.parseEntry(raw)
+	return true, nil
+}
+
+// runHeap 为各个run的head组成的小根堆
+type runHeap struct {
+	sorter  *entrySorter
+	readers []*runReader
+}
+
+func (h *runHeap) Len() int {
+	return len(h.readers)
+}
+
+func (h *runHeap) Less(i, j int) bool {
+	a, b := h.readers[i], h.readers[j]
+	if h.sorter.less(a.head, b.head) {
+		return true
+	} else if h.sorter.less(b.head, a.head) {
+		return false
+	}
+	return a.no < b.no
+}
+
+func (h *runHeap) Swap(i, j int) {
+	h.readers[i], h.readers[j] = h.readers[j], h.readers[i]
+}
+
+func (h *runHeap) Push(x interface{}) {
+	h.readers = append(h.readers, x.(*runReader))
+}
+
+func (h *runHeap) Pop() interface{} {
+	last := h.readers[len(h.readers)-1]
+	h.readers = h.readers[:len(h.readers)-1]
+	return last
+}
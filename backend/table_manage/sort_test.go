@@ -0,0 +1,115 @@
+package table_manage
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestEntrySorterSpill 检查超过_SORT_MEMORY的entry被写成多个run后归并, 结果有序且稳定,
+// handle返回false时停止, Close删除所有的临时文件.
+func TestEntrySorterSpill(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun("create table o k int64, g int64, s string (index k)")
+	tb := db.tableManager.tableCacher["o"]
+
+	const n = 12000
+	pad := strings.Repeat("x", 1000) // 共约12MB, 产生3个run
+	add := func(s *entrySorter) {
+		for i := 0; i < n; i++ {
+			err := s.Add(entry{"k": int64(i), "g": int64(i % 7), "s": pad})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	sorter := newEntrySorter(tb, []orderKey{{tb.fieldByName("g"), true}})
+	add(sorter)
+	if len(sorter.runs) < 2 {
+		t.Fatalf("got %d runs, expected at least 2", len(sorter.runs))
+	}
+	var names []string
+	for _, file := range sorter.runs {
+		names = append(names, file.Name())
+	}
+
+	var prev entry
+	count := 0
+	err := sorter.Sort(func(e entry) (bool, error) {
+		if prev != nil {
+			g0, g1 := prev["g"].(int64), e["g"].(int64)
+			if g0 < g1 || (g0 == g1 && prev["k"].(int64) >= e["k"].(int64)) {
+				t.Fatalf("entry %v after %v", e, prev)
+			}
+		}
+		if e["s"] != pad {
+			t.Fatalf("entry %v was not read back", e["k"])
+		}
+		prev = e
+		count++
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Errorf("got %d entries, expected %d", count, n)
+	}
+	sorter.Close()
+	for _, name := range names {
+		if _, err := os.Stat(name); os.IsNotExist(err) == false {
+			t.Errorf("run %s was not removed: %v", name, err)
+		}
+	}
+
+	sorter = newEntrySorter(tb, []orderKey{{tb.fieldByName("k"), true}})
+	defer sorter.Close()
+	add(sorter)
+	var got []int64
+	err = sorter.Sort(func(e entry) (bool, error) {
+		got = append(got, e["k"].(int64))
+		return len(got) < 3, nil
+	})
+	if err != nil || len(got) != 3 || got[0] != n-1 || got[2] != n-3 {
+		t.Errorf("got %v, %v, expected the 3 largest k", got, err)
+	}
+}
+
+// TestOrderByLimitOffset 检查多个字段的order by, NULL排在最前, 以及limit和offset.
+// 通过索引取得顺序和通过entrySorter排序的结果相同.
+func TestOrderByLimitOffset(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table o k int64, v int64, name string (index k)",
+		"insert into o values (1, 3, 'c'), (2, 1, 'a'), (3, null, 'n'), (4, 3, 'a'), (5, 2, 'b')",
+	)
+
+	tests := []struct {
+		stat     string
+		expected string
+	}{
+		{"read k from o order by v, name", "[3]\n[2]\n[5]\n[4]\n[1]\n"},
+		{"read k from o order by v desc, name desc", "[1]\n[4]\n[5]\n[2]\n[3]\n"},
+		{"read k from o order by v desc, k limit 2", "[1]\n[4]\n"},
+		{"read k from o order by v limit 2 offset 1", "[2]\n[5]\n"},
+		{"read k from o order by v, k offset 4", "[4]\n"},
+		{"read k from o order by v offset 9", ""},
+		{"read k from o order by v limit 0", ""},
+		{"read k from o where v >= 2 order by name, k desc limit 2", "[4]\n[5]\n"},
+		{"read k from o order by k desc limit 2 offset 1", "[4]\n[3]\n"},
+		{"read k from o where k > 1 order by k limit 3 offset 2", "[4]\n[5]\n"},
+	}
+	for _, test := range tests {
+		result, err := db.run(test.stat)
+		if err != nil {
+			t.Errorf("%s: %v", test.stat, err)
+		} else if result != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.stat, result, test.expected)
+		}
+	}
+
+	if _, err := db.run("read k from o order by nope"); err != ErrNoThatField {
+		t.Errorf("unknown field: got %v, expected %v", err, ErrNoThatField)
+	}
+}
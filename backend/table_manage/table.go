@@ -2,6 +2,7 @@ package table_manage
 
 import (
 	"errors"
+	im "fansDB/backend/index_manage"
 	statement "fansDB/backend/parser"
 	tm "fansDB/backend/transaction_manage"
 	"fansDB/backend/utils"
@@ -9,22 +10,55 @@ import (
 
 var (
 	ErrInvalidValues   = errors.New("Invalid values.")
-	ErrInvalidLogOP    = errors.New("Invalid logic operation.")
 	ErrNoThatField     = errors.New("No that field.")
 	ErrFieldHasNoField = errors.New("Field has no index.")
+	ErrOverflow        = errors.New("Value overflow.")
+	ErrTypeMismatch    = errors.New("Type mismatch.")
+	ErrDivideByZero    = errors.New("Divide by zero.")
+	ErrDuplicatedKey   = errors.New("Duplicated key.")
 )
 
 // map[Field]Value
 type entry map[string]interface{}
 
+const (
+	_TABLE_VERSION_ROWS    = 1 // 带有rows的表
+	_TABLE_VERSION_NULLS   = 2 // entry带有null bitmap的表
+	_TABLE_VERSION_INDEXES = 3 // 带有组合索引的表
+)
+
+/*
+	table的二进制格式有两种:
+	[Table Name] [Next] [Field1 UUID] [Field2 UUID] ... [FieldN UUID]
+	[Table Name] [Next] [NilUUID] [Version] [Rows UUID] [Indexes] [Field1 UUID] ... [FieldN UUID]
+
+	字段的uuid不可能为NilUUID, 所以用NilUUID来标记后一种格式, Version为uint16.
+	Rows是一棵以entry的uuid为key的B+树, 记录了该表所有版本的entry, 用于全表扫描.
+	前一种格式的表是旧版本创建的, 没有Rows, 只能通过字段的索引来扫描全表.
+	Version不小于_TABLE_VERSION_INDEXES的表才有Indexes, 其格式为:
+	[NoIndexes] uint16 [Index1 UUID] ... [IndexM UUID]
+	为该表的组合索引, 由于组合索引引用了字段, 所以它们在字段之后读入.
+
+	Version不小于_TABLE_VERSION_NULLS的表, 其entry的格式为:
+	[Null Bitmap] [Field1 Value] ... [FieldN Value]
+	Null Bitmap共(N+7)/8字节, 第i位为1表示第i个字段为NULL, 此时该字段的值不会被写入.
+	更早的表的entry没有Null Bitmap, 其字段都不能为NULL.
+*/
 type table struct {
 	TableManager *tableManager
 	SelfUUID     utils.UUID
 
-	Name   string
-	status byte
-	Next   utils.UUID
-	fields []*field
+	Name    string
+	status  byte
+	Next    utils.UUID
+	version uint16
+	rows    utils.UUID
+	rowTree im.BPlusTree
+	fields  []*field
+	indexes []*index // 组合索引
+
+	indexSet *indexSet // 写入entry时需要更新的索引, 由表的各个版本共享
+	prev     *table    // 被修改了索引的表的原来的版本, 见tableManager.CreateIndex
 }
 
 /*
@@ -46,6 +80,7 @@ func LoadTable(tbm *tableManager, uuid utils.UUID) *table {
 	}
 
 	tb.parseSelf(raw)
+	tb.indexSet = &indexSet{writers: tb.writers()}
 	return tb
 }
 
@@ -57,61 +92,177 @@ func (t *table) parseSelf(raw []byte) {
 	t.Next = utils.ParseUUID(raw[pos:])
 	pos += utils.LEN_UUID
 
+	if pos < len(raw) && utils.ParseUUID(raw[pos:]) == utils.NilUUID {
+		pos += utils.LEN_UUID
+		t.version = utils.ParseUint16(raw[pos:])
+		pos += 2
+		t.rows = utils.ParseUUID(raw[pos:])
+		pos += utils.LEN_UUID
+
+		var err error
+		t.rowTree, err = im.Load(t.rows, t.TableManager.DataManager)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	var indexUUIDs []utils.UUID
+	if t.version >= _TABLE_VERSION_INDEXES {
+		noIndexes := int(utils.ParseUint16(raw[pos:]))
+		pos += 2
+		for i := 0; i < noIndexes; i++ {
+			indexUUIDs = append(indexUUIDs, utils.ParseUUID(raw[pos:]))
+			pos += utils.LEN_UUID
+		}
+	}
+
 	for pos < len(raw) {
 		uuid := utils.ParseUUID(raw[pos:])
 		pos += utils.LEN_UUID
 		f := LoadField(t, uuid)
 		t.fields = append(t.fields, f)
 	}
+
+	for _, uuid := range indexUUIDs {
+		t.indexes = append(t.indexes, LoadIndex(t, uuid))
+	}
 }
 
 // CreateTable 创建一张表, 并返回其指针.
 func CreateTable(tbm *tableManager, next utils.UUID, xid tm.TransactionID, create *statement.Create) (*table, error) {
+	rows, err := im.Create(tbm.DataManager)
+	if err != nil {
+		return nil, err
+	}
+	rowTree, err := im.Load(rows, tbm.DataManager)
+	if err != nil {
+		return nil, err
+	}
+
 	tb := &table{
 		TableManager: tbm,
 		Name:         create.TableName,
 		Next:         next,
+		version:      _TABLE_VERSION_INDEXES,
+		rows:         rows,
+		rowTree:      rowTree,
 	}
 
 	for i := 0; i < len(create.FieldName); i++ {
 		fname := create.FieldName[i]
 		ftype := create.FieldType[i]
-		indexed := false
+		indexed, hash := false, false
 		for j := 0; j < len(create.Index); j++ {
 			if create.Index[j] == fname {
 				indexed = true
 				break
 			}
 		}
-		field, err := CreateField(tb, xid, fname, ftype, indexed)
+		for j := 0; j < len(create.HashIndex); j++ {
+			if create.HashIndex[j] == fname {
+				hash = true
+				break
+			}
+		}
+		field, err := CreateField(tb, xid, fname, ftype, indexed, hash, create.FieldNotNull[i],
+			create.FieldUnique[i], create.PrimaryKey == fname, create.FieldDefault[i])
 		if err != nil {
 			return nil, err
 		}
 		tb.fields = append(tb.fields, field)
 	}
 
-	err := tb.persistSelf(xid)
+	for _, names := range create.CompositeIndex {
+		var fields []*field
+		for _, name := range names {
+			fd := tb.fieldByName(name)
+			if fd == nil {
+				return nil, ErrNoThatField
+			}
+			for _, f := range fields {
+				if f == fd {
+					return nil, ErrInvalidValues
+				}
+			}
+			fields = append(fields, fd)
+		}
+		name := statement.DefaultIndexName(names) // 与create index的默认名字相同, 可以被drop index引用
+		if tb.indexByName(name) != nil {
+			return nil, ErrIndexExists
+		}
+		ix, err := CreateIndex(tb, xid, name, fields)
+		if err != nil {
+			return nil, err
+		}
+		tb.indexes = append(tb.indexes, ix)
+	}
+
+	err = tb.persistSelf(xid)
 	if err != nil {
 		return nil, err
 	}
 
+	tb.indexSet = &indexSet{writers: tb.writers()}
 	return tb, nil
 }
 
-// persist 将t自身持久化到磁盘上, 该函数只会在CreateTable的时候被调用
+// persistSelf 将t自身持久化到磁盘上, 该函数只会在CreateTable的时候被调用
 func (t *table) persistSelf(xid tm.TransactionID) error {
+	self, err := t.persist(xid, t.Next)
+	if err != nil {
+		return err
+	}
+
+	t.SelfUUID = self
+	return nil
+}
+
+// persist 以next作为t的Next, 将t的一个新版本持久化, 返回该版本的uuid.
+// 由于SM中的记录不能被修改, 所以改变表链时, 需要用它写入新的版本.
+func (t *table) persist(xid tm.TransactionID, next utils.UUID) (utils.UUID, error) {
 	raw := utils.VarStrToRaw(t.Name)
-	raw = append(raw, utils.UUIDToRaw(t.Next)...)
+	raw = append(raw, utils.UUIDToRaw(next)...)
+	if t.rows != utils.NilUUID {
+		raw = append(raw, utils.UUIDToRaw(utils.NilUUID)...)
+		raw = append(raw, utils.Uint16ToRaw(t.version)...)
+		raw = append(raw, utils.UUIDToRaw(t.rows)...)
+	}
+	if t.version >= _TABLE_VERSION_INDEXES {
+		raw = append(raw, utils.Uint16ToRaw(uint16(len(t.indexes)))...)
+		for _, ix := range t.indexes {
+			raw = append(raw, utils.UUIDToRaw(ix.SelfUUID)...)
+		}
+	}
 	for _, f := range t.fields {
 		raw = append(raw, utils.UUIDToRaw(f.SelfUUID)...)
 	}
 
-	self, err := t.TableManager.SerializabilityManager.Insert(xid, raw)
+	return t.TableManager.SerializabilityManager.Insert(xid, raw)
+}
+
+// Drop 在xid中删除该表自身的记录, 以及其所有字段的记录.
+// 表链的修改在xid提交时由tableManager完成.
+func (t *table) Drop(xid tm.TransactionID) error {
+	ok, err := t.TableManager.SerializabilityManager.Delete(xid, t.SelfUUID)
 	if err != nil {
 		return err
 	}
+	if ok == false { // 该表对xid不可见
+		return ErrNoThatTable
+	}
 
-	t.SelfUUID = self
+	for _, f := range t.fields {
+		err := f.Drop(xid)
+		if err != nil {
+			return err
+		}
+	}
+	for _, ix := range t.indexes {
+		err := ix.Drop(xid)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -120,7 +271,15 @@ func (t *table) Print() string {
 	str += t.Name + ": "
 	for i := 0; i < len(t.fields); i++ {
 		str += t.fields[i].Print()
-		if i == len(t.fields)-1 {
+		if i == len(t.fields)-1 && len(t.indexes) == 0 {
+			str += "}"
+		} else {
+			str += ", "
+		}
+	}
+	for i, ix := range t.indexes {
+		str += ix.Print()
+		if i == len(t.indexes)-1 {
 			str += "}"
 		} else {
 			str += ", "
@@ -130,7 +289,7 @@ func (t *table) Print() string {
 }
 
 func (t *table) Delete(xid tm.TransactionID, delete *statement.Delete) (int, error) {
-	uuids, err := t.parseWhere(delete.Where)
+	uuids, _, err := t.selectEntries(xid, delete.Where)
 	if err != nil {
 		return 0, err
 	}
@@ -149,236 +308,684 @@ func (t *table) Delete(xid tm.TransactionID, delete *statement.Delete) (int, err
 	return count, nil
 }
 
+/*
+	Update 对该表执行update语句, 每个entry的所有赋值都以其原来的值计算, 并写入同一个新版本中.
+	所有的新值都在写入之前计算好, 所以计算出错时不会写入任何版本.
+	每个新版本在原来的entry被删除之前写入, 这样如果它违反了唯一约束, 原来的entry仍然存在.
+	和Insert一样, 一条update语句要么全部生效, 要么在出错时撤销已经写入的新版本, 并恢复被删除的entry.
+	原来的entry在被选出之后已经不可见时, 跳过该entry, 和Delete一样不计入结果.
+*/
 func (t *table) Update(xid tm.TransactionID, update *statement.Update) (int, error) {
-	uuids, err := t.parseWhere(update.Where)
+	var fields []*field
+	for _, assignment := range update.Assignments {
+		fd := t.fieldByName(assignment.FieldName)
+		if fd == nil {
+			return 0, ErrNoThatField
+		}
+		for _, f := range fields {
+			if f == fd {
+				return 0, ErrInvalidValues
+			}
+		}
+		err := t.checkExpr(fd, assignment.Value)
+		if err != nil {
+			return 0, err
+		}
+		fields = append(fields, fd)
+	}
+
+	uuids, entries, err := t.selectEntries(xid, update.Where)
 	if err != nil {
 		return 0, err
 	}
 
-	var fd *field
-	for _, f := range t.fields {
-		if f.FName == update.FieldName {
-			fd = f
-			break
+	for _, e := range entries { // 计算所有的新值
+		values := make([]interface{}, len(fields))
+		for j, fd := range fields {
+			values[j], err = t.evalExpr(fd, update.Assignments[j].Value, e)
+			if err != nil {
+				return 0, err
+			}
+			err = fd.CheckValue(values[j])
+			if err != nil {
+				return 0, err
+			}
+		}
+		for j, fd := range fields { // 更新entry
+			e[fd.FName] = values[j]
 		}
 	}
-	if fd == nil {
-		return 0, ErrNoThatField
+
+	var done []int // 已经被替换的entry
+	newUUIDs := make([]utils.UUID, len(uuids))
+	for i, uuid := range uuids {
+		newUUID, ok, err := t.updateEntry(xid, uuid, entries[i])
+		if err != nil {
+			for _, k := range done {
+				t.undoInsert(xid, entries[k], newUUIDs[k])
+				t.TableManager.SerializabilityManager.UnDelete(xid, uuids[k])
+			}
+			return 0, err
+		}
+		if ok {
+			newUUIDs[i] = newUUID
+			done = append(done, i)
+		}
 	}
-	v, err := fd.StrToValue(update.Value)
+
+	return len(done), nil
+}
+
+// updateEntry 在xid中用e替换uuid, 返回e的uuid.
+// 先写入e, 再删除uuid, uuid已经对xid不可见时撤销e并返回false, 出错时也撤销e.
+func (t *table) updateEntry(xid tm.TransactionID, uuid utils.UUID, e entry) (utils.UUID, bool, error) {
+	newUUID, err := t.insertEntry(xid, e, uuid) // 将新entry存储进DB, 并更新对应的索引
 	if err != nil {
-		return 0, err
+		return utils.NilUUID, false, err
 	}
+	ok, err := t.TableManager.SerializabilityManager.Delete(xid, uuid) // 删除原来的entry
+	if err != nil || ok == false {
+		t.undoInsert(xid, e, newUUID)
+		return utils.NilUUID, false, err
+	}
+	return newUUID, true, nil
+}
 
-	count := 0
-	for _, uuid := range uuids {
-		raw, ok, err := t.TableManager.SerializabilityManager.Read(xid, uuid)
-		if err != nil {
-			return 0, err
+// Read 对该表执行read语句.
+// 如果order by只有一个字段, 且该字段的索引保持了值的顺序, 则直接按索引的顺序读取,
+// 否则读取所有满足where的entry后再排序.
+func (t *table) Read(xid tm.TransactionID, read *statement.Read) (string, error) {
+	if isAggregateRead(read) {
+		return t.readAggregate(xid, read)
+	}
+
+	fields, err := t.selectFields(read.Fields)
+	if err != nil {
+		return "", err
+	}
+	keys, err := t.orderKeys(read.OrderBy)
+	if err != nil {
+		return "", err
+	}
+	err = t.checkWhere(read.Where)
+	if err != nil {
+		return "", err
+	}
+
+	result := ""
+	limit, offset := read.Limit, read.Offset
+	emit := func(e entry) (bool, error) {
+		if limit == 0 {
+			return false, nil
 		}
-		if ok == false {
-			continue
+		if offset > 0 {
+			offset--
+			return true, nil
 		}
+		result += t.entryPrint(e, fields) + "\n"
+		if limit > 0 {
+			limit--
+		}
+		return limit != 0, nil
+	}
+	if limit == 0 {
+		return result, nil
+	}
 
-		_, err = t.TableManager.SerializabilityManager.Delete(xid, uuid) // 删除原来的entry
-		if err != nil {
-			return 0, err
+	if len(keys) == 0 {
+		err = t.filterEntries(xid, read.Where, nil, func(_ utils.UUID, e entry) (bool, error) {
+			return emit(e)
+		})
+		return result, err
+	}
+
+	candidates, ok, err := t.orderedCandidates(keys, read.Where)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		err = t.filterEntries(xid, read.Where, candidates, func(_ utils.UUID, e entry) (bool, error) {
+			return emit(e)
+		})
+		return result, err
+	}
+
+	sorter := newEntrySorter(t, keys)
+	defer sorter.Close()
+	err = t.filterEntries(xid, read.Where, nil, func(_ utils.UUID, e entry) (bool, error) {
+		return true, sorter.Add(e)
+	})
+	if err != nil {
+		return "", err
+	}
+	err = sorter.Sort(emit)
+	return result, err
+}
+
+// orderKeys 将order by转换为对应的字段
+func (t *table) orderKeys(orderBy []statement.OrderBy) ([]orderKey, error) {
+	var keys []orderKey
+	for _, ob := range orderBy {
+		fd := t.fieldByName(ob.Field)
+		if fd == nil {
+			return nil, ErrNoThatField
 		}
+		keys = append(keys, orderKey{fd, ob.Desc})
+	}
+	return keys, nil
+}
 
-		e := t.parseEntry(raw) // 读取并解析entry
-		e[fd.FName] = v        // 更新entry
-		raw = t.entryToRaw(e)  // 将新entry存储进DB
-		uuid, err = t.TableManager.SerializabilityManager.Insert(xid, raw)
+// orderedCandidates 尝试通过索引按keys的顺序取得候选的uuid, 如果无法通过索引保证顺序, 则返回false.
+// 如果where能在另一个字段的索引上确定区间, 则认为先通过该索引过滤再排序更合适.
+func (t *table) orderedCandidates(keys []orderKey, where *statement.Where) (cursor, bool, error) {
+	if len(keys) != 1 {
+		return nil, false, nil
+	}
+	fd := keys[0].fd
+	if fd.IsIndexed() == false || fd.IsHashIndexed() || fd.IsKeyExact() == false || fd.Nullable() { // NULL不在索引中
+		return nil, false, nil
+	}
+
+	ranges := []keyRange{{0, utils.INF}}
+	if where != nil {
+		best, bestRanges, err := t.chooseIndex(where)
 		if err != nil {
-			return 0, err
+			return nil, false, err
+		}
+		if best != nil && best != fd {
+			return nil, false, nil
+		}
+		ix, _, columns, err := t.chooseCompositeIndex(where)
+		if err != nil {
+			return nil, false, err
+		}
+		if ix != nil && (columns >= 2 || best == nil) {
+			return nil, false, nil
+		}
+		if best == fd {
+			ranges = bestRanges
 		}
+	}
 
-		count++
+	desc := keys[0].desc
+	var open []func() (im.Iterator, error)
+	for i := range ranges {
+		r := ranges[i]
+		if desc { // 区间按从小到大排列
+			r = ranges[len(ranges)-1-i]
+		}
+		open = append(open, func() (im.Iterator, error) {
+			return fd.Iterate(r.left, r.right, desc)
+		})
+	}
+	return newTreeCursor(open...), true, nil
+}
 
-		for _, f := range t.fields { // 更新对应的索引
-			if f.IsIndexed() {
-				err := f.Insert(e[f.FName], uuid)
-				if err != nil {
-					return 0, err
-				}
-			}
+// selectFields 将read中要读取的字段名转换为对应的field, 顺序和names相同.
+// "*"表示所有的字段.
+func (t *table) selectFields(names []string) ([]*field, error) {
+	var fields []*field
+	for _, name := range names {
+		if name == "*" {
+			fields = append(fields, t.fields...)
+			continue
+		}
+		fd := t.fieldByName(name)
+		if fd == nil {
+			return nil, ErrNoThatField
 		}
+		fields = append(fields, fd)
 	}
+	return fields, nil
+}
 
-	return count, nil
+// fieldByName 返回名为name的字段, 如果不存在则返回nil
+func (t *table) fieldByName(name string) *field {
+	for _, f := range t.fields {
+		if f.FName == name {
+			return f
+		}
+	}
+	return nil
 }
 
-func (t *table) Read(xid tm.TransactionID, read *statement.Read) (string, error) {
-	uuids, err := t.parseWhere(read.Where)
+func (t *table) indexByName(name string) *index {
+	for _, ix := range t.indexes {
+		if ix.Name == name {
+			return ix
+		}
+	}
+	return nil
+}
+
+// selectEntries 返回对xid可见, 且满足where的所有entry, 以及它们的uuid.
+func (t *table) selectEntries(xid tm.TransactionID, where *statement.Where) ([]utils.UUID, []entry, error) {
+	var uuids []utils.UUID
+	var entries []entry
+	err := t.filterEntries(xid, where, nil, func(uuid utils.UUID, e entry) (bool, error) {
+		uuids = append(uuids, uuid)
+		entries = append(entries, e)
+		return true, nil
+	})
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
+	return uuids, entries, nil
+}
 
-	result := ""
-	for _, uuid := range uuids {
-		raw, ok, err := t.TableManager.SerializabilityManager.Read(xid, uuid)
+// filterEntries 按candidates的顺序, 将其中对xid可见, 且满足where的entry交给handle, handle返回false时停止.
+// candidates为nil时, 通过parseWhere取得候选的uuid. candidates会被关闭.
+// 候选的uuid是从索引中逐个读出的, 所以handle不能修改该表, 需要修改的语句应该先取得所有的entry, 见selectEntries.
+func (t *table) filterEntries(xid tm.TransactionID, where *statement.Where, candidates cursor,
+	handle func(uuid utils.UUID, e entry) (bool, error)) error {
+	if candidates == nil {
+		var err error
+		candidates, err = t.parseWhere(where)
 		if err != nil {
-			return "", err
+			return err
+		}
+	}
+	defer candidates.Close()
+
+	for {
+		uuid, ok, err := candidates.Next()
+		if err != nil || ok == false {
+			return err
+		}
+		e, ok, err := t.readEntry(xid, uuid, where)
+		if err != nil {
+			return err
 		}
 		if ok == false {
 			continue
 		}
-		e := t.parseEntry(raw)
-		result += t.entryPrint(e) + "\n"
+		goOn, err := handle(uuid, e)
+		if err != nil || goOn == false {
+			return err
+		}
+	}
+}
+
+// readEntry 读取uuid对应的entry, 如果它对xid不可见, 或者不满足where, 则返回false.
+func (t *table) readEntry(xid tm.TransactionID, uuid utils.UUID, where *statement.Where) (entry, bool, error) {
+	raw, ok, err := t.TableManager.SerializabilityManager.Read(xid, uuid)
+	if err != nil || ok == false {
+		return nil, false, err
 	}
 
-	return result, nil
+	e := t.parseEntry(raw)
+	match, err := t.matchWhere(where, e)
+	if err != nil || match == false {
+		return nil, false, err
+	}
+	return e, true, nil
 }
 
-// parseWhere 对where语句进行解析, 返回field, 该where对应区间内的uuid
-func (t *table) parseWhere(where *statement.Where) ([]utils.UUID, error) {
-	var l0, r0, l1, r1 utils.UUID
-	single := false
-	var err error
-	var fd *field
+// scanAll 返回该表所有entry的uuid, 其中包括对当前事务不可见的版本.
+// 旧版本创建的表没有rows, 只能借助某个字段的索引来扫描.
+func (t *table) scanAll() ([]utils.UUID, error) {
+	if t.rowTree != nil {
+		return t.rowTree.SearchRange(0, utils.INF)
+	}
+	for _, f := range t.fields {
+		if f.IsIndexed() {
+			return f.Search(0, utils.INF)
+		}
+	}
+	return nil, nil // 既没有rows也没有索引, 其中的entry无法被找到
+}
 
-	if where == nil {
-		for _, f := range t.fields {
-			if f.IsIndexed() {
-				fd = f
-				break
-			}
+// scanCursor 和scanAll一样返回该表所有entry的uuid, 但是在遍历时才从索引中逐个读出.
+func (t *table) scanCursor() cursor {
+	if t.rowTree != nil {
+		return newTreeCursor(func() (im.Iterator, error) {
+			return t.rowTree.Iterate(0, utils.INF, false)
+		})
+	}
+	for _, f := range t.fields {
+		if f.IsIndexed() {
+			fd := f
+			return newTreeCursor(func() (im.Iterator, error) {
+				return fd.Iterate(0, utils.INF, false)
+			})
 		}
-		l0, r0 = 0, utils.INF
-		single = true
-	} else if where != nil {
-		for _, f := range t.fields {
-			if f.FName == where.SingleExp1.Field {
-				if f.IsIndexed() == false {
-					return nil, ErrFieldHasNoField
-				}
-				fd = f
-				break
+	}
+	return newTreeCursor()
+}
+
+// cursor 按顺序逐个返回候选的entry的uuid
+type cursor interface {
+	Next() (utils.UUID, bool, error)
+	Close()
+}
+
+// treeCursor 依次遍历索引上的多个区间, 每个区间的迭代器在遍历到它时才被打开.
+type treeCursor struct {
+	open []func() (im.Iterator, error) // 尚未打开的各个区间的迭代器
+	it   im.Iterator
+}
+
+func newTreeCursor(open ...func() (im.Iterator, error)) *treeCursor {
+	return &treeCursor{open: open}
+}
+
+func (c *treeCursor) Next() (utils.UUID, bool, error) {
+	for {
+		if c.it == nil {
+			if len(c.open) == 0 {
+				return utils.NilUUID, false, nil
 			}
+			it, err := c.open[0]()
+			if err != nil {
+				return utils.NilUUID, false, err
+			}
+			c.it, c.open = it, c.open[1:]
 		}
-		if fd == nil {
-			return nil, ErrNoThatField
+		_, uuid, ok, err := c.it.Next()
+		if err != nil {
+			return utils.NilUUID, false, err
+		}
+		if ok {
+			return uuid, true, nil
+		}
+		c.it.Close()
+		c.it = nil
+	}
+}
+
+func (c *treeCursor) Close() {
+	if c.it != nil {
+		c.it.Close()
+		c.it = nil
+	}
+	c.open = nil
+}
+
+// Insert 对该表执行insert语句, 返回插入的entry的数目.
+// 所有的行会先全部转换为entry, 再逐个插入. 如果插入中途出错, 则删除该语句已经插入的entry,
+// 使得该语句要么插入所有的行, 要么什么都不插入.
+func (t *table) Insert(xid tm.TransactionID, insert *statement.Insert) (int, error) {
+	var entries []entry
+	for _, row := range insert.Rows {
+		e, err := t.strToEntry(insert.Fields, row) // 将insert的values转换为entry
+		if err != nil {
+			return 0, err
 		}
+		entries = append(entries, e)
+	}
 
-		l0, r0, l1, r1, single, err = t.calWhere(fd, where)
+	var uuids []utils.UUID
+	for _, e := range entries {
+		uuid, err := t.insertEntry(xid, e, utils.NilUUID)
 		if err != nil {
-			return nil, err
+			for i, uuid := range uuids {
+				t.undoInsert(xid, entries[i], uuid)
+			}
+			return 0, err
 		}
+		uuids = append(uuids, uuid)
 	}
+	return len(uuids), nil
+}
 
-	uuids, err := fd.Search(l0, r0)
+// insertEntry 将e插入到DB, 并更新对应的索引, old为e将要替换的entry, 没有时为NilUUID.
+// 如果更新索引出错, 或者e违反了唯一约束(返回ErrDuplicatedKey), 则撤销e的插入.
+func (t *table) insertEntry(xid tm.TransactionID, e entry, old utils.UUID) (utils.UUID, error) {
+	raw := t.entryToRaw(e)
+	uuid, err := t.TableManager.SerializabilityManager.Insert(xid, raw)
 	if err != nil {
-		return nil, err
+		return utils.NilUUID, err
 	}
-	if single == false {
-		tmp, err := fd.Search(l1, r1)
-		if err != nil {
-			return nil, err
+	err = t.ownUnique(xid, e, uuid)
+	if err == nil {
+		err = t.indexEntry(e, uuid)
+	}
+	if err == nil {
+		err = t.checkUnique(xid, e, uuid, old)
+	}
+	if err != nil {
+		t.undoInsert(xid, e, uuid)
+		return utils.NilUUID, err
+	}
+	return uuid, nil
+}
+
+// ownUnique 如果e在某个唯一的字段上不为NULL, 则在e被加入索引之前, 使xid占用e的版本uuid,
+// 这样在checkUnique中找到e的其他事务会等待xid结束. 其他的版本不会被唯一约束检查, 不需要占用.
+func (t *table) ownUnique(xid tm.TransactionID, e entry, uuid utils.UUID) error {
+	for _, f := range t.fields {
+		if f.unique && e[f.FName] != nil {
+			return t.TableManager.SerializabilityManager.Own(xid, uuid)
 		}
-		uuids = append(uuids, tmp...)
 	}
+	return nil
+}
 
-	return uuids, nil
+// undoInsert 撤销insertEntry插入的entry e, 将uuid从各个索引中删除, 并删除该版本.
+// 索引中不存在的uuid会被忽略, 所以可以用于只加入了部分索引的entry.
+// 撤销发生在已经出错的路径上, 所以其中的错误被忽略, 残留的版本对其他事务不可见, 会被VACUUM清理.
+func (t *table) undoInsert(xid tm.TransactionID, e entry, uuid utils.UUID) {
+	t.unindexEntry(e, uuid)
+	t.TableManager.SerializabilityManager.Delete(xid, uuid)
 }
 
-// calWhere 计算该where语句所表示的key的区间.
-// 由于where或许有or, 所以区间可能为2个.
-func (t *table) calWhere(fd *field, where *statement.Where) (l0, r0, l1, r1 utils.UUID, single bool, err error) {
-	if where.LogicOp == "" { // single
-		single = true
-		l0, r0, err = fd.CalExp(where.SingleExp1)
-	} else if where.LogicOp == "or" {
-		single = false
-		l0, r0, err = fd.CalExp(where.SingleExp1)
-		if err != nil {
-			return
+/*
+	checkUnique 检查entry e(其uuid为uuid)在唯一的字段上是否与其他版本冲突, old为e将要替换的entry.
+	e先被加入索引, 再检查冲突, 所以两个同时写入相同值的事务中, 至少有一个能看到另一个写入的版本.
+	如果冲突的版本由活跃的事务写入或删除, 则等待该事务结束, 见SerializabilityManager.Conflict.
+	NULL不会和任何值冲突.
+*/
+func (t *table) checkUnique(xid tm.TransactionID, e entry, uuid, old utils.UUID) error {
+	for _, f := range t.fields {
+		v := e[f.FName]
+		if f.unique == false || v == nil {
+			continue
 		}
-		l1, r1, err = fd.CalExp(where.SingleExp2)
-	} else if where.LogicOp == "and" {
-		single = true
-		l0, r0, err = fd.CalExp(where.SingleExp1)
+		key := f.ValueToUUID(v)
+		uuids, err := f.Search(key, key)
 		if err != nil {
-			return
+			return err
+		}
+		for _, other := range uuids {
+			if other == uuid || other == old {
+				continue
+			}
+			raw, ok, err := t.TableManager.SerializabilityManager.ReadVersion(other)
+			if err != nil {
+				return err
+			}
+			if ok == false || f.Compare(t.parseEntry(raw)[f.FName], v) != 0 { // key可能不能唯一确定值
+				continue
+			}
+			conflict, err := t.TableManager.SerializabilityManager.Conflict(xid, other)
+			if err != nil {
+				return err
+			}
+			if conflict {
+				return ErrDuplicatedKey
+			}
 		}
-		l1, r1, err = fd.CalExp(where.SingleExp2)
-		// 合并[l0, r0], [l1, r1]两个区间
-		if l1 > l0 {
-			l0 = l1
+	}
+	return nil
+}
+
+// indexEntry 将uuid加入到rows, 以及indexSet中的各个索引中, 其中包括正在被建立的索引.
+// NULL不会被加入到字段的索引中, 它不会满足任何比较, 所以通过索引查找时不需要找到它.
+func (t *table) indexEntry(e entry, uuid utils.UUID) error {
+	t.indexSet.lock.RLock()
+	defer t.indexSet.lock.RUnlock()
+	if t.rowTree != nil {
+		err := t.rowTree.Insert(uuid, uuid)
+		if err != nil {
+			return err
 		}
-		if r1 < r0 {
-			r0 = r1
+	}
+
+	for _, w := range t.indexSet.writers {
+		err := w.insertEntry(e, uuid)
+		if err != nil {
+			return err
 		}
-		return
-	} else {
-		err = ErrInvalidLogOP
 	}
-	return
+	return nil
 }
 
-// Insert 对该表执行insert语句.
-func (t *table) Insert(xid tm.TransactionID, insert *statement.Insert) error {
-	e, err := t.strToEntry(insert.Values) // 将insert的values转换为entry
-	if err != nil {
-		return err
+// unindexEntry 将uuid从rows, 以及indexSet中的各个索引中删除, e为uuid对应的entry.
+func (t *table) unindexEntry(e entry, uuid utils.UUID) error {
+	t.indexSet.lock.RLock()
+	defer t.indexSet.lock.RUnlock()
+	if t.rowTree != nil {
+		_, err := t.rowTree.Delete(uuid, uuid)
+		if err != nil {
+			return err
+		}
 	}
 
-	raw := t.entryToRaw(e) // 将该entry插入到DB
-	uuid, err := t.TableManager.SerializabilityManager.Insert(xid, raw)
+	for _, w := range t.indexSet.writers {
+		err := w.deleteEntry(e, uuid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	Vacuum 将该表中对所有事务都不可见的版本从rows和各个索引中删除, 返回删除的版本的数目.
+	这些版本不会再被任何事务读到, 所以不需要和读写该表的事务互斥. entry本身占用的空间不会被释放.
+	对于没有rows的表, 通过索引找到的版本在删除之后就无法再被找到, 所以同样不会被重复处理.
+*/
+func (t *table) Vacuum() (int, error) {
+	uuids, err := t.scanAll()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	for _, f := range t.fields { // 更新对应的索引
-		if f.IsIndexed() {
-			err := f.Insert(e[f.FName], uuid)
-			if err != nil {
-				return err
-			}
+	count := 0
+	for _, uuid := range uuids {
+		dead, err := t.TableManager.SerializabilityManager.IsDead(uuid)
+		if err != nil {
+			return count, err
 		}
+		if dead == false {
+			continue
+		}
+		raw, ok, err := t.TableManager.SerializabilityManager.ReadVersion(uuid)
+		if err != nil {
+			return count, err
+		}
+		if ok == false { // 在恢复时被清除的entry, 已经无法计算它在索引中的key
+			continue
+		}
+		err = t.unindexEntry(t.parseEntry(raw), uuid)
+		if err != nil {
+			return count, err
+		}
+		count++
 	}
-	return nil
+	return count, nil
 }
 
-func (t *table) strToEntry(values []string) (entry, error) {
-	if len(values) != len(t.fields) {
+// strToEntry 将values转换为entry, values与names一一对应, 未给出的字段取默认值.
+// names为nil时, values需要按顺序给出所有字段的值.
+func (t *table) strToEntry(names []string, values []*statement.Value) (entry, error) {
+	if names == nil {
+		if len(values) != len(t.fields) {
+			return nil, ErrInvalidValues
+		}
+		for _, f := range t.fields {
+			names = append(names, f.FName)
+		}
+	}
+	if len(values) != len(names) {
 		return nil, ErrInvalidValues
 	}
 
 	e := entry{}
-	for i, f := range t.fields {
-		v, err := f.StrToValue(values[i])
+	for i, name := range names {
+		f := t.fieldByName(name)
+		if f == nil {
+			return nil, ErrNoThatField
+		}
+		if _, ok := e[f.FName]; ok {
+			return nil, ErrInvalidValues
+		}
+		if values[i].Null {
+			e[f.FName] = nil
+		} else {
+			v, err := f.StrToValue(values[i].Str)
+			if err != nil {
+				return nil, err
+			}
+			e[f.FName] = v
+		}
+	}
+	for _, f := range t.fields {
+		if _, ok := e[f.FName]; ok == false {
+			v, err := f.DefaultValue()
+			if err != nil {
+				return nil, err
+			}
+			e[f.FName] = v
+		}
+		err := f.CheckValue(e[f.FName])
 		if err != nil {
 			return nil, err
 		}
-		e[f.FName] = v
 	}
 
 	return e, nil
 }
 
+// hasNullBitmap 返回该表的entry是否带有null bitmap
+func (t *table) hasNullBitmap() bool {
+	return t.version >= _TABLE_VERSION_NULLS
+}
+
 func (t *table) entryToRaw(e entry) []byte {
 	var raw []byte
+	if t.hasNullBitmap() {
+		raw = make([]byte, (len(t.fields)+7)/8)
+		for i, f := range t.fields {
+			if e[f.FName] == nil {
+				raw[i/8] |= 1 << (i % 8)
+			}
+		}
+	}
 	for _, f := range t.fields {
-		raw = append(raw, f.ValueToRaw(e[f.FName])...)
+		if e[f.FName] != nil {
+			raw = append(raw, f.ValueToRaw(e[f.FName])...)
+		}
 	}
 	return raw
 }
 
 func (t *table) parseEntry(raw []byte) entry {
 	var pos, shift int
+	var bitmap []byte
+	if t.hasNullBitmap() {
+		pos = (len(t.fields) + 7) / 8
+		bitmap = raw[:pos]
+	}
+
 	e := entry{}
-	for _, f := range t.fields {
+	for i, f := range t.fields {
+		if bitmap != nil && bitmap[i/8]&(1<<(i%8)) != 0 {
+			e[f.FName] = nil
+			continue
+		}
 		e[f.FName], shift = f.ParseValue(raw[pos:])
 		pos += shift
 	}
 	return e
 }
 
-func (t *table) entryPrint(e entry) string {
+// entryPrint 按fields的顺序打印e中对应的值
+func (t *table) entryPrint(e entry, fields []*field) string {
 	str := "["
-	for i, f := range t.fields {
+	for i, f := range fields {
 		str += f.ValuePrint(e[f.FName])
-		if i == len(t.fields)-1 {
+		if i == len(fields)-1 {
 			str += "]"
 		} else {
 			str += ", "
@@ -6,14 +6,16 @@ import (
 	statement "fansDB/backend/parser"
 	tm "fansDB/backend/transaction_manage"
 	"fansDB/backend/utils"
+	"fansDB/backend/utils/booter"
 	sm "fansDB/backend/version_manage"
-	"nyadb2/backend/utils/booter"
+	"sort"
 	"sync"
 )
 
 var (
 	ErrDuplicatedTable = errors.New("Duplicated table.")
 	ErrNoThatTable     = errors.New("No that table.")
+	ErrConcurrentDDL   = errors.New("Tables are being changed by another transaction.")
 )
 
 type TableManager interface {
@@ -23,46 +25,73 @@ type TableManager interface {
 
 	Show(xid tm.TransactionID) []byte
 	Create(xid tm.TransactionID, create *statement.Create) ([]byte, error)
+	Drop(xid tm.TransactionID, drop *statement.Drop) ([]byte, error)
+	CreateIndex(xid tm.TransactionID, create *statement.CreateIndex) ([]byte, error)
+	DropIndex(xid tm.TransactionID, drop *statement.DropIndex) ([]byte, error)
 
 	Insert(xid tm.TransactionID, insert *statement.Insert) ([]byte, error)
 	Read(xid tm.TransactionID, read *statement.Read) ([]byte, error)
 	Update(xid tm.TransactionID, update *statement.Update) ([]byte, error)
 	Delete(xid tm.TransactionID, delete *statement.Delete) ([]byte, error)
+	Vacuum(xid tm.TransactionID, vacuum *statement.Vacuum) ([]byte, error)
+	Verify(xid tm.TransactionID, verify *statement.Verify) ([]byte, error)
+
+	// VerifyAll 检查xid看到的所有表, 返回有问题的表名到其问题的映射, 没有问题时映射为空
+	VerifyAll(xid tm.TransactionID) (map[string][]string, error)
 }
 
+/*
+	所有的表通过table.Next组成一条链, 链头的uuid存储在booter中.
+
+	修改表链的事务(DDL)同一时刻只能有一个, 即ddlOwner.
+	Create和Drop只会在事务内写入或删除表的记录, 在提交之前, 新建的表只对该事务可见,
+	被删除的表对其他事务仍然可见. 表链和booter的修改被推迟到提交时完成, 见Commit.
+	如果事务被回滚, 则只需要丢弃内存中的这些记录.
+
+	create index和drop index也是DDL, 它们为表生成一个新版本(见table.CreateIndex), 记录在alteredTable中.
+	在提交之前, 只有xid能看到新版本; 提交时新版本的记录被写入表链, 并替换掉tableCacher中原来的版本.
+*/
 type tableManager struct {
+	TransactionManager     tm.TransactionManager
 	DataManager            dm.DataManager
 	SerializabilityManager sm.SerializabilityManager
 
 	booter booter.Booter
 
-	tableCacher        map[string]*table             // 表缓存
-	transactionIDTable map[tm.TransactionID][]*table // xid 创建了哪些表
+	tableCacher        map[string]*table             // 表缓存, 只包含已经提交的表
+	transactionIDTable map[tm.TransactionID][]*table // xid 创建了哪些表, 按创建顺序排列
+	droppedTable       map[tm.TransactionID][]*table // xid 删除了哪些表, 提交前对其他事务仍可见
+	alteredTable       map[tm.TransactionID][]*table // xid 修改了索引的表的新版本
+	ddlOwner           tm.TransactionID              // 正在修改表链的事务
 	lock               sync.Mutex
 }
 
-func newTableManager(sm sm.SerializabilityManager, dm dm.DataManager, booter booter.Booter) *tableManager {
+func newTableManager(tm0 tm.TransactionManager, sm sm.SerializabilityManager, dm dm.DataManager, booter booter.Booter) *tableManager {
 	tbm := &tableManager{
+		TransactionManager:     tm0,
 		DataManager:            dm,
 		SerializabilityManager: sm,
 		booter:                 booter,
 		tableCacher:            make(map[string]*table),
 		transactionIDTable:     make(map[tm.TransactionID][]*table),
+		droppedTable:           make(map[tm.TransactionID][]*table),
+		alteredTable:           make(map[tm.TransactionID][]*table),
+		ddlOwner:               tm.SUPER_TRANSACTION_ID,
 	}
 
 	tbm.loadTables()
 	return tbm
 }
 
-func Create(path string, sm sm.SerializabilityManager, dm dm.DataManager) *tableManager {
+func Create(path string, tm tm.TransactionManager, sm sm.SerializabilityManager, dm dm.DataManager) *tableManager {
 	booter := booter.Create(path)
 	booter.Update(utils.UUIDToRaw(utils.NilUUID))
-	return newTableManager(sm, dm, booter)
+	return newTableManager(tm, sm, dm, booter)
 }
 
-func Open(path string, sm sm.SerializabilityManager, dm dm.DataManager) *tableManager {
+func Open(path string, tm tm.TransactionManager, sm sm.SerializabilityManager, dm dm.DataManager) *tableManager {
 	booter := booter.Open(path)
-	return newTableManager(sm, dm, booter)
+	return newTableManager(tm, sm, dm, booter)
 }
 
 // loadTables 将所有的table读入内存.
@@ -75,8 +104,23 @@ func (tbm *tableManager) loadTables() {
 	}
 }
 
+/*
+	firstTableUUID 返回表链头的uuid.
+	booter的内容有两种格式:
+	[First Table UUID]
+	[XID] [New First Table UUID] [Old First Table UUID]
+	后者只在DDL事务提交的过程中出现, 如果XID已经提交, 则使用新的表头, 否则使用旧的.
+	这样即使在提交过程中崩溃, 也能找到正确的表头.
+*/
 func (tbm *tableManager) firstTableUUID() utils.UUID {
 	raw := tbm.booter.Load()
+	if len(raw) == tm.LEN_TRANSACTION_ID+utils.LEN_UUID*2 {
+		xid := tm.ParseTransactionID(raw)
+		if tbm.TransactionManager.IsCommitted(xid) {
+			return utils.ParseUUID(raw[tm.LEN_TRANSACTION_ID:])
+		}
+		return utils.ParseUUID(raw[tm.LEN_TRANSACTION_ID+utils.LEN_UUID:])
+	}
 	return utils.ParseUUID(raw)
 }
 
@@ -85,15 +129,91 @@ func (tbm *tableManager) updateFirstTableUUID(uuid utils.UUID) {
 	tbm.booter.Update(raw)
 }
 
+// prepareFirstTableUUID 在xid提交前, 同时记录新旧两个表头
+func (tbm *tableManager) prepareFirstTableUUID(xid tm.TransactionID, newUUID, oldUUID utils.UUID) {
+	raw := tm.XIDToRaw(xid)
+	raw = append(raw, utils.UUIDToRaw(newUUID)...)
+	raw = append(raw, utils.UUIDToRaw(oldUUID)...)
+	tbm.booter.Update(raw)
+}
+
+// getTable 返回xid所能看到的名为name的表, 调用者需持有tbm.lock
+func (tbm *tableManager) getTable(xid tm.TransactionID, name string) (*table, bool) {
+	for _, t := range tbm.transactionIDTable[xid] { // xid自己创建的表
+		if t.Name == name && tbm.isDropped(xid, t) == false {
+			return t, true
+		}
+	}
+	tb, ok := tbm.tableCacher[name]
+	if ok == false {
+		return nil, false
+	}
+	for _, t := range tbm.alteredTable[xid] { // xid修改过的表
+		if t.prev == tb {
+			tb = t
+			break
+		}
+	}
+	if tbm.isDropped(xid, tb) {
+		return nil, false
+	}
+	return tb, true
+}
+
+// isDropped 判断tb是否已经被xid删除
+func (tbm *tableManager) isDropped(xid tm.TransactionID, tb *table) bool {
+	for _, t := range tbm.droppedTable[xid] {
+		if t == tb {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingFirstTableUUID 返回在xid看来的表链头, 即xid最后创建的表, 或者已提交的表头.
+func (tbm *tableManager) pendingFirstTableUUID(xid tm.TransactionID) utils.UUID {
+	created := tbm.transactionIDTable[xid]
+	if len(created) > 0 {
+		return created[len(created)-1].SelfUUID
+	}
+	return tbm.firstTableUUID()
+}
+
+// acquireDDL 让xid成为修改表链的事务, 调用者需持有tbm.lock.
+// 已有其他的DDL事务时立即返回ErrConcurrentDDL而不等待, 由客户端在该事务结束后重试:
+// 等待的事务可能持有ddlOwner正在等待的版本, 而这种等待不在SM的lockTable中, 无法被检测为死锁.
+func (tbm *tableManager) acquireDDL(xid tm.TransactionID) error {
+	if tbm.ddlOwner != tm.SUPER_TRANSACTION_ID && tbm.ddlOwner != xid {
+		return ErrConcurrentDDL
+	}
+	tbm.ddlOwner = xid
+	return nil
+}
+
 func (tbm *tableManager) Read(xid tm.TransactionID, read *statement.Read) ([]byte, error) {
 	tbm.lock.Lock()
-	tb, ok := tbm.tableCacher[read.TableName]
+	tb, ok := tbm.getTable(xid, read.TableName)
+	tables := []*table{tb}
+	for _, join := range read.Joins {
+		var tmp *table
+		tmp, ok = tbm.getTable(xid, join.TableName)
+		if ok == false {
+			break
+		}
+		tables = append(tables, tmp)
+	}
 	tbm.lock.Unlock()
 	if ok == false {
 		return nil, ErrNoThatTable
 	}
 
-	result, err := tb.Read(xid, read)
+	var result string
+	var err error
+	if len(read.Joins) > 0 {
+		result, err = readJoin(xid, read, tables)
+	} else {
+		result, err = tb.Read(xid, read)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +222,7 @@ func (tbm *tableManager) Read(xid tm.TransactionID, read *statement.Read) ([]byt
 
 func (tbm *tableManager) Update(xid tm.TransactionID, update *statement.Update) ([]byte, error) {
 	tbm.lock.Lock()
-	tb, ok := tbm.tableCacher[update.TableName]
+	tb, ok := tbm.getTable(xid, update.TableName)
 	tbm.lock.Unlock()
 	if ok == false {
 		return nil, ErrNoThatTable
@@ -117,7 +237,7 @@ func (tbm *tableManager) Update(xid tm.TransactionID, update *statement.Update)
 
 func (tbm *tableManager) Delete(xid tm.TransactionID, delete *statement.Delete) ([]byte, error) {
 	tbm.lock.Lock()
-	tb, ok := tbm.tableCacher[delete.TableName]
+	tb, ok := tbm.getTable(xid, delete.TableName)
 	tbm.lock.Unlock()
 	if ok == false {
 		return nil, ErrNoThatTable
@@ -130,42 +250,249 @@ func (tbm *tableManager) Delete(xid tm.TransactionID, delete *statement.Delete)
 	return []byte("Delete " + utils.Uint32ToStr(uint32(count))), nil
 }
 
+// Vacuum 从xid看到的表的索引中删除已经对所有事务都不可见的版本
+func (tbm *tableManager) Vacuum(xid tm.TransactionID, vacuum *statement.Vacuum) ([]byte, error) {
+	tbm.lock.Lock()
+	tb, ok := tbm.getTable(xid, vacuum.TableName)
+	tbm.lock.Unlock()
+	if ok == false {
+		return nil, ErrNoThatTable
+	}
+
+	count, err := tb.Vacuum()
+	if err != nil {
+		return nil, err
+	}
+	return []byte("Vacuum " + utils.Uint32ToStr(uint32(count))), nil
+}
+
+// Verify 检查xid看到的表, 每张表输出一行"name: ok", 或者每个问题一行"name: problem"
+func (tbm *tableManager) Verify(xid tm.TransactionID, verify *statement.Verify) ([]byte, error) {
+	var tables []*table
+	tbm.lock.Lock()
+	if verify.TableName != "" {
+		tb, ok := tbm.getTable(xid, verify.TableName)
+		if ok == false {
+			tbm.lock.Unlock()
+			return nil, ErrNoThatTable
+		}
+		tables = append(tables, tb)
+	} else {
+		tables = tbm.visibleTables(xid)
+	}
+	tbm.lock.Unlock()
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Name < tables[j].Name
+	})
+
+	problems, err := verifyTables(xid, tables)
+	if err != nil {
+		return nil, err
+	}
+	var results []byte
+	for _, tb := range tables {
+		lines := problems[tb.Name]
+		if len(lines) == 0 {
+			lines = []string{"ok"}
+		}
+		for _, line := range lines {
+			results = append(results, tb.Name+": "+line+"\n"...)
+		}
+	}
+	return results, nil
+}
+
+func (tbm *tableManager) VerifyAll(xid tm.TransactionID) (map[string][]string, error) {
+	tbm.lock.Lock()
+	tables := tbm.visibleTables(xid)
+	tbm.lock.Unlock()
+	return verifyTables(xid, tables)
+}
+
+// verifyTables 在xid中依次检查tables, 只记录有问题的表
+func verifyTables(xid tm.TransactionID, tables []*table) (map[string][]string, error) {
+	problems := make(map[string][]string)
+	for _, tb := range tables {
+		tp, err := tb.Verify(xid)
+		if err != nil {
+			return nil, err
+		}
+		if len(tp) > 0 {
+			problems[tb.Name] = tp
+		}
+	}
+	return problems, nil
+}
+
 func (tbm *tableManager) Insert(xid tm.TransactionID, insert *statement.Insert) ([]byte, error) {
 	tbm.lock.Lock()
-	tb, ok := tbm.tableCacher[insert.TableName]
+	tb, ok := tbm.getTable(xid, insert.TableName)
 	tbm.lock.Unlock()
 	if ok == false {
 		return nil, ErrNoThatTable
 	}
 
-	err := tb.Insert(xid, insert)
+	count, err := tb.Insert(xid, insert)
 	if err != nil {
 		return nil, err
 	}
-	return []byte("Insert"), nil
+	return []byte("Insert " + utils.Uint32ToStr(uint32(count))), nil
 }
 
 func (tbm *tableManager) Create(xid tm.TransactionID, create *statement.Create) ([]byte, error) {
 	tbm.lock.Lock()
 	defer tbm.lock.Unlock()
 
-	_, ok := tbm.tableCacher[create.TableName]
+	_, ok := tbm.getTable(xid, create.TableName)
 	if ok == true { // 已经存在
 		return nil, ErrDuplicatedTable
 	}
+	err := tbm.acquireDDL(xid)
+	if err != nil {
+		return nil, err
+	}
 
-	// 直接创建新表
-	tb, err := CreateTable(tbm, tbm.firstTableUUID(), xid, create)
+	// 新表接在xid看到的表头之前, 提交之前只对xid可见
+	tb, err := CreateTable(tbm, tbm.pendingFirstTableUUID(xid), xid, create)
 	if err != nil {
 		return nil, err
 	} else { // 创建成功
-		tbm.updateFirstTableUUID(tb.SelfUUID)
-		tbm.tableCacher[create.TableName] = tb
 		tbm.transactionIDTable[xid] = append(tbm.transactionIDTable[xid], tb)
 		return []byte("create " + create.TableName), nil
 	}
 }
 
+// Drop 在xid中删除一张表, 提交之前其他事务依然能看到该表.
+func (tbm *tableManager) Drop(xid tm.TransactionID, drop *statement.Drop) ([]byte, error) {
+	tbm.lock.Lock()
+	defer tbm.lock.Unlock()
+
+	tb, ok := tbm.getTable(xid, drop.TableName)
+	if ok == false {
+		return nil, ErrNoThatTable
+	}
+	err := tbm.acquireDDL(xid)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tb.Drop(xid)
+	if err != nil {
+		return nil, err
+	}
+	tbm.droppedTable[xid] = append(tbm.droppedTable[xid], tb)
+	return []byte("drop " + drop.TableName), nil
+}
+
+// beginAlter 返回xid看到的名为name的表, 并让xid成为修改表链的事务
+func (tbm *tableManager) beginAlter(xid tm.TransactionID, name string) (*table, error) {
+	tbm.lock.Lock()
+	defer tbm.lock.Unlock()
+
+	tb, ok := tbm.getTable(xid, name)
+	if ok == false {
+		return nil, ErrNoThatTable
+	}
+	err := tbm.acquireDDL(xid)
+	if err != nil {
+		return nil, err
+	}
+	return tb, nil
+}
+
+// endAlter 用nt替换xid看到的表old.
+// 如果old是xid自己创建的表, 则直接替换它并重新写入其记录, 否则nt对应的已提交的版本为nt.prev.
+func (tbm *tableManager) endAlter(xid tm.TransactionID, old, nt *table) error {
+	tbm.lock.Lock()
+	defer tbm.lock.Unlock()
+
+	created := tbm.transactionIDTable[xid]
+	for i, t := range created {
+		if t == old {
+			created[i] = nt
+			nt.prev = nil
+			return tbm.rewriteCreated(xid, i)
+		}
+	}
+	altered := tbm.alteredTable[xid]
+	for i, t := range altered {
+		if t == old {
+			altered[i] = nt
+			nt.prev = old.prev
+			return nil
+		}
+	}
+	tbm.alteredTable[xid] = append(altered, nt)
+	return nil
+}
+
+/*
+	rewriteCreated 重新写入xid创建的第i张表的记录, 使其指向新的字段和索引.
+	之后创建的表依次指向前一张表, 所以它们的记录也需要以新的Next重新写入,
+	已经被xid删除的表只需要更新Next, 提交时relink会跳过它们.
+	这些记录在提交之前只对xid可见, 提交时relink不需要再次写入它们.
+	调用者持有tbm.lock.
+*/
+func (tbm *tableManager) rewriteCreated(xid tm.TransactionID, i int) error {
+	created := tbm.transactionIDTable[xid]
+	for ; i < len(created); i++ {
+		t := created[i]
+		if i > 0 {
+			t.Next = created[i-1].SelfUUID
+		}
+		if tbm.isDropped(xid, t) {
+			continue
+		}
+		_, err := tbm.SerializabilityManager.Delete(xid, t.SelfUUID)
+		if err != nil {
+			return err
+		}
+		err = t.persistSelf(xid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	CreateIndex 在xid中为一张已有的表建立索引.
+	建立索引时不持有tbm.lock, 其他事务可以继续读写该表, 它们写入的entry会被同时加入新的索引.
+	由于xid是唯一的DDL事务, 而同一事务的语句是依次执行的, 所以这期间xid看到的表不会被修改.
+*/
+func (tbm *tableManager) CreateIndex(xid tm.TransactionID, create *statement.CreateIndex) ([]byte, error) {
+	tb, err := tbm.beginAlter(xid, create.TableName)
+	if err != nil {
+		return nil, err
+	}
+	nt, err := tb.CreateIndex(xid, create)
+	if err != nil {
+		return nil, err
+	}
+	err = tbm.endAlter(xid, tb, nt)
+	if err != nil {
+		return nil, err
+	}
+	return []byte("create index " + create.IndexName), nil
+}
+
+// DropIndex 在xid中删除一个索引, 提交之前其他事务依然会使用该索引.
+func (tbm *tableManager) DropIndex(xid tm.TransactionID, drop *statement.DropIndex) ([]byte, error) {
+	tb, err := tbm.beginAlter(xid, drop.TableName)
+	if err != nil {
+		return nil, err
+	}
+	nt, err := tb.DropIndex(xid, drop.IndexName)
+	if err != nil {
+		return nil, err
+	}
+	err = tbm.endAlter(xid, tb, nt)
+	if err != nil {
+		return nil, err
+	}
+	return []byte("drop index " + drop.IndexName), nil
+}
+
 /*
 	Show 返回所有的表名.
 */
@@ -173,13 +500,7 @@ func (tbm *tableManager) Show(xid tm.TransactionID) []byte {
 	tbm.lock.Lock()
 	defer tbm.lock.Unlock()
 	var results []byte
-	for _, t := range tbm.tableCacher { // 打印已经提交的表
-		tPrint := t.Print()
-		results = append(results, tPrint...)
-		results = append(results, '\n')
-	}
-
-	for _, t := range tbm.transactionIDTable[xid] { // 打印它自己创建的表
+	for _, t := range tbm.visibleTables(xid) {
 		tPrint := t.Print()
 		results = append(results, tPrint...)
 		results = append(results, '\n')
@@ -188,6 +509,24 @@ func (tbm *tableManager) Show(xid tm.TransactionID) []byte {
 	return results
 }
 
+// visibleTables 返回xid能看到的所有表: 已经提交的表, 被xid修改过的表为其新版本, 之后为它自己创建的表.
+// 调用者持有tbm.lock.
+func (tbm *tableManager) visibleTables(xid tm.TransactionID) []*table {
+	var tables []*table
+	for _, t := range tbm.tableCacher {
+		t, ok := tbm.getTable(xid, t.Name)
+		if ok {
+			tables = append(tables, t)
+		}
+	}
+	for _, t := range tbm.transactionIDTable[xid] {
+		if tbm.isDropped(xid, t) == false {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
 func (tbm *tableManager) Begin(begin *statement.Begin) (tm.TransactionID, []byte) {
 	var level int
 	if begin.IsRepeatableRead {
@@ -197,15 +536,151 @@ func (tbm *tableManager) Begin(begin *statement.Begin) (tm.TransactionID, []byte
 	return xid, []byte("begin")
 }
 
+// Commit 提交xid. 只有修改了表链的事务需要在提交时持有tbm.lock, 其他事务的提交不会互相阻塞.
+// 只有xid自己的语句能让它成为ddlOwner, 而同一事务的语句依次执行, 所以释放tbm.lock后ddlOwner不会变为xid.
 func (tbm *tableManager) Commit(xid tm.TransactionID) ([]byte, error) {
-	err := tbm.SerializabilityManager.Commit(xid)
+	tbm.lock.Lock()
+	if tbm.ddlOwner != xid {
+		tbm.lock.Unlock()
+		err := tbm.SerializabilityManager.Commit(xid)
+		if err != nil {
+			return nil, err
+		}
+		return []byte("commit"), nil
+	}
+	defer tbm.lock.Unlock()
+
+	links, newFirst, err := tbm.relink(xid)
 	if err != nil {
 		return nil, err
 	}
+	oldFirst := tbm.firstTableUUID()
+	tbm.prepareFirstTableUUID(xid, newFirst, oldFirst)
+	err = tbm.SerializabilityManager.Commit(xid)
+	if err != nil {
+		tbm.updateFirstTableUUID(oldFirst)
+		return nil, err
+	}
+	tbm.updateFirstTableUUID(newFirst)
+
+	for _, l := range links {
+		l.table.SelfUUID = l.selfUUID
+		l.table.Next = l.next
+	}
+	for _, t := range tbm.alteredTable[xid] {
+		if t.prev != nil && tbm.tableCacher[t.Name] == t.prev {
+			tbm.tableCacher[t.Name] = t
+		}
+		t.prev = nil
+		t.indexSet.reset(t)
+	}
+	for _, t := range tbm.droppedTable[xid] {
+		if tbm.tableCacher[t.Name] == t {
+			delete(tbm.tableCacher, t.Name)
+		}
+	}
+	for _, t := range tbm.transactionIDTable[xid] {
+		if tbm.isDropped(xid, t) == false {
+			tbm.tableCacher[t.Name] = t
+		}
+	}
+	tbm.endDDL(xid)
 	return []byte("commit"), nil
 }
 
+// Abort 回滚xid, 和Commit一样, 只有修改了表链的事务需要持有tbm.lock.
 func (tbm *tableManager) Abort(xid tm.TransactionID) []byte {
+	tbm.lock.Lock()
+	if tbm.ddlOwner != xid {
+		tbm.lock.Unlock()
+		tbm.SerializabilityManager.Abort(xid)
+		return []byte("abort")
+	}
+	defer tbm.lock.Unlock()
+
 	tbm.SerializabilityManager.Abort(xid)
+	for _, t := range tbm.alteredTable[xid] { // 不再更新xid新建的索引
+		if t.prev != nil {
+			t.indexSet.reset(t.prev)
+		}
+	}
+	tbm.endDDL(xid)
 	return []byte("abort")
 }
+
+// endDDL 清除xid对表链所做修改的记录, 并释放ddlOwner
+func (tbm *tableManager) endDDL(xid tm.TransactionID) {
+	delete(tbm.transactionIDTable, xid)
+	delete(tbm.droppedTable, xid)
+	delete(tbm.alteredTable, xid)
+	tbm.ddlOwner = tm.SUPER_TRANSACTION_ID
+}
+
+// tableLink 表示提交后某张表的新版本
+type tableLink struct {
+	table    *table
+	selfUUID utils.UUID
+	next     utils.UUID
+}
+
+/*
+	relink 计算xid提交后的表链, 返回需要更新的表, 以及新的表头.
+	xid看到的表链为: xid新建的表(从新到旧), 接着是已经提交的表, 从中去掉xid删除的表.
+	从链尾开始, 如果一张表的Next与它在新链中的后继不一致, 或者它的索引被xid修改过,
+	则在xid中删除它的旧版本, 并写入指向新后继的新版本. 由于新版本的uuid不同, 它的前驱也需要被重写,
+	所以被删除的表之前的所有表都会被重写.
+*/
+func (tbm *tableManager) relink(xid tm.TransactionID) ([]*tableLink, utils.UUID, error) {
+	byUUID := make(map[utils.UUID]*table)
+	for _, t := range tbm.tableCacher {
+		byUUID[t.SelfUUID] = t
+	}
+	for _, t := range tbm.transactionIDTable[xid] {
+		byUUID[t.SelfUUID] = t
+	}
+	altered := make(map[*table]bool)
+	for _, t := range tbm.alteredTable[xid] { // 新版本和原来的版本有相同的SelfUUID
+		byUUID[t.SelfUUID] = t
+		altered[t] = true
+	}
+	dropped := make(map[*table]bool)
+	for _, t := range tbm.droppedTable[xid] {
+		dropped[t] = true
+	}
+
+	var chain []*table
+	for uuid := tbm.pendingFirstTableUUID(xid); uuid != utils.NilUUID; {
+		t := byUUID[uuid]
+		utils.Assert(t != nil)
+		if dropped[t] == false {
+			chain = append(chain, t)
+		}
+		uuid = t.Next
+	}
+
+	var links []*tableLink
+	next := utils.NilUUID
+	for i := len(chain) - 1; i >= 0; i-- {
+		t := chain[i]
+		if t.Next == next && altered[t] == false {
+			next = t.SelfUUID
+			continue
+		}
+
+		ok, err := tbm.SerializabilityManager.Delete(xid, t.SelfUUID)
+		if err != nil {
+			return nil, utils.NilUUID, err
+		}
+		if ok == false { // 该表在xid开始后才被创建, 对xid不可见
+			return nil, utils.NilUUID, ErrConcurrentDDL
+		}
+		self, err := t.persist(xid, next)
+		if err != nil {
+			return nil, utils.NilUUID, err
+		}
+		links = append(links, &tableLink{t, self, next})
+		next = self
+	}
+
+	return links, next, nil
+}
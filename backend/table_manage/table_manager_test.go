@@ -0,0 +1,230 @@
+package table_manage
+
+import (
+	dm "fansDB/backend/data_manage"
+	statement "fansDB/backend/parser"
+	tm "fansDB/backend/transaction_manage"
+	sm "fansDB/backend/version_manage"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	_TEST_MEM = (1 << 20) * 16 // 16MB
+)
+
+// testDB 为测试使用的数据库, 测试结束时被关闭
+type testDB struct {
+	t    *testing.T
+	path string
+
+	transactionManager tm.TransactionManager
+	dataManager        dm.DataManager
+	tableManager       *tableManager
+}
+
+// openTestDB 在临时目录中创建一个数据库
+func openTestDB(t *testing.T) *testDB {
+	db := &testDB{t: t, path: filepath.Join(t.TempDir(), "db")}
+	db.transactionManager = tm.Create(db.path)
+	db.dataManager = dm.Create(db.path, _TEST_MEM, db.transactionManager)
+	serializabilityManager := sm.NewSerializabilityManager(db.transactionManager, db.dataManager)
+	db.tableManager = Create(db.path, db.transactionManager, serializabilityManager, db.dataManager)
+	t.Cleanup(db.close)
+	return db
+}
+
+// close 关闭数据库, 将所有的修改写回磁盘
+func (db *testDB) close() {
+	if db.dataManager != nil {
+		db.dataManager.Close()
+		db.transactionManager.Close()
+		db.dataManager = nil
+	}
+}
+
+// reopen 关闭并重新打开数据库
+func (db *testDB) reopen() {
+	db.close()
+	db.transactionManager = tm.Open(db.path)
+	db.dataManager = dm.Open(db.path, _TEST_MEM, db.transactionManager)
+	serializabilityManager := sm.NewSerializabilityManager(db.transactionManager, db.dataManager)
+	db.tableManager = Open(db.path, db.transactionManager, serializabilityManager, db.dataManager)
+}
+
+// begin 开始一个读提交的事务
+func (db *testDB) begin() tm.TransactionID {
+	xid, _ := db.tableManager.Begin(&statement.Begin{})
+	return xid
+}
+
+func (db *testDB) commit(xid tm.TransactionID) {
+	_, err := db.tableManager.Commit(xid)
+	if err != nil {
+		db.t.Fatal(err)
+	}
+}
+
+// exec 在xid中执行一条非事务控制类的语句
+func (db *testDB) exec(xid tm.TransactionID, stat string) (string, error) {
+	var result []byte
+	var err error
+	switch st := parse(db.t, stat).(type) {
+	case *statement.Show:
+		result = db.tableManager.Show(xid)
+	case *statement.Create:
+		result, err = db.tableManager.Create(xid, st)
+	case *statement.Drop:
+		result, err = db.tableManager.Drop(xid, st)
+	case *statement.CreateIndex:
+		result, err = db.tableManager.CreateIndex(xid, st)
+	case *statement.DropIndex:
+		result, err = db.tableManager.DropIndex(xid, st)
+	case *statement.Insert:
+		result, err = db.tableManager.Insert(xid, st)
+	case *statement.Read:
+		result, err = db.tableManager.Read(xid, st)
+	case *statement.Update:
+		result, err = db.tableManager.Update(xid, st)
+	case *statement.Delete:
+		result, err = db.tableManager.Delete(xid, st)
+	case *statement.Vacuum:
+		result, err = db.tableManager.Vacuum(xid, st)
+	case *statement.Verify:
+		result, err = db.tableManager.Verify(xid, st)
+	default:
+		db.t.Fatalf("%s: unsupported statement", stat)
+	}
+	return string(result), err
+}
+
+// mustExec 在xid中执行stat, 出错时结束测试
+func (db *testDB) mustExec(xid tm.TransactionID, stat string) string {
+	result, err := db.exec(xid, stat)
+	if err != nil {
+		db.t.Fatalf("%s: %v", stat, err)
+	}
+	return result
+}
+
+// run 在一个单独的事务中执行stat, 成功则提交, 失败则回滚
+func (db *testDB) run(stat string) (string, error) {
+	xid := db.begin()
+	result, err := db.exec(xid, stat)
+	if err != nil {
+		db.tableManager.Abort(xid)
+		return "", err
+	}
+	_, err = db.tableManager.Commit(xid)
+	return result, err
+}
+
+// mustRun 在一个单独的事务中执行stats, 出错时结束测试
+func (db *testDB) mustRun(stats ...string) string {
+	var result string
+	for _, stat := range stats {
+		var err error
+		result, err = db.run(stat)
+		if err != nil {
+			db.t.Fatalf("%s: %v", stat, err)
+		}
+	}
+	return result
+}
+
+// parse 解析stat, 出错时结束测试
+func parse(t *testing.T, stat string) interface{} {
+	result, err := statement.Parse([]byte(stat))
+	if err != nil {
+		t.Fatalf("%s: %v", stat, err)
+	}
+	return result
+}
+
+// TestCreateIndexOnCreatedTable 检查在创建表的事务中为它建立索引, 该表和之后创建的表的记录被立即重新写入,
+// 提交时不会被当作修改过的表再次写入, 提交并重新打开后表和索引都只有一份.
+func TestCreateIndexOnCreatedTable(t *testing.T) {
+	db := openTestDB(t)
+	xid := db.begin()
+	db.mustExec(xid, "create table t a int64, b int64 (index a)")
+	db.mustExec(xid, "create table s c int64 (index c)")
+	db.mustExec(xid, "insert into t values (1, 10), (2, 20)")
+	db.mustExec(xid, "create index on t (b)")
+	db.mustExec(xid, "create index on t (a, b)")
+	if altered := db.tableManager.alteredTable[xid]; len(altered) != 0 {
+		t.Errorf("got %d altered tables, expected 0", len(altered))
+	}
+	created := db.tableManager.transactionIDTable[xid]
+	selfT, selfS := created[0].SelfUUID, created[1].SelfUUID
+	db.commit(xid)
+	if db.tableManager.tableCacher["t"].SelfUUID != selfT || db.tableManager.tableCacher["s"].SelfUUID != selfS {
+		t.Errorf("created tables were persisted again at commit")
+	}
+
+	expected := map[string]bool{
+		"{t: (a, int64, Index), (b, int64, Index), Index a_b(a, b)}": true,
+		"{s: (c, int64, Index)}": true,
+	}
+	for i := 0; i < 2; i++ {
+		show := strings.Split(strings.TrimSuffix(db.mustRun("show"), "\n"), "\n")
+		if len(show) != len(expected) || expected[show[0]] == false || expected[show[1]] == false {
+			t.Errorf("reopened=%v: got %v", i > 0, show)
+		}
+		if result := db.mustRun("read * from t where b = 20"); result != "[2, 20]\n" {
+			t.Errorf("reopened=%v: got %s", i > 0, result)
+		}
+		if result := db.mustRun("verify t"); result != "t: ok\n" {
+			t.Errorf("reopened=%v: got %s", i > 0, result)
+		}
+		db.reopen()
+	}
+}
+
+// TestCreateTableTransactional 检查新建的表在提交前只对创建它的事务可见, 回滚后不会留下任何记录,
+// 提交后对其他事务可见, 并且在重新打开后仍然存在.
+func TestCreateTableTransactional(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun("create table base k int64 (index k)")
+
+	xid := db.begin()
+	db.mustExec(xid, "create table t k int64 (index k)")
+	db.mustExec(xid, "insert into t values (1)")
+	other := db.begin()
+	if _, err := db.exec(other, "read * from t"); err != ErrNoThatTable {
+		t.Errorf("other transaction: got %v, expected %v", err, ErrNoThatTable)
+	}
+	db.tableManager.Abort(other)
+	db.tableManager.Abort(xid)
+
+	if _, err := db.run("read * from t"); err != ErrNoThatTable {
+		t.Errorf("after abort: got %v, expected %v", err, ErrNoThatTable)
+	}
+	db.reopen()
+	if show := db.mustRun("show"); show != "{base: (k, int64, Index)}\n" {
+		t.Errorf("after abort and reopen: got %s", show)
+	}
+
+	xid = db.begin()
+	db.mustExec(xid, "create table t k int64 (index k)")
+	db.mustExec(xid, "insert into t values (2)")
+	if _, err := db.exec(xid, "create table t k int64 (index k)"); err != ErrDuplicatedTable {
+		t.Errorf("duplicated: got %v, expected %v", err, ErrDuplicatedTable)
+	}
+	db.mustExec(xid, "create table gone k int64 (index k)")
+	db.mustExec(xid, "drop table gone")
+	db.commit(xid)
+
+	for i := 0; i < 2; i++ {
+		if result := db.mustRun("read * from t"); result != "[2]\n" {
+			t.Errorf("reopened=%v: got %s", i > 0, result)
+		}
+		if _, err := db.run("read * from gone"); err != ErrNoThatTable {
+			t.Errorf("reopened=%v: got %v, expected %v", i > 0, err, ErrNoThatTable)
+		}
+		if _, err := db.run("create table t k int64 (index k)"); err != ErrDuplicatedTable {
+			t.Errorf("reopened=%v: got %v, expected %v", i > 0, err, ErrDuplicatedTable)
+		}
+		db.reopen()
+	}
+}
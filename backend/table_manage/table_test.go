@@ -0,0 +1,237 @@
+package table_manage
+
+import (
+	sm "fansDB/backend/version_manage"
+	"strings"
+	"testing"
+	"time"
+)
+
+// _BLOCK_TIMEOUT 为判断一个操作是否在等待的时间
+const _BLOCK_TIMEOUT = 100 * time.Millisecond
+
+// TestUniqueDuplicate 检查唯一的字段和主键不能重复, NULL不会冲突
+func TestUniqueDuplicate(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table u id int64 primary key, name string unique, n int64",
+		"insert into u values (1, 'a', 1), (2, null, 2), (3, null, 3)",
+	)
+
+	tests := []struct {
+		stat     string
+		expected error
+	}{
+		{"insert into u values (1, 'b', 9)", ErrDuplicatedKey},
+		{"insert into u values (4, 'a', 9)", ErrDuplicatedKey},
+		{"insert into u values (4, 'd', 4), (5, 'd', 5)", ErrDuplicatedKey},
+		{"update u set id = 1 where id = 2", ErrDuplicatedKey},
+		{"insert into u values (4, null, 4)", nil},
+		{"update u set name = 'a' where id = 1", nil},
+	}
+	for _, test := range tests {
+		_, err := db.run(test.stat)
+		if err != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.stat, err, test.expected)
+		}
+	}
+
+	expected := "[1, a, 1]\n[2, NULL, 2]\n[3, NULL, 3]\n[4, NULL, 4]\n"
+	if result := db.mustRun("read * from u order by id"); result != expected {
+		t.Errorf("got\n%s\nexpected\n%s", result, expected)
+	}
+}
+
+// TestUniqueConcurrentInsert 检查写入相同值的事务会等待先写入的事务结束,
+// 先写入的事务提交时返回ErrDuplicatedKey, 回滚时则插入成功.
+func TestUniqueConcurrentInsert(t *testing.T) {
+	for _, commit := range []bool{true, false} {
+		db := openTestDB(t)
+		db.mustRun("create table u id int64 primary key, n int64")
+
+		first := db.begin()
+		db.mustExec(first, "insert into u values (1, 1)")
+
+		second := db.begin()
+		done := make(chan error, 1)
+		go func() {
+			_, err := db.exec(second, "insert into u values (1, 2)")
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("commit=%v: insert did not wait, got %v", commit, err)
+		case <-time.After(_BLOCK_TIMEOUT):
+		}
+
+		if commit {
+			db.commit(first)
+		} else {
+			db.tableManager.Abort(first)
+		}
+		err := <-done
+		if commit && err != ErrDuplicatedKey {
+			t.Errorf("after commit: got %v, expected %v", err, ErrDuplicatedKey)
+		}
+		if commit == false && err != nil {
+			t.Errorf("after abort: got %v, expected nil", err)
+		}
+		db.tableManager.Abort(second)
+	}
+}
+
+// TestUniqueDeadlock 检查两个事务互相等待对方写入的值时, 后等待的事务被自动撤销, 另一个事务继续执行
+func TestUniqueDeadlock(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun("create table u id int64 primary key")
+
+	first, second := db.begin(), db.begin()
+	db.mustExec(first, "insert into u values (1)")
+	db.mustExec(second, "insert into u values (2)")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := db.exec(first, "insert into u values (2)")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("insert did not wait, got %v", err)
+	case <-time.After(_BLOCK_TIMEOUT):
+	}
+
+	_, err := db.exec(second, "insert into u values (1)")
+	if err != sm.ErrCannotSR {
+		t.Fatalf("got %v, expected %v", err, sm.ErrCannotSR)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("first transaction: %v", err)
+	}
+	db.tableManager.Abort(second)
+	db.commit(first)
+
+	expected := "[1]\n[2]\n"
+	if result := db.mustRun("read * from u order by id"); result != expected {
+		t.Errorf("got\n%s\nexpected\n%s", result, expected)
+	}
+}
+
+// TestUpdateRollback 检查多行的update在后面的行出错时, 已经更新的行被恢复, 索引中也不会残留新版本
+func TestUpdateRollback(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table u id int64 primary key, name string unique, n int64",
+		"insert into u values (1, 'a', 1), (2, 'b', 0), (3, 'c', 3)",
+	)
+
+	xid := db.begin()
+	tests := []struct {
+		stat     string
+		expected error
+	}{
+		{"update u set name = 'z' where id >= 1", ErrDuplicatedKey},
+		{"update u set n = 6 / n where id >= 1", ErrDivideByZero},
+		{"update u set id = id + 1 where id <= 2", ErrDuplicatedKey},
+	}
+	for _, test := range tests {
+		_, err := db.exec(xid, test.stat)
+		if err != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.stat, err, test.expected)
+		}
+	}
+
+	expected := "[1, a, 1]\n[2, b, 0]\n[3, c, 3]\n"
+	if result := db.mustExec(xid, "read * from u order by id"); result != expected {
+		t.Errorf("got\n%s\nexpected\n%s", result, expected)
+	}
+	if result := db.mustExec(xid, "read * from u where name = 'a'"); result != "[1, a, 1]\n" {
+		t.Errorf("got %s", result)
+	}
+	if result := db.mustExec(xid, "update u set name = 'z' where id = 1"); result != "Update 1" {
+		t.Errorf("got %s", result)
+	}
+	db.commit(xid)
+
+	expected = "[1, z, 1]\n[2, b, 0]\n[3, c, 3]\n"
+	if result := db.mustRun("read * from u order by id"); result != expected {
+		t.Errorf("got\n%s\nexpected\n%s", result, expected)
+	}
+	if result := db.mustRun("verify u"); result != "u: ok\n" {
+		t.Errorf("got %s", result)
+	}
+}
+
+// TestReadSelectedColumns 检查read只返回选择的字段, 按选择的顺序排列, 同一字段可以被选择多次
+func TestReadSelectedColumns(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table s a int64, b string, c int64 (index a)",
+		"insert into s values (1, 'x', 10), (2, null, 20)",
+	)
+
+	tests := []struct {
+		stat     string
+		expected string
+	}{
+		{"read * from s order by a", "[1, x, 10]\n[2, NULL, 20]\n"},
+		{"read c from s order by a", "[10]\n[20]\n"},
+		{"read c, a from s order by a", "[10, 1]\n[20, 2]\n"},
+		{"read b, b from s where a = 2", "[NULL, NULL]\n"},
+		{"read a from s where c = 30", ""},
+	}
+	for _, test := range tests {
+		if result := db.mustRun(test.stat); result != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.stat, result, test.expected)
+		}
+	}
+	if _, err := db.run("read a, d from s"); err != ErrNoThatField {
+		t.Errorf("got %v, expected %v", err, ErrNoThatField)
+	}
+}
+
+// TestInsertRollback 检查多行的insert在某一行出错时, 同一语句中已经插入的行被撤销, 索引中也不会残留;
+// 给出字段列表时, 未给出的字段取默认值.
+func TestInsertRollback(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun("create table r id int64 primary key, name string unique, n int64 not null default 7")
+
+	xid := db.begin()
+	if result := db.mustExec(xid, "insert into r values (1, 'a', 1)"); result != "Insert 1" {
+		t.Errorf("got %s", result)
+	}
+	tests := []struct {
+		stat     string
+		expected error
+	}{
+		{"insert into r values (2, 'b', 2), (3, 'c', 3), (1, 'd', 4)", ErrDuplicatedKey},
+		{"insert into r values (2, 'b', 2), (3, 'b', 3)", ErrDuplicatedKey},
+		{"insert into r (id, name) values (2, 'b'), (3, 'a')", ErrDuplicatedKey},
+		{"insert into r values (2, 'b', 2), (3, 'c', null)", ErrNotNull},
+		{"insert into r values (2, 'b', 2), (3, 'c')", ErrInvalidValues},
+		{"insert into r (id, nope) values (2, 'b')", ErrNoThatField},
+	}
+	for _, test := range tests {
+		_, err := db.exec(xid, test.stat)
+		if err != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.stat, err, test.expected)
+		}
+	}
+	for _, stat := range []string{"read * from r order by id", "read * from r where id = 2", "read * from r where name = 'b'"} {
+		if result := db.mustExec(xid, stat); strings.Contains(result, "[2, ") {
+			t.Errorf("%s: got %s", stat, result)
+		}
+	}
+
+	if result := db.mustExec(xid, "insert into r (name, id) values ('b', 2), ('c', 3)"); result != "Insert 2" {
+		t.Errorf("got %s", result)
+	}
+	db.commit(xid)
+
+	expected := "[1, a, 1]\n[2, b, 7]\n[3, c, 7]\n"
+	if result := db.mustRun("read * from r order by id"); result != expected {
+		t.Errorf("got\n%s\nexpected\n%s", result, expected)
+	}
+	if result := db.mustRun("verify r"); result != "r: ok\n" {
+		t.Errorf("got %s", result)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+	verify.go 实现了verify语句, 检查表的rows和各个索引是否正确:
+		1. 每个索引的结构, 见index_manage.Verify;
+		2. 索引中的每个entry都指向rows中的版本, 且它的key与该版本的内容一致;
+		3. 对当前事务可见的每一行, 都能通过每个索引找到, NULL不在字段的索引中.
+	索引中包括所有版本的entry, 而rows在索引之前被写入, 在索引之前被vacuum删除,
+	所以先读出索引, 再读出rows, 此时索引中不在rows中的只能是已经被vacuum删除的版本.
+	检查可以和其他事务的读写并发进行.
+	索引中的uuid被假定为有效的dataitem地址, DM无法判断一个任意的uuid是否指向某个dataitem.
+*/
+package table_manage
+
+import (
+	"bytes"
+	im "fansDB/backend/index_manage"
+	tm "fansDB/backend/transaction_manage"
+	"fansDB/backend/utils"
+	"fmt"
+	"sort"
+)
+
+const (
+	_MAX_VERIFY_REPORT = 100 // 每张表最多报告的问题数
+)
+
+// verifiedIndex 为一个需要检查的索引, 以及从中读出的各个uuid的key.
+// 字段的索引以8字节的大端序作为key(见im.UUIDToKey), 和indexKey一致.
+type verifiedIndex struct {
+	name string
+	ix   im.Index
+	w    indexWriter
+	keys map[utils.UUID][][]byte
+}
+
+// verifyReport 记录了一张表的检查中发现的问题
+type verifyReport struct {
+	problems []string
+}
+
+func (r *verifyReport) add(format string, args ...interface{}) {
+	if len(r.problems) < _MAX_VERIFY_REPORT {
+		r.problems = append(r.problems, fmt.Sprintf(format, args...))
+	}
+}
+
+// Verify 在xid中检查该表, 返回发现的问题, 见verify.go
+func (t *table) Verify(xid tm.TransactionID) ([]string, error) {
+	report := &verifyReport{}
+	var indexes []*verifiedIndex
+	for _, f := range t.fields {
+		if f.IsIndexed() {
+			indexes = append(indexes, &verifiedIndex{name: f.FName, ix: f.idx, w: f})
+		}
+	}
+	for _, ix := range t.indexes {
+		indexes = append(indexes, &verifiedIndex{name: ix.Name, ix: ix.bt, w: ix})
+	}
+
+	if t.rowTree != nil {
+		err := verifyIndex(report, "rows", t.rowTree)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, vi := range indexes {
+		err := verifyIndex(report, "index "+vi.name, vi.ix)
+		if err != nil {
+			return nil, err
+		}
+		vi.keys, err = readIndex(report, vi)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	uuids, err := t.scanAll()
+	if err != nil {
+		return nil, err
+	}
+	rows := make(map[utils.UUID]bool, len(uuids))
+	for _, uuid := range uuids {
+		rows[uuid] = true
+	}
+
+	sm := t.TableManager.SerializabilityManager
+	for _, vi := range indexes {
+		entries := make([]utils.UUID, 0, len(vi.keys))
+		for uuid := range vi.keys {
+			entries = append(entries, uuid)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i] < entries[j]
+		})
+		for _, uuid := range entries {
+			keys := vi.keys[uuid]
+			if t.rowTree != nil && rows[uuid] == false {
+				dead, err := sm.IsDead(uuid)
+				if err != nil {
+					return nil, err
+				}
+				if dead == false {
+					report.add("index %s: entry %d is not in rows", vi.name, uuid)
+				}
+				continue
+			}
+			raw, ok, err := sm.ReadVersion(uuid)
+			if err != nil {
+				return nil, err
+			}
+			if ok == false { // 在恢复时被清除的entry
+				continue
+			}
+			key, indexed := vi.w.indexKey(t.parseEntry(raw))
+			if indexed == false || len(keys) != 1 || bytes.Equal(keys[0], key) == false {
+				report.add("index %s: entry %d does not match its row", vi.name, uuid)
+			}
+		}
+	}
+
+	for _, uuid := range uuids {
+		raw, ok, err := sm.Read(xid, uuid)
+		if err != nil {
+			return nil, err
+		}
+		if ok == false {
+			continue
+		}
+		e := t.parseEntry(raw)
+		for _, vi := range indexes {
+			key, indexed := vi.w.indexKey(e)
+			if indexed == false || containsKey(vi.keys[uuid], key) {
+				continue
+			}
+			found, err := searchIndex(vi.ix, key, uuid)
+			if err != nil {
+				return nil, err
+			}
+			if found == false {
+				report.add("index %s: row %d is not reachable", vi.name, uuid)
+			}
+		}
+	}
+	return report.problems, nil
+}
+
+// searchIndex 在索引中查找(key, uuid).
+// 读出索引之后才提交的行也可能对xid可见, 它们不在读出的索引中, 需要重新查找.
+func searchIndex(ix im.Index, key []byte, uuid utils.UUID) (bool, error) {
+	var uuids []utils.UUID
+	var err error
+	if bt, ok := ix.(im.BPlusTree); ok {
+		uuids, err = bt.SearchKeyRange(key, key)
+	} else {
+		uuids, err = ix.Search(im.KeyToUUID(key))
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, tmp := range uuids {
+		if tmp == uuid {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// verifyIndex 检查一个索引的结构, 将问题加入report
+func verifyIndex(report *verifyReport, name string, ix im.Index) error {
+	result, err := im.Verify(ix)
+	if err != nil {
+		return err
+	}
+	for _, violation := range result.Violations {
+		report.add("%s: %s", name, violation)
+	}
+	return nil
+}
+
+// readIndex 读出索引中所有的entry, 按uuid记录它们的key
+func readIndex(report *verifyReport, vi *verifiedIndex) (map[utils.UUID][][]byte, error) {
+	keys := make(map[utils.UUID][][]byte)
+	handle := func(key []byte, uuid utils.UUID) (bool, error) {
+		if containsKey(keys[uuid], key) {
+			report.add("index %s: entry %d appears more than once", vi.name, uuid)
+		}
+		keys[uuid] = append(keys[uuid], key)
+		return true, nil
+	}
+	var err error
+	if bt, ok := vi.ix.(im.BPlusTree); ok {
+		err = bt.ScanKey(nil, nil, false, handle)
+	} else {
+		err = vi.ix.Scan(0, utils.INF, false, func(key, uuid utils.UUID) (bool, error) {
+			return handle(im.UUIDToKey(key), uuid)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
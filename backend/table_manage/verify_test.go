@@ -0,0 +1,118 @@
+package table_manage
+
+import (
+	im "fansDB/backend/index_manage"
+	"fansDB/backend/utils"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// corruptedTable 创建表t并写入几行, 返回t和其中a = 2的行
+func corruptedTable(t *testing.T, db *testDB) (*table, utils.UUID, entry) {
+	db.mustRun(
+		"create table t a int64, b string (index a b)",
+		"insert into t values (1, 'x'), (2, 'y'), (3, 'z')",
+		"create index on t (a, b)",
+	)
+	tb := db.tableManager.tableCacher["t"]
+	uuids, err := tb.scanAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uuid := range uuids {
+		raw, ok, err := tb.TableManager.SerializabilityManager.ReadVersion(uuid)
+		if err != nil || ok == false {
+			t.Fatalf("read %d: %v", uuid, err)
+		}
+		e := tb.parseEntry(raw)
+		if e["a"] == int64(2) {
+			return tb, uuid, e
+		}
+	}
+	t.Fatal("row a = 2 not found")
+	return nil, utils.NilUUID, nil
+}
+
+// expectProblem 检查problems中表name有包含substr的问题
+func expectProblem(t *testing.T, problems map[string][]string, name, substr string) {
+	for _, problem := range problems[name] {
+		if strings.Contains(problem, substr) {
+			return
+		}
+	}
+	t.Errorf("got problems %v, expected one of %s containing %q", problems, name, substr)
+}
+
+func TestVerifyHealthyTable(t *testing.T) {
+	db := openTestDB(t)
+	corruptedTable(t, db)
+
+	xid := db.begin()
+	problems, err := db.tableManager.VerifyAll(xid)
+	db.tableManager.Abort(xid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("got problems %v", problems)
+	}
+	if result := db.mustRun("verify"); result != "t: ok\n" {
+		t.Errorf("got %s", result)
+	}
+}
+
+// TestVerifyMissingEntry 检查从字段的索引中删除一行的entry后, 该行被报告为无法通过索引找到
+func TestVerifyMissingEntry(t *testing.T) {
+	db := openTestDB(t)
+	tb, uuid, e := corruptedTable(t, db)
+
+	f := tb.fieldByName("a")
+	key, _ := f.indexKey(e)
+	ok, err := f.idx.(im.BPlusTree).DeleteKey(key, uuid)
+	if err != nil || ok == false {
+		t.Fatalf("delete entry: %v, %v", ok, err)
+	}
+
+	xid := db.begin()
+	problems, err := db.tableManager.VerifyAll(xid)
+	db.tableManager.Abort(xid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectProblem(t, problems, "t", fmt.Sprintf("index a: row %d is not reachable", uuid))
+	if len(problems) != 1 {
+		t.Errorf("got problems %v, expected only t", problems)
+	}
+}
+
+// TestVerifyMismatchedEntry 检查组合索引中key与行的内容不一致的entry被报告, 且verify语句输出该问题
+func TestVerifyMismatchedEntry(t *testing.T) {
+	db := openTestDB(t)
+	tb, uuid, e := corruptedTable(t, db)
+
+	ix := tb.indexByName("a_b")
+	key, _ := ix.indexKey(e)
+	ok, err := ix.bt.DeleteKey(key, uuid)
+	if err != nil || ok == false {
+		t.Fatalf("delete entry: %v, %v", ok, err)
+	}
+	e["b"] = "w"
+	key, _ = ix.indexKey(e)
+	if err := ix.bt.InsertKey(key, uuid); err != nil {
+		t.Fatal(err)
+	}
+
+	xid := db.begin()
+	problems, err := db.tableManager.VerifyAll(xid)
+	db.tableManager.Abort(xid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectProblem(t, problems, "t", fmt.Sprintf("index a_b: entry %d does not match its row", uuid))
+
+	result := db.mustRun("verify t")
+	if strings.Contains(result, "index a_b: entry") == false || strings.Contains(result, "t: ok") {
+		t.Errorf("got %s", result)
+	}
+}
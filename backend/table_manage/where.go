@@ -0,0 +1,488 @@
+/*
+	where.go 实现了where表达式树的求值, 以及根据表达式树选择索引.
+
+	对每个有索引的字段, 都尝试计算出一组key的区间, 使得满足where的entry一定落在这些区间内:
+	作用在该字段上的简单表达式, 其区间由field.CalExp给出, 作用在其他字段上的则无法确定区间;
+	and取两边区间的交集, 如果只有一边能确定区间, 则取那一边;
+	or取两边区间的并集, 只要有一边无法确定, 结果就无法确定;
+	not无法确定区间.
+	在能确定区间的字段中, 选出区间总宽度最小的那个来查找; 如果都无法确定, 则扫描全表.
+	hash索引只按key等值查找, 所以只有区间都是单点(即"="和"in")时才使用它.
+	无论以哪种方式取得的entry, 最后都会经过matchWhere的过滤, 所以区间只需要覆盖全部结果即可.
+
+	对组合索引, 按字段的顺序依次计算各字段的区间: 如果某个字段的区间都是单点, 则用这些点扩展key的前缀,
+	继续计算下一个字段; 否则以该字段的区间结束, 如果该字段无法确定区间, 则以前缀本身结束.
+	被确定了区间的字段数最多的组合索引, 如果确定了至少两个字段, 或者没有字段的索引能确定区间, 就用它来查找.
+
+	求值使用三值逻辑: 和NULL的比较的结果为unknown, not unknown仍为unknown,
+	and和or按照false和true优先的规则计算. 只有结果为true的entry满足where.
+	NULL不在索引中, 和它的比较都不为true, 所以通过区间查找不会漏掉结果;
+	is null和is not null则无法确定区间.
+*/
+package table_manage
+
+import (
+	"errors"
+	im "fansDB/backend/index_manage"
+	statement "fansDB/backend/parser"
+	"fansDB/backend/utils"
+	"sort"
+)
+
+var (
+	ErrInvalidLogOP = errors.New("Invalid logic operation.")
+)
+
+const (
+	_MAX_INDEX_PREFIXES = 64 // 查找组合索引时, 单点扩展出的前缀的最大数量
+)
+
+// keyRange 表示索引上的一个闭区间[left, right]
+type keyRange struct {
+	left, right utils.UUID
+}
+
+// bytesRange 表示组合索引上的一个闭区间[left, right], right为nil表示没有上界
+type bytesRange struct {
+	left, right []byte
+}
+
+// parseWhere 对where语句进行解析, 返回可能满足where的entry的uuid.
+// 返回的结果仍需通过matchWhere过滤.
+func (t *table) parseWhere(where *statement.Where) (cursor, error) {
+	if where == nil {
+		return t.scanCursor(), nil
+	}
+	err := t.checkWhere(where)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, ranges, err := t.chooseIndex(where)
+	if err != nil {
+		return nil, err
+	}
+	ix, ixRanges, columns, err := t.chooseCompositeIndex(where)
+	if err != nil {
+		return nil, err
+	}
+	var open []func() (im.Iterator, error)
+	if ix != nil && (columns >= 2 || fd == nil) {
+		for i := range ixRanges {
+			r := ixRanges[i]
+			open = append(open, func() (im.Iterator, error) {
+				return ix.Iterate(r.left, r.right, false)
+			})
+		}
+		return newTreeCursor(open...), nil
+	}
+	if fd == nil {
+		return t.scanCursor(), nil
+	}
+
+	for i := range ranges {
+		r := ranges[i]
+		open = append(open, func() (im.Iterator, error) {
+			return fd.Iterate(r.left, r.right, false)
+		})
+	}
+	return newTreeCursor(open...), nil
+}
+
+// checkWhere 检查where中的字段是否都存在
+func (t *table) checkWhere(where *statement.Where) error {
+	return walkWhere(where, func(exp *statement.SingleExp) error {
+		if t.fieldByName(exp.Field) == nil {
+			return ErrNoThatField
+		}
+		return nil
+	})
+}
+
+// walkWhere 对where中的每个简单表达式调用visit
+func walkWhere(where *statement.Where, visit func(exp *statement.SingleExp) error) error {
+	if where == nil {
+		return nil
+	}
+	if where.LogicOp == "" {
+		return visit(where.SingleExp)
+	}
+	err := walkWhere(where.Left, visit)
+	if err != nil {
+		return err
+	}
+	return walkWhere(where.Right, visit)
+}
+
+// chooseIndex 选出区间总宽度最小的有索引的字段, 以及它的区间.
+// 如果所有字段都无法确定区间, 则返回nil.
+func (t *table) chooseIndex(where *statement.Where) (*field, []keyRange, error) {
+	var best *field
+	var bestRanges []keyRange
+	var bestWidth uint64
+	for _, f := range t.fields {
+		if f.IsIndexed() == false {
+			continue
+		}
+		ranges, bounded, err := t.calRanges(f, where)
+		if err != nil {
+			return nil, nil, err
+		}
+		if bounded == false || (f.IsHashIndexed() && isPoints(ranges) == false) {
+			continue
+		}
+		width := rangesWidth(ranges)
+		if best == nil || width < bestWidth {
+			best, bestRanges, bestWidth = f, ranges, width
+		}
+	}
+	return best, bestRanges, nil
+}
+
+// chooseCompositeIndex 选出被确定了区间的字段数最多的组合索引, 返回它的区间和被确定的字段数.
+// 如果所有组合索引的第一个字段都无法确定区间, 则返回nil.
+func (t *table) chooseCompositeIndex(where *statement.Where) (*index, []bytesRange, int, error) {
+	var best *index
+	var bestRanges []bytesRange
+	var bestColumns int
+	for _, ix := range t.indexes {
+		ranges, columns, err := t.calIndexRanges(ix, where)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if columns > bestColumns {
+			best, bestRanges, bestColumns = ix, ranges, columns
+		}
+	}
+	return best, bestRanges, bestColumns, nil
+}
+
+// calIndexRanges 计算where在组合索引ix上对应的区间, columns为被确定了区间的字段数.
+func (t *table) calIndexRanges(ix *index, where *statement.Where) ([]bytesRange, int, error) {
+	prefixes := [][]byte{{}}
+	for i, fd := range ix.fields {
+		ranges, bounded, err := t.calRanges(fd, where)
+		if err != nil {
+			return nil, 0, err
+		}
+		if bounded == false {
+			var result []bytesRange
+			for _, p := range prefixes {
+				result = append(result, bytesRange{p, prefixEnd(p)})
+			}
+			return result, i, nil
+		}
+
+		points := len(prefixes)*len(ranges) <= _MAX_INDEX_PREFIXES
+		for _, r := range ranges {
+			if r.left != r.right {
+				points = false
+			}
+		}
+		if points == false {
+			var result []bytesRange
+			for _, p := range prefixes {
+				for _, r := range ranges {
+					result = append(result, bytesRange{appendKeyPart(p, r.left), prefixEnd(appendKeyPart(p, r.right))})
+				}
+			}
+			return result, i + 1, nil
+		}
+
+		var next [][]byte
+		for _, p := range prefixes {
+			for _, r := range ranges {
+				next = append(next, appendKeyPart(p, r.left))
+			}
+		}
+		prefixes = next
+	}
+
+	var result []bytesRange
+	for _, p := range prefixes {
+		result = append(result, bytesRange{p, p})
+	}
+	return result, len(ix.fields), nil
+}
+
+// calRanges 计算where在fd的索引上对应的区间, bounded为false表示无法确定区间.
+// 返回的区间已经排好序, 且互不相交.
+func (t *table) calRanges(fd *field, where *statement.Where) ([]keyRange, bool, error) {
+	switch where.LogicOp {
+	case "":
+		exp := where.SingleExp
+		if exp.Field != fd.FName || exp.CmpOp == "is null" || exp.CmpOp == "is not null" {
+			return nil, false, nil
+		}
+		if exp.CmpOp != "=" && exp.CmpOp != "in" && fd.IsKeyOrdered() == false {
+			return nil, false, nil
+		}
+		ranges, err := fd.CalExp(exp)
+		if err != nil {
+			return nil, false, err
+		}
+		return ranges, true, nil
+	case "and", "or":
+		l, lok, err := t.calRanges(fd, where.Left)
+		if err != nil {
+			return nil, false, err
+		}
+		r, rok, err := t.calRanges(fd, where.Right)
+		if err != nil {
+			return nil, false, err
+		}
+		if where.LogicOp == "or" {
+			if lok && rok {
+				return unionRanges(l, r), true, nil
+			}
+			return nil, false, nil
+		}
+		if lok && rok {
+			return intersectRanges(l, r), true, nil
+		} else if lok {
+			return l, true, nil
+		} else if rok {
+			return r, true, nil
+		}
+		return nil, false, nil
+	case "not":
+		return nil, false, nil
+	default:
+		return nil, false, ErrInvalidLogOP
+	}
+}
+
+// unionRanges 求两组区间的并集
+func unionRanges(a, b []keyRange) []keyRange {
+	all := make([]keyRange, 0, len(a)+len(b))
+	all = append(all, a...)
+	all = append(all, b...)
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].left < all[j].left
+	})
+
+	var result []keyRange
+	for _, r := range all {
+		if len(result) > 0 && r.left <= result[len(result)-1].right {
+			last := &result[len(result)-1]
+			if r.right > last.right {
+				last.right = r.right
+			}
+			continue
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+// intersectRanges 求两组区间的交集, a和b都需要有序且互不相交
+func intersectRanges(a, b []keyRange) []keyRange {
+	var result []keyRange
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		left, right := a[i].left, a[i].right
+		if b[j].left > left {
+			left = b[j].left
+		}
+		if b[j].right < right {
+			right = b[j].right
+		}
+		if left <= right {
+			result = append(result, keyRange{left, right})
+		}
+		if a[i].right < b[j].right {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// isPoints 判断区间是否都是单点
+func isPoints(ranges []keyRange) bool {
+	for _, r := range ranges {
+		if r.left != r.right {
+			return false
+		}
+	}
+	return true
+}
+
+// rangesWidth 返回区间的总宽度, 溢出时返回最大值
+func rangesWidth(ranges []keyRange) uint64 {
+	var width uint64
+	for _, r := range ranges {
+		w := uint64(r.right - r.left)
+		if width+w < width {
+			return uint64(utils.INF)
+		}
+		width += w
+	}
+	return width
+}
+
+// logic 为三值逻辑中的值
+type logic int8
+
+const (
+	_LOGIC_FALSE logic = iota
+	_LOGIC_TRUE
+	_LOGIC_UNKNOWN
+)
+
+func toLogic(b bool) logic {
+	if b {
+		return _LOGIC_TRUE
+	}
+	return _LOGIC_FALSE
+}
+
+// matchWhere 判断e是否满足where
+func (t *table) matchWhere(where *statement.Where, e entry) (bool, error) {
+	return evalWhere(where, func(exp *statement.SingleExp) (logic, error) {
+		return t.matchSingleExp(exp, e)
+	})
+}
+
+// evalWhere 对where求值, 其中的简单表达式由matchExp求值, 结果为true时返回true.
+func evalWhere(where *statement.Where, matchExp func(exp *statement.SingleExp) (logic, error)) (bool, error) {
+	if where == nil {
+		return true, nil
+	}
+	result, err := evalLogic(where, matchExp)
+	return result == _LOGIC_TRUE, err
+}
+
+func evalLogic(where *statement.Where, matchExp func(exp *statement.SingleExp) (logic, error)) (logic, error) {
+	switch where.LogicOp {
+	case "":
+		return matchExp(where.SingleExp)
+	case "and":
+		left, err := evalLogic(where.Left, matchExp)
+		if err != nil || left == _LOGIC_FALSE {
+			return _LOGIC_FALSE, err
+		}
+		right, err := evalLogic(where.Right, matchExp)
+		if err != nil || right == _LOGIC_FALSE {
+			return _LOGIC_FALSE, err
+		}
+		if left == _LOGIC_TRUE && right == _LOGIC_TRUE {
+			return _LOGIC_TRUE, nil
+		}
+		return _LOGIC_UNKNOWN, nil
+	case "or":
+		left, err := evalLogic(where.Left, matchExp)
+		if err != nil || left == _LOGIC_TRUE {
+			return left, err
+		}
+		right, err := evalLogic(where.Right, matchExp)
+		if err != nil || right == _LOGIC_TRUE {
+			return right, err
+		}
+		if left == _LOGIC_FALSE && right == _LOGIC_FALSE {
+			return _LOGIC_FALSE, nil
+		}
+		return _LOGIC_UNKNOWN, nil
+	case "not":
+		result, err := evalLogic(where.Left, matchExp)
+		if err != nil {
+			return _LOGIC_FALSE, err
+		}
+		switch result {
+		case _LOGIC_TRUE:
+			return _LOGIC_FALSE, nil
+		case _LOGIC_FALSE:
+			return _LOGIC_TRUE, nil
+		default:
+			return _LOGIC_UNKNOWN, nil
+		}
+	default:
+		return _LOGIC_FALSE, ErrInvalidLogOP
+	}
+}
+
+// matchSingleExp 判断e是否满足exp
+func (t *table) matchSingleExp(exp *statement.SingleExp, e entry) (logic, error) {
+	fd := t.fieldByName(exp.Field)
+	if fd == nil {
+		return _LOGIC_FALSE, ErrNoThatField
+	}
+	return matchValue(fd, e[fd.FName], exp)
+}
+
+// comparer 能将字符串转换为值, 并比较两个值的大小, 如field.
+type comparer interface {
+	StrToValue(valStr string) (interface{}, error)
+	Compare(v0, v1 interface{}) int
+}
+
+// matchValue 判断值v是否满足exp, v的类型由c决定, v为nil表示NULL.
+func matchValue(c comparer, v interface{}, exp *statement.SingleExp) (logic, error) {
+	switch exp.CmpOp {
+	case "is null":
+		return toLogic(v == nil), nil
+	case "is not null":
+		return toLogic(v != nil), nil
+	case "between":
+		if len(exp.Values) != 2 {
+			return _LOGIC_FALSE, ErrInvalidValues
+		}
+		low, err := c.StrToValue(exp.Values[0])
+		if err != nil {
+			return _LOGIC_FALSE, err
+		}
+		high, err := c.StrToValue(exp.Values[1])
+		if err != nil {
+			return _LOGIC_FALSE, err
+		}
+		if v == nil {
+			return _LOGIC_UNKNOWN, nil
+		}
+		return toLogic(c.Compare(v, low) >= 0 && c.Compare(v, high) <= 0), nil
+	case "in":
+		var values []interface{}
+		for _, value := range exp.Values {
+			tmp, err := c.StrToValue(value)
+			if err != nil {
+				return _LOGIC_FALSE, err
+			}
+			values = append(values, tmp)
+		}
+		if v == nil {
+			return _LOGIC_UNKNOWN, nil
+		}
+		for _, tmp := range values {
+			if c.Compare(v, tmp) == 0 {
+				return _LOGIC_TRUE, nil
+			}
+		}
+		return _LOGIC_FALSE, nil
+	}
+
+	tmp, err := c.StrToValue(exp.Value)
+	if err != nil {
+		return _LOGIC_FALSE, err
+	}
+	if v == nil {
+		return _LOGIC_UNKNOWN, nil
+	}
+
+	cmp := c.Compare(v, tmp)
+	switch exp.CmpOp {
+	case "=":
+		return toLogic(cmp == 0), nil
+	case ">":
+		return toLogic(cmp > 0), nil
+	case "<":
+		return toLogic(cmp < 0), nil
+	case ">=":
+		return toLogic(cmp >= 0), nil
+	case "<=":
+		return toLogic(cmp <= 0), nil
+	case "!=":
+		return toLogic(cmp != 0), nil
+	default:
+		return _LOGIC_FALSE, ErrInvalidValues
+	}
+}
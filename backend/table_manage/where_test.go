@@ -0,0 +1,208 @@
+package table_manage
+
+import (
+	statement "fansDB/backend/parser"
+	"fansDB/backend/utils"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestWhereSequentialScan 检查作用在没有索引的字段上的where通过扫描全表求值,
+// 以及和有索引的字段组合时, and仍可以通过索引查找, or则退化为扫描全表, 结果都相同.
+func TestWhereSequentialScan(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table s k int64, v int64, name string (index k)",
+		"insert into s values (1, 10, 'a'), (2, 20, 'b'), (3, 30, 'c'), (4, 20, 'd'), (5, 50, 'b')",
+	)
+
+	tests := []struct {
+		stat     string
+		expected string
+	}{
+		{"read k from s where v = 20 order by k", "[2]\n[4]\n"},
+		{"read k from s where v > 20 order by k", "[3]\n[5]\n"},
+		{"read k from s where name = 'b' order by k", "[2]\n[5]\n"},
+		{"read k from s where v = 99", ""},
+		{"read k from s where k > 1 and v = 20 order by k", "[2]\n[4]\n"},
+		{"read k from s where k = 1 or v = 50 order by k", "[1]\n[5]\n"},
+		{"read k from s where v < 30 and name = 'b'", "[2]\n"},
+	}
+	for _, test := range tests {
+		result, err := db.run(test.stat)
+		if err != nil {
+			t.Errorf("%s: %v", test.stat, err)
+		} else if result != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.stat, result, test.expected)
+		}
+	}
+
+	if _, err := db.run("read k from s where nope = 1"); err != ErrNoThatField {
+		t.Errorf("unknown field: got %v, expected %v", err, ErrNoThatField)
+	}
+	if result := db.mustRun("update s set v = 0 where v = 20", "read k from s where v = 0 order by k"); result != "[2]\n[4]\n" {
+		t.Errorf("after update: got %q", result)
+	}
+	if result := db.mustRun("delete from s where name = 'b'", "read k from s order by k"); result != "[1]\n[3]\n[4]\n" {
+		t.Errorf("after delete: got %q", result)
+	}
+}
+
+// TestWhereThreeValued 检查嵌套的and, or, not和括号按照三值逻辑求值:
+// 和NULL的比较为unknown, not unknown仍为unknown, 只有结果为true的行被选出.
+func TestWhereThreeValued(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table n k int64, a int64, b int64 (index k)",
+		"insert into n values (1, 1, null), (2, null, 1), (3, null, null), (4, 0, 0), (5, 1, 1)",
+	)
+
+	tests := []struct {
+		where    string
+		expected string
+	}{
+		{"a = 1 or b = 1", "[1]\n[2]\n[5]\n"},
+		{"a = 1 and b = 1", "[5]\n"},
+		{"not (a = 1)", "[4]\n"},
+		{"not not (a = 1)", "[1]\n[5]\n"},
+		{"not (a = 1 or b = 1)", "[4]\n"},
+		{"not (a = 1 and b = 1)", "[4]\n"},
+		{"not (a = 1 and b is null)", "[2]\n[4]\n[5]\n"},
+		{"a is null and (b = 1 or b is null)", "[2]\n[3]\n"},
+		{"(a = 1 or b = 1) and not (k = 5)", "[1]\n[2]\n"},
+		{"k >= 2 and (a is null or b = 0)", "[2]\n[3]\n[4]\n"},
+		{"k = 1 or k = 3 or k = 5", "[1]\n[3]\n[5]\n"},
+		{"k = 1 or (k = 2 and a = 1) or (k > 3 and not (b = 1))", "[1]\n[4]\n"},
+		{"(((k = 2)))", "[2]\n"},
+	}
+	for _, test := range tests {
+		stat := "read k from n where " + test.where + " order by k"
+		result, err := db.run(stat)
+		if err != nil {
+			t.Errorf("%s: %v", stat, err)
+		} else if result != test.expected {
+			t.Errorf("%s: got %q, expected %q", stat, result, test.expected)
+		}
+	}
+}
+
+// TestCalRangesBoundaries 检查>=, <=, !=, between和in在key的边界(最小和最大的int64)上计算出的区间
+func TestCalRangesBoundaries(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun("create table b k int64 (index k)")
+	tb := db.tableManager.tableCacher["b"]
+	fd := tb.fieldByName("k")
+
+	key := func(v int64) utils.UUID {
+		return utils.Int64ToKey(v)
+	}
+	tests := []struct {
+		where    string
+		expected []keyRange
+	}{
+		{"k < -9223372036854775808", nil},
+		{"k <= -9223372036854775808", []keyRange{{0, 0}}},
+		{"k > 9223372036854775807", nil},
+		{"k >= 9223372036854775807", []keyRange{{utils.INF, utils.INF}}},
+		{"k != -9223372036854775808", []keyRange{{1, utils.INF}}},
+		{"k != 9223372036854775807", []keyRange{{0, utils.INF - 1}}},
+		{"k != 0", []keyRange{{0, key(-1)}, {key(1), utils.INF}}},
+		{"k between 5 and 1", nil},
+		{"k between -1 and 1", []keyRange{{key(-1), key(1)}}},
+		{"k between 1 and 1", []keyRange{{key(1), key(1)}}},
+		{"k in (3, 1, 3)", []keyRange{{key(1), key(1)}, {key(3), key(3)}}},
+		{"k >= 1 and k <= 1", []keyRange{{key(1), key(1)}}},
+		{"k != 0 and k >= 0", []keyRange{{key(1), utils.INF}}},
+		{"k in (1, 2) or k between 2 and 4", []keyRange{{key(1), key(1)}, {key(2), key(4)}}},
+	}
+	for _, test := range tests {
+		read := parse(t, "read k from b where "+test.where).(*statement.Read)
+		ranges, ok, err := tb.calRanges(fd, read.Where)
+		if err != nil || ok == false {
+			t.Errorf("%s: got %v, %v", test.where, ok, err)
+		} else if reflect.DeepEqual(ranges, test.expected) == false {
+			t.Errorf("%s: got %v, expected %v", test.where, ranges, test.expected)
+		}
+	}
+}
+
+// TestCompareOperators 检查各个比较运算符通过索引查找和扫描全表得到相同的结果
+func TestCompareOperators(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table c k int64, v int64 (index k)",
+		"insert into c values (-9223372036854775808, -9223372036854775808), (-1, -1), (0, 0), (1, 1), (2, 2), "+
+			"(9223372036854775807, 9223372036854775807), (null, null)",
+	)
+
+	tests := []struct {
+		where    string
+		expected string
+	}{
+		{"F >= 1", "[1]\n[2]\n[9223372036854775807]\n"},
+		{"F <= -1", "[-9223372036854775808]\n[-1]\n"},
+		{"F <> 0", "[-9223372036854775808]\n[-1]\n[1]\n[2]\n[9223372036854775807]\n"},
+		{"F != 9223372036854775807 and F != -9223372036854775808", "[-1]\n[0]\n[1]\n[2]\n"},
+		{"F between -1 and 1", "[-1]\n[0]\n[1]\n"},
+		{"F between 2 and -2", ""},
+		{"F in (2, -1, 7, 2)", "[-1]\n[2]\n"},
+		{"F >= 9223372036854775807", "[9223372036854775807]\n"},
+		{"F < -9223372036854775808", ""},
+		{"not (F in (0, 1))", "[-9223372036854775808]\n[-1]\n[2]\n[9223372036854775807]\n"},
+	}
+	for _, test := range tests {
+		for _, f := range []string{"k", "v"} {
+			stat := "read v from c where " + strings.Replace(test.where, "F", f, -1) + " order by v"
+			result, err := db.run(stat)
+			if err != nil {
+				t.Errorf("%s: %v", stat, err)
+			} else if result != test.expected {
+				t.Errorf("%s: got %q, expected %q", stat, result, test.expected)
+			}
+		}
+	}
+}
+
+// TestStringPrefixKey 检查string的索引只用前8个字节作为key时, 前缀相同的值仍按完整的值比较,
+// 通过索引查找和扫描全表得到相同的结果.
+func TestStringPrefixKey(t *testing.T) {
+	db := openTestDB(t)
+	db.mustRun(
+		"create table p k int64, s string, v string (index k s)",
+		"insert into p values (1, 'abcdefgh', 'abcdefgh'), (2, 'abcdefghA', 'abcdefghA'), (3, 'abcdefghZ', 'abcdefghZ'), "+
+			"(4, 'abcdefg', 'abcdefg'), (5, 'abcdefgi', 'abcdefgi'), (6, '', ''), (7, null, null)",
+	)
+
+	tests := []struct {
+		where    string
+		expected string
+	}{
+		{"F = 'abcdefghA'", "[2]\n"},
+		{"F = 'abcdefghB'", ""},
+		{"F > 'abcdefgh'", "[2]\n[3]\n[5]\n"},
+		{"F >= 'abcdefghA'", "[2]\n[3]\n[5]\n"},
+		{"F < 'abcdefghZ'", "[1]\n[2]\n[4]\n[6]\n"},
+		{"F <= 'abcdefgh'", "[1]\n[4]\n[6]\n"},
+		{"F != 'abcdefghA'", "[1]\n[3]\n[4]\n[5]\n[6]\n"},
+		{"F between 'abcdefgh0' and 'abcdefghZ'", "[2]\n[3]\n"},
+		{"F in ('abcdefghZ', 'abcdefgh', 'x')", "[1]\n[3]\n"},
+		{"F = ''", "[6]\n"},
+	}
+	for _, test := range tests {
+		for _, f := range []string{"s", "v"} {
+			stat := "read k from p where " + strings.Replace(test.where, "F", f, -1) + " order by k"
+			result, err := db.run(stat)
+			if err != nil {
+				t.Errorf("%s: %v", stat, err)
+			} else if result != test.expected {
+				t.Errorf("%s: got %q, expected %q", stat, result, test.expected)
+			}
+		}
+	}
+
+	expected := "[7]\n[6]\n[4]\n[1]\n[2]\n[3]\n[5]\n"
+	if result := db.mustRun("read k from p order by s"); result != expected {
+		t.Errorf("order by s: got %q, expected %q", result, expected)
+	}
+}
@@ -111,10 +111,10 @@ func (tm *transactionManager) checkXIDCounter() {
 	}
 	//读取xid数目，即header，这里header只存了数目
 	tm.xidCounter = ParseTransactionID(tmp)
-	//理想状态下，最后一个xid位置。
-	lastXIDPosition, _ := xidPosition(tm.xidCounter)
+	//理想状态下，下一个xid的位置即为文件末尾。
+	end, _ := xidPosition(tm.xidCounter + 1)
 	//判断真实文件长度是否等于计算出来的文件长度
-	if lastXIDPosition+_XID_FIELD_SIZE != state.Size() {
+	if end != state.Size() {
 		panic(ErrBadXIDFile)
 	}
 
@@ -122,7 +122,7 @@ func (tm *transactionManager) checkXIDCounter() {
 
 //根据xid来获取位置
 func xidPosition(xid TransactionID) (int64, int) {
-	position := _XID_FILE_HEADER_SIZE + (xid-1)*_XID_FILE_HEADER_SIZE
+	position := _XID_FILE_HEADER_SIZE + (xid-1)*_XID_FIELD_SIZE
 	return int64(position), _XID_FIELD_SIZE
 }
 
@@ -160,8 +160,8 @@ func (t *transactionManager) updateTransactionState(xid TransactionID, state int
 //开启事务
 func (t *transactionManager) Begin() TransactionID {
 	t.counterLock.Lock()
-	defer t.counterLock.Lock()
-	xid := t.xidCounter
+	defer t.counterLock.Unlock()
+	xid := t.xidCounter + 1 // xid从1开始, 0为SUPER_TRANSACTION_ID
 	//更新事务状态，这里相当于追加
 	t.updateTransactionState(xid, _FIELD_TRAN_ACTIVE)
 	//更新头文件
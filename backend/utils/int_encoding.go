@@ -3,6 +3,7 @@ package utils
 import (
 	"bytes"
 	"encoding/binary"
+	"math"
 )
 
 func PutUint16(buf []byte, num uint16) {
@@ -20,7 +21,7 @@ func ParseUint16(raw []byte) uint16 {
 }
 
 func Uint16ToRaw(num uint16) []byte {
-	buf := make([]byte, 4)
+	buf := make([]byte, 2)
 	PutUint16(buf, num)
 	return buf
 }
@@ -107,3 +108,17 @@ func Uint64ToRaw(num uint64) []byte {
 	PutUint64(buf, num)
 	return buf
 }
+
+func PutFloat64(buf []byte, num float64) {
+	PutUint64(buf, math.Float64bits(num))
+}
+
+func ParseFloat64(raw []byte) float64 {
+	return math.Float64frombits(ParseUint64(raw))
+}
+
+func Float64ToRaw(num float64) []byte {
+	buf := make([]byte, 8)
+	PutFloat64(buf, num)
+	return buf
+}
@@ -0,0 +1,43 @@
+package utils
+
+import "math"
+
+/**
+ * 将各种类型的值转换为保持顺序的UUID, 用作B+树的key.
+ * 即对于a < b, 有XXXToKey(a) < XXXToKey(b).
+ */
+
+// Int64ToKey 翻转符号位, 使负数排在正数之前
+func Int64ToKey(num int64) UUID {
+	return UUID(uint64(num) ^ (1 << 63))
+}
+
+// Float64ToKey 对于非负数, 翻转符号位; 对于负数, 翻转所有位.
+// num不能为NaN, 且-0需要先转换为0.
+func Float64ToKey(num float64) UUID {
+	bits := math.Float64bits(num)
+	if bits&(1<<63) != 0 {
+		return UUID(^bits)
+	}
+	return UUID(bits | (1 << 63))
+}
+
+func BoolToKey(b bool) UUID {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// StrToPrefixKey 取str的前8个字节, 按大端序转换为UUID, 不足8个字节的在后面补0.
+// 对于a < b, 有StrToPrefixKey(a) <= StrToPrefixKey(b), 但不同的str可能有相同的key.
+func StrToPrefixKey(str string) UUID {
+	var key uint64
+	for i := 0; i < 8; i++ {
+		key <<= 8
+		if i < len(str) {
+			key |= uint64(str[i])
+		}
+	}
+	return UUID(key)
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"math"
+	"testing"
+)
+
+// TestInt64ToKey 检查Int64ToKey保持顺序, 且最小和最大的int64分别对应0和INF
+func TestInt64ToKey(t *testing.T) {
+	nums := []int64{math.MinInt64, math.MinInt64 + 1, -256, -1, 0, 1, 256, math.MaxInt64 - 1, math.MaxInt64}
+	for i := 1; i < len(nums); i++ {
+		if Int64ToKey(nums[i-1]) >= Int64ToKey(nums[i]) {
+			t.Errorf("key of %d >= key of %d", nums[i-1], nums[i])
+		}
+	}
+	if Int64ToKey(math.MinInt64) != 0 || Int64ToKey(math.MaxInt64) != INF {
+		t.Errorf("got keys %d, %d for the bounds", Int64ToKey(math.MinInt64), Int64ToKey(math.MaxInt64))
+	}
+}
+
+// TestFloat64ToKey 检查Float64ToKey对负数, 0, 非规格化数和无穷大都保持顺序.
+// -0和0的key不同, 所以调用者需要先将-0转换为0.
+func TestFloat64ToKey(t *testing.T) {
+	nums := []float64{
+		math.Inf(-1), -math.MaxFloat64, -1e10, -1.5, -1, -math.SmallestNonzeroFloat64,
+		0, math.SmallestNonzeroFloat64, 1, 1.5, 1e10, math.MaxFloat64, math.Inf(1),
+	}
+	for i := 1; i < len(nums); i++ {
+		if Float64ToKey(nums[i-1]) >= Float64ToKey(nums[i]) {
+			t.Errorf("key of %g >= key of %g", nums[i-1], nums[i])
+		}
+	}
+
+	negZero := math.Copysign(0, -1)
+	if Float64ToKey(negZero) == Float64ToKey(0) {
+		t.Errorf("-0 and 0 have the same key")
+	}
+	if Float64ToKey(negZero) <= Float64ToKey(-math.SmallestNonzeroFloat64) {
+		t.Errorf("key of -0 is not between the negative numbers and 0")
+	}
+}
+
+// TestBoolToKey 检查false排在true之前
+func TestBoolToKey(t *testing.T) {
+	if BoolToKey(false) >= BoolToKey(true) {
+		t.Errorf("key of false >= key of true")
+	}
+}
+
+// TestStrToPrefixKey 检查StrToPrefixKey不破坏顺序, 前8个字节相同的str有相同的key
+func TestStrToPrefixKey(t *testing.T) {
+	strs := []string{"", "\x00", "A", "a", "a\x00b", "ab", "abcdefgh", "abcdefgh\x00", "abcdefghZ", "abcdefgi", "b", "\xff\xff\xff\xff\xff\xff\xff\xff\xff"}
+	for i := 1; i < len(strs); i++ {
+		if StrToPrefixKey(strs[i-1]) > StrToPrefixKey(strs[i]) {
+			t.Errorf("key of %q > key of %q", strs[i-1], strs[i])
+		}
+	}
+
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{"", "\x00", true},
+		{"abcdefgh", "abcdefghZ", true},
+		{"abcdefghA", "abcdefghZ", true},
+		{"abcdefg", "abcdefgh", false},
+		{"a", "b", false},
+	}
+	for _, test := range tests {
+		if (StrToPrefixKey(test.a) == StrToPrefixKey(test.b)) != test.equal {
+			t.Errorf("%q and %q: expected equal keys to be %v", test.a, test.b, test.equal)
+		}
+	}
+	if StrToPrefixKey("\xff\xff\xff\xff\xff\xff\xff\xff") != INF {
+		t.Errorf("got key %d for the largest prefix", StrToPrefixKey("\xff\xff\xff\xff\xff\xff\xff\xff"))
+	}
+}
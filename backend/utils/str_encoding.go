@@ -1,9 +1,29 @@
 package utils
 
 import (
+	"encoding/hex"
+	"errors"
 	"strconv"
+	"strings"
+	"time"
 )
 
+var (
+	ErrInvalidBytes = errors.New("Bytes must be written as 0x followed by hex digits.")
+)
+
+const (
+	_TIMESTAMP_PRINT_LAYOUT = "2006-01-02 15:04:05.999999"
+)
+
+// 可以解析的timestamp格式, 秒之后可以带有小数部分, 没有时区的按UTC解析
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
 // StrToUUID 将str转换为UUID, 使用了一种简单的hash算法, 可能会有冲突.
 // 另外转换后的UUID将无序.
 func StrToUUID(str string) UUID {
@@ -43,6 +63,15 @@ func Int64ToStr(num int64) string {
 	return strconv.FormatInt(num, 10)
 }
 
+func StrToInt32(str string) (int32, error) {
+	i64, err := strconv.ParseInt(str, 10, 32)
+	return int32(i64), err
+}
+
+func Int32ToStr(num int32) string {
+	return strconv.FormatInt(int64(num), 10)
+}
+
 func StrToUint32(str string) (uint32, error) {
 	i64, err := strconv.ParseUint(str, 10, 32)
 	return uint32(i64), err
@@ -51,3 +80,78 @@ func StrToUint32(str string) (uint32, error) {
 func Uint32ToStr(num uint32) string {
 	return strconv.FormatUint(uint64(num), 10)
 }
+
+func StrToFloat64(str string) (float64, error) {
+	return strconv.ParseFloat(str, 64)
+}
+
+func Float64ToStr(num float64) string {
+	return strconv.FormatFloat(num, 'f', -1, 64)
+}
+
+func StrToBool(str string) (bool, error) {
+	return strconv.ParseBool(str)
+}
+
+func BoolToStr(b bool) string {
+	return strconv.FormatBool(b)
+}
+
+// StrToTimestamp 解析timestamp, 结果为UTC时间, 精确到微秒
+func StrToTimestamp(str string) (time.Time, error) {
+	var err error
+	for _, layout := range timestampLayouts {
+		var t time.Time
+		t, err = time.ParseInLocation(layout, str, time.UTC)
+		if err == nil {
+			return t.UTC().Truncate(time.Microsecond), nil
+		}
+	}
+	return time.Time{}, err
+}
+
+func TimestampToStr(t time.Time) string {
+	return t.UTC().Format(_TIMESTAMP_PRINT_LAYOUT)
+}
+
+// TimestampToMicro 返回t距1970-01-01 00:00:00 UTC的微秒数
+func TimestampToMicro(t time.Time) int64 {
+	return t.Unix()*1e6 + int64(t.Nanosecond()/1e3)
+}
+
+func MicroToTimestamp(micro int64) time.Time {
+	sec, usec := micro/1e6, micro%1e6
+	if usec < 0 {
+		sec, usec = sec-1, usec+1e6
+	}
+	return time.Unix(sec, usec*1e3).UTC()
+}
+
+// StrToBytes 解析0x开头的十六进制串
+func StrToBytes(str string) ([]byte, error) {
+	if strings.HasPrefix(str, "0x") == false && strings.HasPrefix(str, "0X") == false {
+		return nil, ErrInvalidBytes
+	}
+	b, err := hex.DecodeString(str[2:])
+	if err != nil {
+		return nil, ErrInvalidBytes
+	}
+	return b, nil
+}
+
+func BytesToStr(b []byte) string {
+	return "0x" + hex.EncodeToString(b)
+}
+
+func VarBytesToRaw(b []byte) []byte {
+	raw := Uint32ToRaw(uint32(len(b)))
+	raw = append(raw, b...)
+	return raw
+}
+
+func ParseVarBytes(raw []byte) ([]byte, int) {
+	length := ParseUint32(raw)
+	b := make([]byte, length)
+	copy(b, raw[4:4+length])
+	return b, int(length) + 4
+}
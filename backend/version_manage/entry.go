@@ -34,7 +34,7 @@ func newEntry(serializabilityManager *serializabilityManager, di dm.DataItem, uu
 // 通过uuid读取一个entry
 func LoadEntry(serializabilityManager *serializabilityManager, uuid utils.UUID) (*entry, bool, error) {
 	// 通过serializabilityManager中的dataitem读取uuid中的数据快
-	di, ok, err := serializabilityManager.DM.Read(uuid)
+	di, ok, err := serializabilityManager.DataManager.Read(uuid)
 	if err != nil {
 		return nil, false, err
 	}
@@ -93,3 +93,10 @@ func (e *entry) SetXMAX(transactionID tm.TransactionID) {
 	defer e.dataitem.After(transactionID)
 	tm.PutTransactionID(e.dataitem.Data()[_ENTRY_OF_XMAX:], transactionID)
 }
+
+// ClearXMAX 由transactionID清除XMAX, 撤销它对该版本的删除
+func (e *entry) ClearXMAX(transactionID tm.TransactionID) {
+	e.dataitem.Before()
+	defer e.dataitem.After(transactionID)
+	tm.PutTransactionID(e.dataitem.Data()[_ENTRY_OF_XMAX:], tm.SUPER_TRANSACTION_ID)
+}
@@ -14,8 +14,11 @@ type LockTable interface {
 	Remove(transactionID utils.UUID)
 }
 
+// uidSet 为一个事务占用的uid的集合, 用于O(1)地判断事务是否已经占用了某个uid
+type uidSet map[utils.UUID]struct{}
+
 type lockTable struct {
-	transactionID2UID map[utils.UUID]*list.List    // transactionID已经获得的资源uid
+	transactionID2UID map[utils.UUID]uidSet        // transactionID已经获得的资源uid
 	uid2TransactionID map[utils.UUID]utils.UUID    // uid被哪个transactionID获得
 	wait              map[utils.UUID]*list.List    // 表示有哪些transactionID在等待这个uid, wait和transactionID2UID应该是对偶关系
 	waitCh            map[utils.UUID]chan struct{} // 用于对等待队列进行恢复
@@ -25,7 +28,7 @@ type lockTable struct {
 
 func NewLockTable() *lockTable {
 	return &lockTable{
-		transactionID2UID: make(map[utils.UUID]*list.List),
+		transactionID2UID: make(map[utils.UUID]uidSet),
 		uid2TransactionID: make(map[utils.UUID]utils.UUID),
 		wait:              make(map[utils.UUID]*list.List),
 		waitCh:            make(map[utils.UUID]chan struct{}),
@@ -38,7 +41,7 @@ func (lt *lockTable) Add(transactionID, uid utils.UUID) (bool, chan struct{}) {
 	lt.lock.Lock()
 	defer lt.lock.Unlock()
 	// 如果已经包含该uid，直接返回ture
-	if isInList(lt.transactionID2UID, transactionID, uid) == true {
+	if _, ok := lt.transactionID2UID[transactionID][uid]; ok == true {
 		// 创建并返回chan
 		ch := make(chan struct{})
 		go func() {
@@ -51,7 +54,7 @@ func (lt *lockTable) Add(transactionID, uid utils.UUID) (bool, chan struct{}) {
 	if _, ok := lt.uid2TransactionID[uid]; ok == false {
 		//添加到uid2TransactionID和transactionID2UID
 		lt.uid2TransactionID[uid] = transactionID
-		putIntoList(lt.transactionID2UID, transactionID, uid)
+		lt.own(transactionID, uid)
 		// 获取资源成功
 		ch := make(chan struct{})
 		go func() {
@@ -77,19 +80,23 @@ func (lt *lockTable) Add(transactionID, uid utils.UUID) (bool, chan struct{}) {
 	return true, ch
 }
 
+// own 记录transactionID占用了uid, 这样它结束时uid会被释放
+func (lt *lockTable) own(transactionID, uid utils.UUID) {
+	set, ok := lt.transactionID2UID[transactionID]
+	if ok == false {
+		set = make(uidSet)
+		lt.transactionID2UID[transactionID] = set
+	}
+	set[uid] = struct{}{}
+}
+
 // Remove 移除一个transactionID,是否其占有资源
 func (lt *lockTable) Remove(transactionID utils.UUID) {
 	lt.lock.Lock()
 	defer lt.lock.Unlock()
 	//获取所有uuid，逐个释放，并同时等待该uuid的事务
-	l := lt.transactionID2UID[transactionID]
-	if l != nil { // 释放它占用的uid
-		for l.Len() > 0 {
-			e := l.Front()
-			v := l.Remove(e)
-			uid := v.(utils.UUID)
-			lt.selectNewXID(uid)
-		}
+	for uid := range lt.transactionID2UID[transactionID] { // 释放它占用的uid
+		lt.selectNewXID(uid)
 	}
 
 	delete(lt.transactionWait, transactionID)
@@ -158,7 +165,7 @@ func (lt *lockTable) selectNewXID(uid utils.UUID) {
 		} else {
 			// 将该uid指向transactionID
 			lt.uid2TransactionID[uid] = transactionID
-			//todo:t添加transactionid-uid
+			lt.own(transactionID, uid)
 			// 对transactionID进行回应
 			ch := lt.waitCh[transactionID]
 			// 删除该transactionID的等待通道
@@ -192,38 +199,13 @@ func removeFromList(listMap map[utils.UUID]*list.List, uid0, uid1 utils.UUID) {
 			l.Remove(e)
 			break
 		}
+		e = e.Next()
 	}
 	if l.Len() == 0 {
 		delete(listMap, uid0)
 	}
 }
 
-//
-// isInList
-//  @Description: 遍历所有的list，判断在list是否含有该uuid
-//  @param listMap
-//  @param uid0 获取list的key
-//  @param uid1 list需要检验的uuid
-//  @return bool list是否含有该uuid
-//
-func isInList(listMap map[utils.UUID]*list.List, uid0, uid1 utils.UUID) bool {
-	// 检验uid0是否存在，如果不存在说明，不含uid1，返回false
-	if _, ok := listMap[uid0]; ok == false {
-		return false
-	}
-	l := listMap[uid0]
-	// 迭代器遍历list，检验是否含有uid1
-	e := l.Front()
-	for e != nil {
-		uid := e.Value.(utils.UUID)
-		if uid == uid1 {
-			return true
-		}
-		e = e.Next()
-	}
-	return false
-}
-
 func putIntoList(listMap map[utils.UUID]*list.List, uid0, uid1 utils.UUID) {
 	if _, ok := listMap[uid0]; ok == false {
 		listMap[uid0] = new(list.List)
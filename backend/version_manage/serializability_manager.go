@@ -7,8 +7,8 @@ package version_manage
 
 import (
 	"errors"
-	tm "fansDB/backend/Transaction_manage"
 	dm "fansDB/backend/data_manage"
+	tm "fansDB/backend/transaction_manage"
 	"fansDB/backend/utils"
 	"fansDB/backend/utils/cacher"
 	"fansDB/backend/version_manage/locktable"
@@ -23,10 +23,20 @@ var (
 type SerializabilityManager interface {
 	// Read 在事务内中读取uuid内容，
 	Read(TransactionID tm.TransactionID, uuid utils.UUID) ([]byte, bool, error)
+	// ReadVersion 读取uuid的内容, 不检查其可见性
+	ReadVersion(uuid utils.UUID) ([]byte, bool, error)
+	// IsDead 判断uuid这个版本是否已经对所有事务都不可见, 用于vacuum
+	IsDead(uuid utils.UUID) (bool, error)
+	// Conflict 判断事务插入的新版本是否与uuid这个版本冲突, 用于唯一约束
+	Conflict(TransactionID tm.TransactionID, uuid utils.UUID) (bool, error)
 	// Insert 在事务中添加
 	Insert(TransactionID tm.TransactionID, data []byte) (utils.UUID, error)
+	// Own 使事务占用它自己插入的uuid直到结束, 用于唯一约束, 见Conflict
+	Own(TransactionID tm.TransactionID, uuid utils.UUID) error
 	// Delete 在事务中删除uuid内容
 	Delete(TransactionID tm.TransactionID, uuid utils.UUID) (bool, error)
+	// UnDelete 撤销事务自己对uuid的删除, 用于语句级的回滚
+	UnDelete(TransactionID tm.TransactionID, uuid utils.UUID) error
 	// Begin 启动一个事务
 	Begin(level int) tm.TransactionID
 	// Commit 提交一个事务
@@ -91,7 +101,30 @@ func (sm *serializabilityManager) Insert(transactionID tm.TransactionID, data []
 	//创建entry
 	raw := WrapEntryRaw(transactionID, data)
 	//添加
-	return sm.DataManager.Insert(transactionID, raw)
+	uuid, err := sm.DataManager.Insert(transactionID, raw)
+	if err != nil {
+		return utils.NilUUID, err
+	}
+	return uuid, nil
+}
+
+/*
+	Own 使transactionID在锁表中占用它自己插入的uuid, 直到它结束.
+	这样检查唯一约束的其他事务找到uuid时, 可以在锁表中等待该事务结束, 见Conflict.
+	只有可能被其他事务通过唯一的字段找到的版本才需要被占用, 调用者需在其他事务能找到uuid之前调用,
+	此时uuid不可能被其他事务占用, 所以不会等待.
+*/
+func (sm *serializabilityManager) Own(transactionID tm.TransactionID, uuid utils.UUID) error {
+	sm.lock.Lock()
+	t := sm.transactionCacher[transactionID]
+	sm.lock.Unlock()
+
+	if t.Err != nil {
+		return t.Err
+	}
+	_, ch := sm.lockTable.Add(utils.UUID(transactionID), uuid)
+	<-ch
+	return nil
 }
 
 // Commit 提交一个事务
@@ -108,8 +141,9 @@ func (sm *serializabilityManager) Commit(transactionID tm.TransactionID) error {
 	delete(sm.transactionCacher, transactionID)
 	sm.lock.Unlock()
 
-	sm.lockTable.Remove(utils.UUID(transactionID))
+	// 先更新事务的状态, 再释放它占用的uuid, 这样被唤醒的事务能看到它已经提交, 见Conflict
 	sm.TransactionManager.Commit(transactionID)
+	sm.lockTable.Remove(utils.UUID(transactionID))
 	return nil
 }
 
@@ -143,6 +177,126 @@ func (sm *serializabilityManager) Read(transactionID tm.TransactionID, uuid util
 	}
 }
 
+// ReadVersion 读取uuid这个版本的内容, 不论它对哪些事务可见.
+// 用于为已有的entry建立索引, 索引中需要包含entry的所有版本.
+func (sm *serializabilityManager) ReadVersion(uuid utils.UUID) ([]byte, bool, error) {
+	handle, err := sm.entryCacher.Get(uuid)
+	if err == ErrNilEntry {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	e := handle.(*entry)
+	defer e.Release()
+	return e.Data(), true, nil
+}
+
+/*
+	IsDead 判断uuid这个版本是否已经对所有事务, 包括之后开始的事务, 都不可见:
+	由被回滚的事务插入的版本, 或被已提交的事务删除, 且该删除对所有活跃的可重复读事务都可见的版本.
+	读提交的事务总能看到已提交的删除. 之后开始的事务的快照中不会包含已提交的事务.
+*/
+func (sm *serializabilityManager) IsDead(uuid utils.UUID) (bool, error) {
+	handle, err := sm.entryCacher.Get(uuid)
+	if err == ErrNilEntry {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	e := handle.(*entry)
+	defer e.Release()
+
+	if sm.TransactionManager.IsAborted(e.XMIN()) {
+		return true, nil
+	}
+	xmax := e.XMAX()
+	if xmax == 0 || sm.TransactionManager.IsCommitted(xmax) == false {
+		return false, nil
+	}
+
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	for _, t := range sm.transactionCacher {
+		if t.Level != 0 && (xmax > t.TransactionID || t.InSnapShot(xmax)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+/*
+	Conflict 判断transactionID插入的新版本是否与uuid这个版本冲突, 即uuid在该事务提交后是否可能仍然存在:
+	被该事务自己插入且未被自己删除的版本, 或由已提交的事务插入, 且没有被已提交的事务或该事务删除的版本.
+	被回滚的事务插入的版本不会冲突.
+	如果uuid的状态取决于一个活跃的事务, 即它被活跃的事务插入或删除, 则和Delete一样在锁表中等待该事务结束,
+	如果会造成死锁, 则自动撤销该事务.
+*/
+func (sm *serializabilityManager) Conflict(transactionID tm.TransactionID, uuid utils.UUID) (bool, error) {
+	sm.lock.Lock()
+	t := sm.transactionCacher[transactionID]
+	sm.lock.Unlock()
+
+	if t.Err != nil {
+		return false, t.Err
+	}
+
+	handle, err := sm.entryCacher.Get(uuid)
+	if err == ErrNilEntry {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	e := handle.(*entry)
+	defer e.Release()
+
+	for waited := false; ; waited = true {
+		conflict, pending := sm.conflict(transactionID, e)
+		if pending == false {
+			return conflict, nil
+		}
+		if waited { // 等待之后仍不确定, 保守地认为冲突
+			return true, nil
+		}
+
+		ok, ch := sm.lockTable.Add(utils.UUID(transactionID), uuid)
+		if ok == false {
+			t.Err = ErrCannotSR
+			sm.abort(transactionID, true) // 自动撤销
+			t.AutoAbortted = true
+			return false, t.Err
+		}
+		<-ch
+	}
+}
+
+// conflict 判断e是否与transactionID插入的新版本冲突, pending为true时表示e的状态取决于一个活跃的事务.
+func (sm *serializabilityManager) conflict(transactionID tm.TransactionID, e *entry) (conflict, pending bool) {
+	xmin := e.XMIN()
+	xmax := e.XMAX()
+	if xmax == transactionID {
+		return false, false
+	}
+	if xmin == transactionID {
+		return true, false
+	}
+	if sm.TransactionManager.IsAborted(xmin) {
+		return false, false
+	}
+	if sm.TransactionManager.IsActive(xmin) {
+		return false, true
+	}
+	if xmax == 0 || sm.TransactionManager.IsAborted(xmax) {
+		return true, false
+	}
+	if sm.TransactionManager.IsCommitted(xmax) {
+		return false, false
+	}
+	return false, true
+}
+
 func (sm *serializabilityManager) Delete(transactionID tm.TransactionID, uuid utils.UUID) (bool, error) {
 	sm.lock.Lock()
 	t := sm.transactionCacher[transactionID]
@@ -197,6 +351,36 @@ func (sm *serializabilityManager) Delete(transactionID tm.TransactionID, uuid ut
 	return true, nil
 }
 
+/*
+	UnDelete 撤销事务对uuid的删除, 使其重新对该事务可见.
+	只有该事务自己删除的版本才会被恢复, 事务在删除时占用了uuid, 直到结束都不会释放,
+	所以此时没有其他事务能删除它, 恢复后其他事务也可以继续等待该事务结束.
+*/
+func (sm *serializabilityManager) UnDelete(transactionID tm.TransactionID, uuid utils.UUID) error {
+	sm.lock.Lock()
+	t := sm.transactionCacher[transactionID]
+	sm.lock.Unlock()
+
+	if t.Err != nil {
+		return t.Err
+	}
+
+	handle, err := sm.entryCacher.Get(uuid)
+	if err == ErrNilEntry {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	e := handle.(*entry)
+	defer e.Release()
+
+	if e.XMAX() == transactionID {
+		e.ClearXMAX(transactionID)
+	}
+	return nil
+}
+
 func (sm *serializabilityManager) abort(transactionID tm.TransactionID, auto bool) {
 	sm.lock.Lock()
 	t := sm.transactionCacher[transactionID]
@@ -209,8 +393,8 @@ func (sm *serializabilityManager) abort(transactionID tm.TransactionID, auto boo
 		return
 	}
 
-	sm.lockTable.Remove(utils.UUID(transactionID))
 	sm.TransactionManager.Abort(transactionID)
+	sm.lockTable.Remove(utils.UUID(transactionID))
 }
 
 func (sm *serializabilityManager) Abort(transactionID tm.TransactionID) {
@@ -15,7 +15,7 @@ import (
 	tm "fansDB/backend/transaction_manage"
 	"fansDB/backend/utils"
 	sm "fansDB/backend/version_manage"
-	"fansDb/transporter"
+	"fansDB/transporter"
 	"flag"
 	"net"
 	"strings"
@@ -1,6 +1,6 @@
 package version_manage
 
-import tm "fansDB/backend/Transaction_manage"
+import tm "fansDB/backend/transaction_manage"
 
 // 可见性相关函数，用于判断一个事务是否对另一个事务可见的。
 
@@ -24,7 +24,6 @@ func IsVisible(tm tm.TransactionManager, t *tm.Transaction, e *entry) bool {
 		// 可重复读
 		return repeatableRead(tm, t, e)
 	}
-	return false
 }
 
 // readCommitted 提交检验entry是否对事务t可见
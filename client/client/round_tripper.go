@@ -5,7 +5,7 @@
  */
 package client
 
-import "fansDb/transporter"
+import "fansDB/transporter"
 
 type RoundTripper interface {
 	RoundTrip(pkg transporter.Package) (transporter.Package, error)
@@ -1,3 +1,3 @@
-module fansDb
+module fansDB
 
 go 1.16
\ No newline at end of file